@@ -4,24 +4,69 @@ package agent
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
 )
 
-// Agent discovers node capabilities and applies them as labels.
+// FieldManager identifies the agent's changes to Node objects.
+const FieldManager = "flexinfer-agent"
+
+// Options configure an Agent.
+type Options struct {
+	// LabelPrefix prefixes every label and annotation the agent manages.
+	LabelPrefix string
+	// HostRoot is where the host filesystem is mounted; hardware information
+	// is read under it.
+	HostRoot string
+	// Telemetry reads GPU utilization and health. Nil disables it.
+	Telemetry metrics.Collector
+	// UtilizationWindow is the time constant of the smoothed GPU utilization.
+	// Zero means DefaultUtilizationWindow.
+	UtilizationWindow time.Duration
+	// PriceTable is the path of a YAML PriceTable, re-read on every report so
+	// a mounted ConfigMap can be updated in place. Empty disables the cost
+	// annotation.
+	PriceTable string
+}
+
+// Agent discovers node capabilities and applies them as labels, and publishes
+// live usage as annotations.
 type Agent struct {
 	kubeClient  kubernetes.Interface
 	nodeName    string
 	labelPrefix string
+	probe       *hwprobe.Prober
+	telemetry   metrics.Collector
+	priceTable  string
+
+	// gpu and gpuCount describe the primary GPUs found by the last probe,
+	// gpus all of them in topology order, and topology how they are
+	// connected.
+	gpu         hwprobe.GPU
+	gpuCount    int
+	gpus        []hwprobe.GPU
+	topology    hwprobe.Topology
+	utilization ewma
 }
 
-// NewAgent creates a new Agent.
-func NewAgent(labelPrefix string) (*Agent, error) {
+// NewAgent creates a new Agent for the node named by the NODE_NAME
+// environment variable.
+func NewAgent(opts Options) (*Agent, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
@@ -36,10 +81,18 @@ func NewAgent(labelPrefix string) (*Agent, error) {
 		return nil, fmt.Errorf("NODE_NAME environment variable not set")
 	}
 
+	window := opts.UtilizationWindow
+	if window == 0 {
+		window = DefaultUtilizationWindow
+	}
 	return &Agent{
 		kubeClient:  clientset,
 		nodeName:    nodeName,
-		labelPrefix: labelPrefix,
+		labelPrefix: opts.LabelPrefix,
+		probe:       hwprobe.New(opts.HostRoot),
+		telemetry:   opts.Telemetry,
+		priceTable:  opts.PriceTable,
+		utilization: ewma{window: window},
 	}, nil
 }
 
@@ -49,72 +102,184 @@ func (a *Agent) ProbeAndLabel(ctx context.Context) error {
 	log.Info("Probing for hardware capabilities...")
 
 	labels := make(map[string]string)
-	a.detectGPU(labels)
-	a.detectCPU(labels)
+	a.detectGPU(ctx, labels)
+	a.detectCPU(ctx, labels)
 
 	log.Info("Applying labels", "labels", labels)
 
-	node, err := a.kubeClient.CoreV1().Nodes().Get(ctx, a.nodeName, metav1.GetOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to get node %s: %w", a.nodeName, err)
+	if err := a.applyLabels(ctx, labels); err != nil {
+		return err
 	}
 
-	// Merge new labels with existing labels
-	if node.Labels == nil {
-		node.Labels = make(map[string]string)
-	}
+	log.Info("Successfully applied labels to node.")
+	return nil
+}
+
+// applyLabels makes the node's labels under labelPrefix exactly labels,
+// leaving every other label alone.
+func (a *Agent) applyLabels(ctx context.Context, labels map[string]string) error {
+	return a.patchNode(ctx, func(node *corev1.Node) ([]byte, error) {
+		return labelPatch(node, a.labelPrefix, labels)
+	})
+}
+
+// patchNode applies the strategic merge patch that patch computes from the
+// current node, if any. The patch carries the resourceVersion it was computed
+// from, so a concurrent change makes it fail with a conflict and it is
+// recomputed against the fresh node.
+func (a *Agent) patchNode(ctx context.Context, patch func(node *corev1.Node) ([]byte, error)) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := a.kubeClient.CoreV1().Nodes().Get(ctx, a.nodeName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get node %s: %w", a.nodeName, err)
+		}
+		p, err := patch(node)
+		if err != nil || p == nil {
+			return err
+		}
+		_, err = a.kubeClient.CoreV1().Nodes().Patch(ctx, a.nodeName, types.StrategicMergePatchType, p,
+			metav1.PatchOptions{FieldManager: FieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to patch node %s: %w", a.nodeName, err)
+		}
+		return nil
+	})
+}
+
+// labelPatch returns a strategic merge patch that sets labels on node and
+// removes the labels under prefix it no longer reports, or nil if the node is
+// already up to date.
+func labelPatch(node *corev1.Node, prefix string, labels map[string]string) ([]byte, error) {
+	changes := map[string]*string{}
 	for k, v := range labels {
-		node.Labels[k] = v
+		if cur, ok := node.Labels[k]; !ok || cur != v {
+			v := v
+			changes[k] = &v
+		}
 	}
-
-	_, err = a.kubeClient.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to update node %s: %w", a.nodeName, err)
+	for k := range node.Labels {
+		if _, ok := labels[k]; !ok && strings.HasPrefix(k, prefix) {
+			changes[k] = nil // null deletes the key
+		}
 	}
+	return metadataPatch(node, "labels", changes)
+}
 
-	log.Info("Successfully applied labels to node.")
-	return nil
+// metadataPatch returns a strategic merge patch applying changes to the
+// node's labels or annotations, or nil if there are none.
+func metadataPatch(node *corev1.Node, field string, changes map[string]*string) ([]byte, error) {
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(map[string]any{
+		"metadata": map[string]any{
+			field:             changes,
+			"resourceVersion": node.ResourceVersion,
+		},
+	})
 }
 
-// detectGPU populates the label map with GPU-related features.
-func (a *Agent) detectGPU(labels map[string]string) {
-	vendor := os.Getenv("GPU_VENDOR")
+// detectGPU populates the label map with GPU-related features. The
+// GPU_VENDOR, GPU_VRAM, GPU_ARCH, GPU_INT4 and GPU_COUNT environment variables
+// override what the probe finds. Nodes without a GPU get no gpu.* labels.
+func (a *Agent) detectGPU(ctx context.Context, labels map[string]string) {
+	var primary hwprobe.GPU
+	count := 0
+	if a.probe != nil {
+		gpus, err := a.probe.GPUs(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed to probe GPUs")
+		}
+		primary, count = hwprobe.Primary(gpus)
+		a.gpus = gpus
+		a.topology = a.probe.Topology(ctx, gpus)
+	}
+	a.gpu, a.gpuCount = primary, count
+
+	vendor := envOr("GPU_VENDOR", primary.Vendor)
 	if vendor == "" {
-		vendor = "NVIDIA"
+		return
+	}
+	if count == 0 {
+		count = 1
 	}
+	var vram string
+	if primary.VRAMBytes > 0 {
+		vram = hwprobe.FormatVRAM(primary.VRAMBytes)
+	}
+
+	setLabel(labels, a.labelPrefix+"gpu.vendor", vendor)
+	setLabel(labels, a.labelPrefix+"gpu.vram", envOr("GPU_VRAM", vram))
+	setLabel(labels, a.labelPrefix+"gpu.arch", envOr("GPU_ARCH", primary.Arch))
+	setLabel(labels, a.labelPrefix+"gpu.int4", envOr("GPU_INT4", strconv.FormatBool(int4Support(primary))))
+	setLabel(labels, a.labelPrefix+"gpu.count", envOr("GPU_COUNT", strconv.Itoa(count)))
+}
 
-	vram := os.Getenv("GPU_VRAM")
-	if vram == "" {
-		vram = "24Gi"
+// detectCPU populates the label map with CPU-related features. Each label can
+// be overridden by an environment variable named after it, e.g. CPU_AVX512 for
+// cpu.avx512.
+func (a *Agent) detectCPU(ctx context.Context, labels map[string]string) {
+	var cpu hwprobe.CPU
+	if a.probe != nil {
+		var err error
+		if cpu, err = a.probe.CPU(); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to probe CPU")
+		}
 	}
 
-	arch := os.Getenv("GPU_ARCH")
-	if arch == "" {
-		arch = "sm_89"
+	features := []struct {
+		name  string
+		value string
+	}{
+		{"vendor", cpu.Vendor},
+		{"avx2", strconv.FormatBool(cpu.AVX2())},
+		{"avx512", strconv.FormatBool(cpu.AVX512())},
+		{"avx512vnni", strconv.FormatBool(cpu.AVX512VNNI())},
+		{"avx512bf16", strconv.FormatBool(cpu.AVX512BF16())},
+		{"amx", strconv.FormatBool(cpu.AMX())},
+		{"neon", strconv.FormatBool(cpu.NEON())},
+		{"sve", strconv.FormatBool(cpu.SVE())},
+		{"cores", countLabel(cpu.Cores)},
+		{"numa", countLabel(cpu.NUMANodes)},
+		{"membw", cpu.MemoryBandwidth()},
+	}
+	for _, f := range features {
+		setLabel(labels, a.labelPrefix+"cpu."+f.name, envOr("CPU_"+strings.ToUpper(f.name), f.value))
 	}
+}
 
-	int4 := os.Getenv("GPU_INT4")
-	if int4 == "" {
-		int4 = "true"
+// countLabel formats a count, leaving unknown (zero) counts out.
+func countLabel(n int) string {
+	if n == 0 {
+		return ""
 	}
+	return strconv.Itoa(n)
+}
 
-	count := os.Getenv("GPU_COUNT")
-	if count == "" {
-		count = "1"
+// int4Support reports whether g has INT4 tensor/matrix instructions: NVIDIA
+// Turing through Ada, and AMD CDNA 1 and 2.
+func int4Support(g hwprobe.GPU) bool {
+	switch g.Vendor {
+	case hwprobe.VendorNVIDIA:
+		sm, err := strconv.Atoi(strings.TrimPrefix(g.Arch, "sm_"))
+		return err == nil && sm >= 75 && sm <= 89
+	case hwprobe.VendorAMD:
+		return g.Arch == "gfx908" || g.Arch == "gfx90a"
 	}
+	return false
+}
 
-	labels[a.labelPrefix+"gpu.vendor"] = vendor
-	labels[a.labelPrefix+"gpu.vram"] = vram
-	labels[a.labelPrefix+"gpu.arch"] = arch
-	labels[a.labelPrefix+"gpu.int4"] = int4
-	labels[a.labelPrefix+"gpu.count"] = count
+// envOr returns the value of the environment variable key, or def if unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
 }
 
-// detectCPU populates the label map with CPU-related features.
-func (a *Agent) detectCPU(labels map[string]string) {
-	avx := os.Getenv("CPU_AVX512")
-	if avx == "" {
-		avx = "false"
+// setLabel sets key to value, leaving unknown (empty) values out.
+func setLabel(labels map[string]string, key, value string) {
+	if value != "" {
+		labels[key] = value
 	}
-	labels[a.labelPrefix+"cpu.avx512"] = avx
 }
@@ -1,21 +1,60 @@
 package agent
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
 )
 
+// probeFixture returns a Prober over a node with two NVIDIA GPUs and
+// nvidia-smi installed.
+func probeFixture(t *testing.T) *hwprobe.Prober {
+	root := t.TempDir()
+	for _, addr := range []string{"0000:01:00.0", "0000:02:00.0"} {
+		dir := filepath.Join(root, "sys/bus/pci/devices", addr)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "vendor"), []byte("0x10de\n"), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "class"), []byte("0x030200\n"), 0o644))
+	}
+	smi := "00000000:01:00.0, NVIDIA A100-SXM4-80GB, 81920, 8.0\n00000000:02:00.0, NVIDIA A100-SXM4-80GB, 81920, 8.0\n"
+	return &hwprobe.Prober{Root: root, Run: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return []byte(smi), nil
+	}}
+}
+
 func TestDetectGPU(t *testing.T) {
-	agent := &Agent{labelPrefix: "flexinfer.ai/"}
+	agent := &Agent{labelPrefix: "flexinfer.ai/", probe: probeFixture(t)}
 	labels := make(map[string]string)
-	agent.detectGPU(labels)
+	agent.detectGPU(context.Background(), labels)
 
 	assert.Equal(t, "NVIDIA", labels["flexinfer.ai/gpu.vendor"])
-	assert.Equal(t, "24Gi", labels["flexinfer.ai/gpu.vram"])
-	assert.Equal(t, "sm_89", labels["flexinfer.ai/gpu.arch"])
+	assert.Equal(t, "80Gi", labels["flexinfer.ai/gpu.vram"])
+	assert.Equal(t, "sm_80", labels["flexinfer.ai/gpu.arch"])
 	assert.Equal(t, "true", labels["flexinfer.ai/gpu.int4"])
-	assert.Equal(t, "1", labels["flexinfer.ai/gpu.count"])
+	assert.Equal(t, "2", labels["flexinfer.ai/gpu.count"])
+}
+
+func TestDetectGPUNone(t *testing.T) {
+	agent := &Agent{labelPrefix: "flexinfer.ai/", probe: &hwprobe.Prober{Root: t.TempDir()}}
+	labels := make(map[string]string)
+	agent.detectGPU(context.Background(), labels)
+
+	assert.Empty(t, labels)
 }
 
 func TestDetectGPUEnvOverride(t *testing.T) {
@@ -25,9 +64,9 @@ func TestDetectGPUEnvOverride(t *testing.T) {
 	t.Setenv("GPU_INT4", "false")
 	t.Setenv("GPU_COUNT", "4")
 
-	agent := &Agent{labelPrefix: "flexinfer.ai/"}
+	agent := &Agent{labelPrefix: "flexinfer.ai/", probe: probeFixture(t)}
 	labels := make(map[string]string)
-	agent.detectGPU(labels)
+	agent.detectGPU(context.Background(), labels)
 
 	assert.Equal(t, "AMD", labels["flexinfer.ai/gpu.vendor"])
 	assert.Equal(t, "16Gi", labels["flexinfer.ai/gpu.vram"])
@@ -37,18 +76,96 @@ func TestDetectGPUEnvOverride(t *testing.T) {
 }
 
 func TestDetectCPU(t *testing.T) {
-	agent := &Agent{labelPrefix: "flexinfer.ai/"}
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "proc/cpuinfo"), []byte(
+		"processor\t: 0\nvendor_id\t: AuthenticAMD\nphysical id\t: 0\ncore id\t\t: 0\nflags\t\t: fpu sse2 avx2 avx512f avx512_vnni avx512_bf16\n\n"+
+			"processor\t: 1\nvendor_id\t: AuthenticAMD\nphysical id\t: 0\ncore id\t\t: 1\nflags\t\t: fpu sse2 avx2 avx512f avx512_vnni avx512_bf16\n"), 0o644))
+
+	agent := &Agent{labelPrefix: "flexinfer.ai/", probe: &hwprobe.Prober{Root: root}}
 	labels := make(map[string]string)
-	agent.detectCPU(labels)
+	agent.detectCPU(context.Background(), labels)
 
-	assert.Equal(t, "false", labels["flexinfer.ai/cpu.avx512"])
+	assert.Equal(t, "amd", labels["flexinfer.ai/cpu.vendor"])
+	assert.Equal(t, "true", labels["flexinfer.ai/cpu.avx2"])
+	assert.Equal(t, "true", labels["flexinfer.ai/cpu.avx512"])
+	assert.Equal(t, "true", labels["flexinfer.ai/cpu.avx512vnni"])
+	assert.Equal(t, "true", labels["flexinfer.ai/cpu.avx512bf16"])
+	assert.Equal(t, "false", labels["flexinfer.ai/cpu.amx"])
+	assert.Equal(t, "false", labels["flexinfer.ai/cpu.neon"])
+	assert.Equal(t, "2", labels["flexinfer.ai/cpu.cores"])
+	assert.Equal(t, "1", labels["flexinfer.ai/cpu.numa"])
+	assert.Equal(t, "medium", labels["flexinfer.ai/cpu.membw"])
 }
 
 func TestDetectCPUEnvOverride(t *testing.T) {
 	t.Setenv("CPU_AVX512", "true")
-	agent := &Agent{labelPrefix: "flexinfer.ai/"}
+	t.Setenv("CPU_MEMBW", "high")
+	agent := &Agent{labelPrefix: "flexinfer.ai/", probe: &hwprobe.Prober{Root: t.TempDir()}}
 	labels := make(map[string]string)
-	agent.detectCPU(labels)
+	agent.detectCPU(context.Background(), labels)
 
 	assert.Equal(t, "true", labels["flexinfer.ai/cpu.avx512"])
+	assert.Equal(t, "false", labels["flexinfer.ai/cpu.avx2"])
+	assert.Equal(t, "high", labels["flexinfer.ai/cpu.membw"])
+}
+
+func TestApplyLabels(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node1",
+		Labels: map[string]string{
+			"kubernetes.io/hostname":  "node1",
+			"flexinfer.ai/gpu.vendor": "NVIDIA",
+			"flexinfer.ai/gpu.count":  "1",
+			"flexinfer.ai/cpu.avx2":   "true",
+		},
+	}})
+	agent := &Agent{kubeClient: clientset, nodeName: "node1", labelPrefix: "flexinfer.ai/"}
+
+	require.NoError(t, agent.applyLabels(context.Background(), map[string]string{
+		"flexinfer.ai/cpu.avx2":  "true",
+		"flexinfer.ai/cpu.cores": "8",
+	}))
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"kubernetes.io/hostname": "node1",
+		"flexinfer.ai/cpu.avx2":  "true",
+		"flexinfer.ai/cpu.cores": "8",
+	}, node.Labels)
+}
+
+func TestApplyLabelsRetriesOnConflict(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}})
+	patches := 0
+	clientset.PrependReactor("patch", "nodes", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patches++
+		if patches == 1 {
+			return true, nil, apierrors.NewConflict(schema.GroupResource{Resource: "nodes"}, "node1", errors.New("modified"))
+		}
+		assert.Equal(t, types.StrategicMergePatchType, action.(k8stesting.PatchAction).GetPatchType())
+		return false, nil, nil
+	})
+	agent := &Agent{kubeClient: clientset, nodeName: "node1", labelPrefix: "flexinfer.ai/"}
+
+	require.NoError(t, agent.applyLabels(context.Background(), map[string]string{"flexinfer.ai/cpu.cores": "8"}))
+	assert.Equal(t, 2, patches)
+
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "8", node.Labels["flexinfer.ai/cpu.cores"])
+}
+
+func TestApplyLabelsUnchanged(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node1",
+		Labels: map[string]string{"flexinfer.ai/cpu.cores": "8"},
+	}})
+	agent := &Agent{kubeClient: clientset, nodeName: "node1", labelPrefix: "flexinfer.ai/"}
+
+	require.NoError(t, agent.applyLabels(context.Background(), map[string]string{"flexinfer.ai/cpu.cores": "8"}))
+	for _, action := range clientset.Actions() {
+		assert.NotEqual(t, "patch", action.GetVerb())
+	}
 }
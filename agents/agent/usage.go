@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// DefaultUtilizationWindow is the default time constant of the smoothed GPU
+// utilization.
+const DefaultUtilizationWindow = 5 * time.Minute
+
+// busyMemoryFraction is the share of its memory in use above which a GPU is
+// reported busy. Drivers and monitoring alone use far less.
+const busyMemoryFraction = 0.05
+
+// PriceTable holds hourly node prices for the cost annotation. Prices only
+// need to be consistent with each other, so any currency works.
+type PriceTable struct {
+	// InstanceTypes maps a node.kubernetes.io/instance-type value to the
+	// price of the whole node.
+	InstanceTypes map[string]float64 `yaml:"instanceTypes"`
+	// GPUModels maps a GPU name, as reported by vendor tooling (e.g.
+	// "NVIDIA A100-SXM4-80GB"), to the price of one GPU. It prices nodes
+	// whose instance type is unknown, such as on-prem ones.
+	GPUModels map[string]float64 `yaml:"gpuModels"`
+}
+
+// LoadPriceTable reads a YAML price table.
+func LoadPriceTable(path string) (*PriceTable, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t PriceTable
+	if err := yaml.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("failed to parse price table %s: %w", path, err)
+	}
+	return &t, nil
+}
+
+// Cost returns the hourly price of a node, preferring its instance type over
+// its GPUs.
+func (t *PriceTable) Cost(instanceType, gpuModel string, gpus int) (float64, bool) {
+	if price, ok := t.InstanceTypes[instanceType]; ok && instanceType != "" {
+		return price, true
+	}
+	if price, ok := t.GPUModels[gpuModel]; ok && gpuModel != "" && gpus > 0 {
+		return price * float64(gpus), true
+	}
+	return 0, false
+}
+
+// ewma is an exponentially weighted moving average over irregularly spaced
+// samples: a sample's weight decays with time, not with the number of samples
+// after it.
+type ewma struct {
+	window time.Duration
+	value  float64
+	last   time.Time
+}
+
+// add folds in a sample taken at now and returns the new average.
+func (e *ewma) add(v float64, now time.Time) float64 {
+	if e.last.IsZero() {
+		e.value = v
+	} else {
+		alpha := 1 - math.Exp(-float64(now.Sub(e.last))/float64(e.window))
+		e.value += alpha * (v - e.value)
+	}
+	e.last = now
+	return e.value
+}
+
+// reset forgets every sample.
+func (e *ewma) reset() {
+	e.value, e.last = 0, time.Time{}
+}
+
+// ReportUsage collects GPU telemetry, exports it as metrics and publishes
+// what the scheduler scores nodes by as annotations: the node's smoothed GPU
+// utilization (gpu.util), the GPUs in use (gpu.busy), how the GPUs are
+// connected (gpu.topology) and its hourly cost (cost).
+func (a *Agent) ReportUsage(ctx context.Context) error {
+	log := log.FromContext(ctx)
+
+	annotations := map[string]*string{a.labelPrefix + "gpu.topology": nil}
+	if a.topology != nil {
+		topology := a.topology.String()
+		annotations[a.labelPrefix+"gpu.topology"] = &topology
+	}
+	if a.telemetry != nil {
+		stats, err := a.telemetry.Collect(ctx)
+		if err != nil {
+			// Keep publishing the last known utilization.
+			log.Error(err, "Failed to collect GPU telemetry", "collector", a.telemetry.Name())
+		} else {
+			annotations[a.labelPrefix+"gpu.util"] = a.smoothUtilization(stats, time.Now())
+			annotations[a.labelPrefix+"gpu.busy"] = busyGPUs(stats, a.gpus)
+			metrics.RecordGPUStats(a.nodeName, stats)
+		}
+	}
+
+	var prices *PriceTable
+	if a.priceTable != "" {
+		var err error
+		if prices, err = LoadPriceTable(a.priceTable); err != nil {
+			log.Error(err, "Failed to load price table")
+		}
+	}
+
+	return a.patchNode(ctx, func(node *corev1.Node) ([]byte, error) {
+		if prices != nil {
+			annotations[a.labelPrefix+"cost"] = a.cost(node, prices)
+		}
+		changes := map[string]*string{}
+		for k, v := range annotations {
+			cur, ok := node.Annotations[k]
+			if (v == nil && ok) || (v != nil && (!ok || cur != *v)) {
+				changes[k] = v
+			}
+		}
+		return metadataPatch(node, "annotations", changes)
+	})
+}
+
+// smoothUtilization folds the mean utilization of the GPUs in stats into the
+// moving average and returns it formatted for the annotation, or nil if no
+// GPU reports its utilization.
+func (a *Agent) smoothUtilization(stats []metrics.GPUStats, now time.Time) *string {
+	sum, n := 0.0, 0
+	for _, s := range stats {
+		if !math.IsNaN(s.UtilizationPercent) {
+			sum += s.UtilizationPercent
+			n++
+		}
+	}
+	if n == 0 {
+		a.utilization.reset()
+		return nil
+	}
+	v := strconv.FormatFloat(a.utilization.add(sum/float64(n), now), 'f', 1, 64)
+	return &v
+}
+
+// busyGPUs returns the GPUs in stats that hold models, going by their
+// memory, formatted for the annotation, or nil if none do. GPUs are numbered
+// as in the topology, by their index in gpus when the collector reports their
+// PCI address: DRM card numbers also count display adapters that are not.
+func busyGPUs(stats []metrics.GPUStats, gpus []hwprobe.GPU) *string {
+	var busy []string
+	for _, s := range stats {
+		if !(s.MemoryUsedBytes > s.MemoryTotalBytes*busyMemoryFraction) {
+			continue
+		}
+		id := s.GPU
+		if s.PCIAddress != "" {
+			i := hwprobe.IndexOf(gpus, s.PCIAddress)
+			if i < 0 {
+				continue
+			}
+			id = strconv.Itoa(i)
+		}
+		busy = append(busy, id)
+	}
+	if len(busy) == 0 {
+		return nil
+	}
+	sort.Strings(busy)
+	v := strings.Join(busy, ",")
+	return &v
+}
+
+// cost returns the node's hourly price formatted for the annotation, or nil
+// if the price table does not list it.
+func (a *Agent) cost(node *corev1.Node, prices *PriceTable) *string {
+	instanceType := node.Labels[corev1.LabelInstanceTypeStable]
+	if instanceType == "" {
+		instanceType = node.Labels[corev1.LabelInstanceType]
+	}
+	price, ok := prices.Cost(instanceType, a.gpu.Name, a.gpuCount)
+	if !ok {
+		return nil
+	}
+	v := strconv.FormatFloat(math.Round(price*1e4)/1e4, 'f', -1, 64)
+	return &v
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+func TestEWMA(t *testing.T) {
+	e := ewma{window: time.Minute}
+	start := time.Now()
+
+	assert.Equal(t, 80.0, e.add(80, start), "the first sample is taken as is")
+	assert.InDelta(t, 80-80*0.632, e.add(0, start.Add(time.Minute)), 0.1, "one window decays by 1-1/e")
+	// A longer gap weighs the new sample more.
+	e.reset()
+	e.add(80, start)
+	assert.InDelta(t, 80-80*0.993, e.add(0, start.Add(5*time.Minute)), 0.1)
+}
+
+func TestPriceTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+instanceTypes:
+  g5.xlarge: 1.006
+gpuModels:
+  NVIDIA A100-SXM4-80GB: 3.67
+`), 0o644))
+	prices, err := LoadPriceTable(path)
+	require.NoError(t, err)
+
+	cost, ok := prices.Cost("g5.xlarge", "NVIDIA A10G", 1)
+	assert.True(t, ok)
+	assert.Equal(t, 1.006, cost, "instance types win over GPU models")
+
+	cost, ok = prices.Cost("", "NVIDIA A100-SXM4-80GB", 2)
+	assert.True(t, ok)
+	assert.Equal(t, 7.34, cost)
+
+	_, ok = prices.Cost("m5.large", "", 0)
+	assert.False(t, ok)
+}
+
+func TestReportUsage(t *testing.T) {
+	prices := filepath.Join(t.TempDir(), "prices.yaml")
+	require.NoError(t, os.WriteFile(prices, []byte("gpuModels:\n  NVIDIA A100-SXM4-80GB: 3.67\n"), 0o644))
+	clientset := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node1",
+		Annotations: map[string]string{"example.com/owner": "team-a"},
+	}})
+	busy, idle := metrics.NewGPUStats("0"), metrics.NewGPUStats("1")
+	busy.UtilizationPercent, idle.UtilizationPercent = 90, 10
+	busy.MemoryUsedBytes, idle.MemoryUsedBytes = 40<<30, 500<<20
+	busy.MemoryTotalBytes, idle.MemoryTotalBytes = 80<<30, 80<<30
+	telemetry := &metrics.FakeCollector{Stats: []metrics.GPUStats{busy, idle}}
+	agent := &Agent{
+		kubeClient:  clientset,
+		nodeName:    "node1",
+		labelPrefix: "flexinfer.ai/",
+		telemetry:   telemetry,
+		priceTable:  prices,
+		gpu:         hwprobe.GPU{Vendor: hwprobe.VendorNVIDIA, Name: "NVIDIA A100-SXM4-80GB"},
+		gpuCount:    2,
+		topology:    hwprobe.Topology{{hwprobe.LinkUnknown, hwprobe.NVLinks(12)}, {hwprobe.NVLinks(12), hwprobe.LinkUnknown}},
+		utilization: ewma{window: time.Minute},
+	}
+
+	require.NoError(t, agent.ReportUsage(context.Background()))
+	node, err := clientset.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"example.com/owner":         "team-a",
+		"flexinfer.ai/gpu.util":     "50.0",
+		"flexinfer.ai/gpu.busy":     "0",
+		"flexinfer.ai/gpu.topology": "X,NV12;NV12,X",
+		"flexinfer.ai/cost":         "7.34",
+	}, node.Annotations)
+
+	// A failed collection keeps the last utilization.
+	telemetry.Err = errors.New("connection refused")
+	require.NoError(t, agent.ReportUsage(context.Background()))
+	node, err = clientset.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "50.0", node.Annotations["flexinfer.ai/gpu.util"])
+	assert.Equal(t, 90.0, testutil.ToFloat64(metrics.GPUUtilization.WithLabelValues("0", "node1")), "and its metrics")
+
+	// GPUs that stop reporting utilization drop the annotation.
+	telemetry.Stats, telemetry.Err = nil, nil
+	require.NoError(t, agent.ReportUsage(context.Background()))
+	node, err = clientset.CoreV1().Nodes().Get(context.Background(), "node1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, node.Annotations, "flexinfer.ai/gpu.util")
+	assert.NotContains(t, node.Annotations, "flexinfer.ai/gpu.busy")
+	assert.Equal(t, "team-a", node.Annotations["example.com/owner"])
+}
+
+func TestBusyGPUs(t *testing.T) {
+	// Topology indices follow the PCI order of the GPUs the probe found,
+	// which leaves out the BMC's display adapter at card0.
+	gpus := []hwprobe.GPU{{PCIAddress: "0000:c1:00.0"}, {PCIAddress: "0000:c2:00.0"}}
+	busy := func(gpu, addr string) metrics.GPUStats {
+		s := metrics.NewGPUStats(gpu)
+		s.PCIAddress = addr
+		s.MemoryUsedBytes, s.MemoryTotalBytes = 40<<30, 64<<30
+		return s
+	}
+	got := busyGPUs([]metrics.GPUStats{busy("2", "0000:C2:00.0"), busy("3", "0000:c3:00.0")}, gpus)
+	require.NotNil(t, got)
+	assert.Equal(t, "1", *got, "card2 is the second GPU, and unknown devices are left out")
+
+	// Collectors that report no address already number GPUs in PCI order.
+	got = busyGPUs([]metrics.GPUStats{busy("0", "")}, gpus)
+	require.NotNil(t, got)
+	assert.Equal(t, "0", *got)
+}
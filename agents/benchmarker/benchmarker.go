@@ -4,25 +4,124 @@ package benchmarker
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"strconv"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexinfer/flexinfer/pkg/backend"
+)
+
+// Keys of the benchmark results ConfigMap.
+const (
+	KeyTokensPerSecond     = "tokensPerSecond"
+	KeyTimeToFirstTokenMs  = "timeToFirstTokenMs"
+	KeyInterTokenLatencyMs = "interTokenLatencyMs"
+	KeyIterations          = "iterations"
+	KeyModel               = "model"
+	KeyBackend             = "backend"
+	KeyDeviceClass         = "deviceClass"
+	KeyRevision            = "revision"
+	KeyTimestamp           = "timestamp"
 )
 
+// LabelModelDeployment labels the results ConfigMap with the name of the
+// ModelDeployment it belongs to.
+const LabelModelDeployment = "modeldeployment_cr"
+
+// ResultsConfigMapName returns the name of the ConfigMap holding the results
+// for modelDeployment on deviceClass. An empty deviceClass names the results
+// of an unpinned benchmark.
+func ResultsConfigMapName(modelDeployment, deviceClass string) string {
+	if deviceClass == "" {
+		return modelDeployment + "-benchmark-results"
+	}
+	return modelDeployment + "-benchmark-results-" + deviceClass
+}
+
+// DefaultPrompts is the prompt set used when none is configured.
+var DefaultPrompts = []string{
+	"Explain the difference between a process and a thread.",
+	"Write a short poem about the ocean.",
+	"Summarize the plot of Romeo and Juliet in three sentences.",
+	"List five tips for writing readable code.",
+}
+
+// Options configures a benchmark run.
+type Options struct {
+	// ModelDeployment is the name of the ModelDeployment being benchmarked.
+	ModelDeployment string
+	// DeviceClass identifies the hardware the benchmark runs on.
+	DeviceClass string
+	// Backend is the name of the backend driver serving the model.
+	Backend string
+	// Revision identifies the benchmark request the results answer, so the
+	// controller can tell fresh results from ones it has asked to refresh.
+	Revision string
+	// Endpoint is the base URL of the backend to benchmark.
+	Endpoint string
+	// API is the protocol the backend speaks.
+	API backend.API
+	// ReadyPath is polled until the backend answers with a 2xx status.
+	ReadyPath string
+	// Prompts is the prompt set cycled through during the run.
+	Prompts []string
+	// WarmupIterations is the number of unmeasured requests sent before the run.
+	WarmupIterations int
+	// MinDuration is the minimum time spent issuing measured requests.
+	MinDuration time.Duration
+	// MaxTokens caps the number of tokens generated per request.
+	MaxTokens int
+	// ReadyTimeout bounds how long to wait for the backend to come up.
+	ReadyTimeout time.Duration
+	// ReadyPollInterval is the delay between readiness checks.
+	ReadyPollInterval time.Duration
+}
+
+// DefaultOptions returns the options used by the flexinfer-bench command.
+func DefaultOptions() Options {
+	return Options{
+		Endpoint:          "http://localhost:11434",
+		API:               backend.APIOllama,
+		ReadyPath:         "/",
+		Prompts:           DefaultPrompts,
+		WarmupIterations:  2,
+		MinDuration:       30 * time.Second,
+		MaxTokens:         128,
+		ReadyTimeout:      10 * time.Minute,
+		ReadyPollInterval: 2 * time.Second,
+	}
+}
+
+// Result is the aggregated outcome of a benchmark run.
+type Result struct {
+	// TokensPerSecond is the end-to-end generation throughput.
+	TokensPerSecond float64
+	// TimeToFirstToken is the mean latency until the first token arrives.
+	TimeToFirstToken time.Duration
+	// InterTokenLatency is the mean gap between consecutive tokens.
+	InterTokenLatency time.Duration
+	// Iterations is the number of measured requests.
+	Iterations int
+}
+
 // Benchmarker runs benchmarks for a model on a specific device.
 type Benchmarker struct {
 	kubeClient kubernetes.Interface
 	namespace  string
+	httpClient *http.Client
+	opts       Options
 }
 
 // NewBenchmarker creates a new Benchmarker.
-func NewBenchmarker() (*Benchmarker, error) {
+func NewBenchmarker(opts Options) (*Benchmarker, error) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get in-cluster config: %w", err)
@@ -40,20 +139,24 @@ func NewBenchmarker() (*Benchmarker, error) {
 	return &Benchmarker{
 		kubeClient: clientset,
 		namespace:  namespace,
+		httpClient: &http.Client{},
+		opts:       opts,
 	}, nil
 }
 
 // Run executes the benchmark and stores the result in a ConfigMap.
 func (b *Benchmarker) Run(ctx context.Context, model, configMapName string) error {
 	log := log.FromContext(ctx)
-	log.Info("Running benchmark", "model", model)
+	log.Info("Running benchmark", "model", model, "endpoint", b.opts.Endpoint)
 
-	tokensPerSecond, err := b.runBenchmarkSimulation(ctx)
+	result, err := b.runBenchmark(ctx, model)
 	if err != nil {
-		return fmt.Errorf("benchmark simulation failed: %w", err)
+		return fmt.Errorf("benchmark failed: %w", err)
 	}
 
-	log.Info("Benchmark result", "tokensPerSecond", tokensPerSecond)
+	log.Info("Benchmark result", "tokensPerSecond", result.TokensPerSecond,
+		"timeToFirstToken", result.TimeToFirstToken, "interTokenLatency", result.InterTokenLatency,
+		"iterations", result.Iterations)
 
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -61,28 +164,113 @@ func (b *Benchmarker) Run(ctx context.Context, model, configMapName string) erro
 			Namespace: b.namespace,
 		},
 		Data: map[string]string{
-			"tokensPerSecond": strconv.FormatFloat(tokensPerSecond, 'f', -1, 64),
-			"model":           model,
-			"timestamp":       time.Now().Format(time.RFC3339),
+			KeyTokensPerSecond:     strconv.FormatFloat(result.TokensPerSecond, 'f', 2, 64),
+			KeyTimeToFirstTokenMs:  formatMillis(result.TimeToFirstToken),
+			KeyInterTokenLatencyMs: formatMillis(result.InterTokenLatency),
+			KeyIterations:          strconv.Itoa(result.Iterations),
+			KeyModel:               model,
+			KeyTimestamp:           time.Now().Format(time.RFC3339),
 		},
 	}
+	if b.opts.ModelDeployment != "" {
+		cm.Labels = map[string]string{LabelModelDeployment: b.opts.ModelDeployment}
+	}
+	if b.opts.DeviceClass != "" {
+		cm.Data[KeyDeviceClass] = b.opts.DeviceClass
+	}
+	if b.opts.Backend != "" {
+		cm.Data[KeyBackend] = b.opts.Backend
+	}
+	if b.opts.Revision != "" {
+		cm.Data[KeyRevision] = b.opts.Revision
+	}
 
-	log.Info("Creating ConfigMap with benchmark results", "configMap", configMapName)
-	_, err = b.kubeClient.CoreV1().ConfigMaps(b.namespace).Create(ctx, cm, metav1.CreateOptions{})
+	// A re-benchmark replaces the previous results only once it has succeeded.
+	log.Info("Writing ConfigMap with benchmark results", "configMap", configMapName)
+	configMaps := b.kubeClient.CoreV1().ConfigMaps(b.namespace)
+	_, err = configMaps.Create(ctx, cm, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create benchmark result configmap: %w", err)
+		return fmt.Errorf("failed to write benchmark result configmap: %w", err)
 	}
 
 	return nil
 }
 
-// runBenchmarkSimulation simulates a benchmark run.
-func (b *Benchmarker) runBenchmarkSimulation(ctx context.Context) (float64, error) {
+// runBenchmark waits for the backend, warms it up and then issues measured
+// requests until every prompt has run at least once and MinDuration has passed.
+func (b *Benchmarker) runBenchmark(ctx context.Context, model string) (Result, error) {
 	log := log.FromContext(ctx)
-	log.Info("Simulating benchmark...")
-	// Placeholder: In a real implementation, this would involve loading the model
-	// and running actual inference to measure tokens per second.
-	time.Sleep(2 * time.Second) // Simulate work
-	tokensPerSecond := 150.75   // Placeholder value
-	return tokensPerSecond, nil
+
+	if len(b.opts.Prompts) == 0 {
+		return Result{}, fmt.Errorf("no prompts configured")
+	}
+
+	readyCtx, cancel := context.WithTimeout(ctx, b.opts.ReadyTimeout)
+	defer cancel()
+	if err := b.waitForReady(readyCtx); err != nil {
+		return Result{}, err
+	}
+	// Ollama loads models lazily and needs an explicit pull; the other
+	// backends download their model before reporting ready.
+	if b.opts.API == backend.APIOllama {
+		if err := b.pullModel(ctx, model); err != nil {
+			return Result{}, err
+		}
+	}
+
+	for i := 0; i < b.opts.WarmupIterations; i++ {
+		prompt := b.opts.Prompts[i%len(b.opts.Prompts)]
+		if _, err := b.generate(ctx, model, prompt); err != nil {
+			return Result{}, fmt.Errorf("warmup iteration %d: %w", i, err)
+		}
+	}
+	log.Info("Warmup complete", "iterations", b.opts.WarmupIterations)
+
+	var samples []sample
+	start := time.Now()
+	for i := 0; i < len(b.opts.Prompts) || time.Since(start) < b.opts.MinDuration; i++ {
+		prompt := b.opts.Prompts[i%len(b.opts.Prompts)]
+		s, err := b.generate(ctx, model, prompt)
+		if err != nil {
+			return Result{}, fmt.Errorf("iteration %d: %w", i, err)
+		}
+		samples = append(samples, s)
+	}
+
+	return aggregate(samples), nil
+}
+
+// aggregate combines per-request samples into a Result.
+func aggregate(samples []sample) Result {
+	var tokens int
+	var total, ttft, decode time.Duration
+	var gaps int
+	for _, s := range samples {
+		tokens += s.tokens
+		total += s.total
+		ttft += s.ttft
+		if s.tokens > 1 {
+			decode += s.total - s.ttft
+			gaps += s.tokens - 1
+		}
+	}
+
+	r := Result{Iterations: len(samples)}
+	if total > 0 {
+		r.TokensPerSecond = float64(tokens) / total.Seconds()
+	}
+	if len(samples) > 0 {
+		r.TimeToFirstToken = ttft / time.Duration(len(samples))
+	}
+	if gaps > 0 {
+		r.InterTokenLatency = decode / time.Duration(gaps)
+	}
+	return r
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 2, 64)
 }
@@ -2,20 +2,82 @@ package benchmarker
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
-func TestRun(t *testing.T) {
+// fakeBackend emulates the subset of the Ollama API used by the benchmarker.
+type fakeBackend struct {
+	tokens     int
+	tokenDelay time.Duration
+	generates  atomic.Int32
+	pulls      atomic.Int32
+}
+
+func (f *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/":
+		fmt.Fprint(w, "Ollama is running")
+	case "/api/pull":
+		f.pulls.Add(1)
+		fmt.Fprint(w, `{"status":"success"}`)
+	case "/api/generate":
+		f.generates.Add(1)
+		var req generateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flusher := w.(http.Flusher)
+		enc := json.NewEncoder(w)
+		for i := 0; i < f.tokens; i++ {
+			time.Sleep(f.tokenDelay)
+			enc.Encode(generateChunk{Response: "tok "})
+			flusher.Flush()
+		}
+		enc.Encode(generateChunk{Done: true, EvalCount: f.tokens})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestBenchmarker(t *testing.T, backend http.Handler) (*Benchmarker, *fake.Clientset) {
+	srv := httptest.NewServer(backend)
+	t.Cleanup(srv.Close)
+
 	clientset := fake.NewSimpleClientset()
-	b := &Benchmarker{
+	opts := DefaultOptions()
+	opts.Endpoint = srv.URL
+	opts.Prompts = []string{"a", "b"}
+	opts.WarmupIterations = 1
+	opts.MinDuration = 0
+	opts.ReadyTimeout = time.Second
+	opts.ReadyPollInterval = 10 * time.Millisecond
+	return &Benchmarker{
 		kubeClient: clientset,
 		namespace:  "default",
-	}
+		httpClient: srv.Client(),
+		opts:       opts,
+	}, clientset
+}
+
+func TestRun(t *testing.T) {
+	backend := &fakeBackend{tokens: 5, tokenDelay: 2 * time.Millisecond}
+	b, clientset := newTestBenchmarker(t, backend)
+	b.opts.ModelDeployment = "md"
+	b.opts.DeviceClass = "nvidia-sm89-24gi"
 
 	model := "test-model"
 	configMapName := "test-cm"
@@ -27,6 +89,135 @@ func TestRun(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, model, cm.Data["model"])
-	assert.Equal(t, "150.75", cm.Data["tokensPerSecond"])
 	assert.NotEmpty(t, cm.Data["timestamp"])
+	assert.Equal(t, "nvidia-sm89-24gi", cm.Data["deviceClass"])
+	assert.Equal(t, "md", cm.Labels[LabelModelDeployment])
+	assert.Equal(t, "2", cm.Data["iterations"])
+
+	tps, err := strconv.ParseFloat(cm.Data["tokensPerSecond"], 64)
+	require.NoError(t, err)
+	assert.Greater(t, tps, 0.0)
+	// Five tokens spaced at least 2ms apart cannot exceed 500 tokens/sec.
+	assert.LessOrEqual(t, tps, 500.0)
+
+	ttft, err := strconv.ParseFloat(cm.Data["timeToFirstTokenMs"], 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, ttft, 2.0)
+
+	itl, err := strconv.ParseFloat(cm.Data["interTokenLatencyMs"], 64)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, itl, 2.0)
+
+	assert.Equal(t, int32(1), backend.pulls.Load())
+	// One warmup request plus one measured request per prompt.
+	assert.Equal(t, int32(3), backend.generates.Load())
+}
+
+func TestRunReplacesPreviousResults(t *testing.T) {
+	backend := &fakeBackend{tokens: 5}
+	b, clientset := newTestBenchmarker(t, backend)
+	b.opts.Backend = "ollama"
+	b.opts.Revision = "new"
+
+	_, err := clientset.CoreV1().ConfigMaps("default").Create(context.Background(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cm", Namespace: "default"},
+		Data:       map[string]string{"tokensPerSecond": "1.00", "revision": "old"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Run(context.Background(), "test-model", "test-cm"))
+
+	cm, err := clientset.CoreV1().ConfigMaps("default").Get(context.Background(), "test-cm", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "new", cm.Data["revision"])
+	assert.Equal(t, "ollama", cm.Data["backend"])
+	assert.NotEqual(t, "1.00", cm.Data["tokensPerSecond"])
+}
+
+func TestRunHonorsMinDuration(t *testing.T) {
+	backend := &fakeBackend{tokens: 2, tokenDelay: 5 * time.Millisecond}
+	b, _ := newTestBenchmarker(t, backend)
+	b.opts.WarmupIterations = 0
+	b.opts.MinDuration = 100 * time.Millisecond
+
+	result, err := b.runBenchmark(context.Background(), "test-model")
+	require.NoError(t, err)
+
+	// Each request takes at least 10ms, so covering 100ms needs several rounds
+	// beyond the two prompts.
+	assert.Greater(t, result.Iterations, 2)
+	assert.Equal(t, int32(result.Iterations), backend.generates.Load())
+}
+
+func TestRunOpenAI(t *testing.T) {
+	var pulls atomic.Int32
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+		case "/v1/completions":
+			var req completionRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Equal(t, 128, req.MaxTokens)
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 3; i++ {
+				fmt.Fprint(w, "data: {\"choices\":[{\"text\":\"tok\"}]}\n\n")
+			}
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"completion_tokens\":6}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+		default:
+			pulls.Add(1)
+			http.NotFound(w, r)
+		}
+	})
+	b, _ := newTestBenchmarker(t, backend)
+	b.opts.API = "openai"
+	b.opts.ReadyPath = "/health"
+
+	result, err := b.runBenchmark(context.Background(), "test-model")
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Iterations)
+	assert.Greater(t, result.TokensPerSecond, 0.0)
+	assert.Zero(t, pulls.Load(), "OpenAI backends must not be sent Ollama pull requests")
+}
+
+func TestRunBackendError(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/generate" {
+			fmt.Fprintln(w, `{"error":"model not found"}`)
+			return
+		}
+		fmt.Fprint(w, `{}`)
+	})
+	b, clientset := newTestBenchmarker(t, backend)
+
+	err := b.Run(context.Background(), "missing", "test-cm")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "model not found")
+
+	_, err = clientset.CoreV1().ConfigMaps("default").Get(context.Background(), "test-cm", metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+func TestRunBackendNotReady(t *testing.T) {
+	backend := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "loading", http.StatusServiceUnavailable)
+	})
+	b, _ := newTestBenchmarker(t, backend)
+	b.opts.ReadyTimeout = 50 * time.Millisecond
+
+	err := b.Run(context.Background(), "test-model", "test-cm")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not ready")
+}
+
+func TestAggregate(t *testing.T) {
+	r := aggregate([]sample{
+		{tokens: 11, ttft: 100 * time.Millisecond, total: 1100 * time.Millisecond},
+		{tokens: 11, ttft: 300 * time.Millisecond, total: 1300 * time.Millisecond},
+	})
+
+	assert.Equal(t, 2, r.Iterations)
+	assert.InDelta(t, 22/2.4, r.TokensPerSecond, 0.001)
+	assert.Equal(t, 200*time.Millisecond, r.TimeToFirstToken)
+	assert.Equal(t, 100*time.Millisecond, r.InterTokenLatency)
 }
@@ -0,0 +1,266 @@
+package benchmarker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/flexinfer/flexinfer/pkg/backend"
+)
+
+// sample holds the measurements for a single generation request.
+type sample struct {
+	// tokens is the number of tokens generated.
+	tokens int
+	// ttft is the time from sending the request to receiving the first token.
+	ttft time.Duration
+	// total is the time from sending the request to receiving the last token.
+	total time.Duration
+}
+
+// generateRequest is the body of an Ollama /api/generate request.
+type generateRequest struct {
+	Model   string         `json:"model"`
+	Prompt  string         `json:"prompt"`
+	Stream  bool           `json:"stream"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// generateChunk is a single line of a streamed Ollama /api/generate response.
+type generateChunk struct {
+	Response  string `json:"response"`
+	Done      bool   `json:"done"`
+	EvalCount int    `json:"eval_count"`
+	Error     string `json:"error"`
+}
+
+// waitForReady polls the backend until it answers with a 2xx status or the
+// context expires.
+func (b *Benchmarker) waitForReady(ctx context.Context) error {
+	ticker := time.NewTicker(b.opts.ReadyPollInterval)
+	defer ticker.Stop()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url(b.opts.ReadyPath), nil)
+		if err != nil {
+			return fmt.Errorf("failed to build readiness request: %w", err)
+		}
+		resp, err := b.httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("backend %s not ready: %w", b.opts.Endpoint, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// pullModel asks the backend to fetch the model so that the first measured
+// request does not include the download time.
+func (b *Benchmarker) pullModel(ctx context.Context, model string) error {
+	body, err := json.Marshal(map[string]any{"name": model, "stream": false})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url("/api/pull"), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build pull request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to pull model %s: %w", model, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to pull model %s: %s: %s", model, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// generate sends one streaming generation request in the backend's protocol
+// and times the response.
+func (b *Benchmarker) generate(ctx context.Context, model, prompt string) (sample, error) {
+	if b.opts.API == backend.APIOpenAI {
+		return b.generateOpenAI(ctx, model, prompt)
+	}
+	return b.generateOllama(ctx, model, prompt)
+}
+
+// generateOllama streams an Ollama /api/generate request.
+func (b *Benchmarker) generateOllama(ctx context.Context, model, prompt string) (sample, error) {
+	resp, start, err := b.post(ctx, "/api/generate", generateRequest{
+		Model:   model,
+		Prompt:  prompt,
+		Stream:  true,
+		Options: map[string]any{"num_predict": b.opts.MaxTokens},
+	})
+	if err != nil {
+		return sample{}, err
+	}
+	defer resp.Body.Close()
+
+	t := tokenTimer{start: start}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var chunk generateChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return sample{}, fmt.Errorf("failed to decode generate response: %w", err)
+		}
+		if chunk.Error != "" {
+			return sample{}, fmt.Errorf("backend error: %s", chunk.Error)
+		}
+		if chunk.Response != "" {
+			t.token()
+		}
+		if chunk.Done {
+			t.reported = chunk.EvalCount
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sample{}, fmt.Errorf("failed to read generate response: %w", err)
+	}
+	return t.sample()
+}
+
+// completionRequest is the body of an OpenAI-compatible /v1/completions request.
+type completionRequest struct {
+	Model         string         `json:"model"`
+	Prompt        string         `json:"prompt"`
+	MaxTokens     int            `json:"max_tokens"`
+	Stream        bool           `json:"stream"`
+	StreamOptions map[string]any `json:"stream_options,omitempty"`
+}
+
+// completionChunk is a single server-sent event of a streamed completion.
+type completionChunk struct {
+	Choices []struct {
+		Text string `json:"text"`
+	} `json:"choices"`
+	Usage *struct {
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// generateOpenAI streams an OpenAI-compatible /v1/completions request.
+func (b *Benchmarker) generateOpenAI(ctx context.Context, model, prompt string) (sample, error) {
+	resp, start, err := b.post(ctx, "/v1/completions", completionRequest{
+		Model:         model,
+		Prompt:        prompt,
+		MaxTokens:     b.opts.MaxTokens,
+		Stream:        true,
+		StreamOptions: map[string]any{"include_usage": true},
+	})
+	if err != nil {
+		return sample{}, err
+	}
+	defer resp.Body.Close()
+
+	t := tokenTimer{start: start}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+		var chunk completionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return sample{}, fmt.Errorf("failed to decode completion response: %w", err)
+		}
+		if chunk.Error != nil {
+			return sample{}, fmt.Errorf("backend error: %s", chunk.Error.Message)
+		}
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Text != "" {
+			t.token()
+		}
+		if chunk.Usage != nil {
+			t.reported = chunk.Usage.CompletionTokens
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sample{}, fmt.Errorf("failed to read completion response: %w", err)
+	}
+	return t.sample()
+}
+
+// post sends a JSON request and returns the response once headers arrive,
+// along with the time the request was sent.
+func (b *Benchmarker) post(ctx context.Context, path string, payload any) (*http.Response, time.Time, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.url(path), bytes.NewReader(body))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("request to %s failed: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, time.Time{}, fmt.Errorf("request to %s failed: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, start, nil
+}
+
+// tokenTimer records when streamed tokens arrive.
+type tokenTimer struct {
+	start    time.Time
+	chunks   int
+	reported int
+	s        sample
+}
+
+func (t *tokenTimer) token() {
+	if t.chunks == 0 {
+		t.s.ttft = time.Since(t.start)
+	}
+	t.chunks++
+	t.s.total = time.Since(t.start)
+}
+
+func (t *tokenTimer) sample() (sample, error) {
+	if t.chunks == 0 {
+		return sample{}, fmt.Errorf("backend returned no tokens")
+	}
+	// Prefer the backend's own token count; streamed chunks are not always
+	// one token each.
+	t.s.tokens = t.chunks
+	if t.reported > 0 {
+		t.s.tokens = t.reported
+	}
+	return t.s, nil
+}
+
+func (b *Benchmarker) url(path string) string {
+	return strings.TrimSuffix(b.opts.Endpoint, "/") + path
+}
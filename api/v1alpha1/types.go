@@ -18,12 +18,13 @@ package v1alpha1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // ModelDeploymentSpec defines the desired state of ModelDeployment
 type ModelDeploymentSpec struct {
-	// Backend is the name of the LLM backend to use (e.g., ollama, vllm).
+	// Backend is the name of the LLM backend to use: ollama, vllm, llamacpp or tgi.
 	// +kubebuilder:validation:Required
 	Backend string `json:"backend"`
 
@@ -36,6 +37,15 @@ type ModelDeploymentSpec struct {
 	// +kubebuilder:validation:Minimum=0
 	Replicas *int32 `json:"replicas,omitempty"`
 
+	// Autoscaling scales the number of pods with the model's traffic. When set, Replicas is
+	// ignored.
+	// +optional
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// IdleTimeout scales the model to zero after this long without requests.
+	// +optional
+	IdleTimeout *metav1.Duration `json:"idleTimeout,omitempty"`
+
 	// Resources defines the resources required by the model.
 	// +optional
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
@@ -43,8 +53,215 @@ type ModelDeploymentSpec struct {
 	// Benchmark defines tuning knobs for the benchmarking process.
 	// +optional
 	Benchmark *BenchmarkSpec `json:"benchmark,omitempty"`
+
+	// Requirements describes the hardware the model needs.
+	// +optional
+	Requirements *ModelRequirements `json:"requirements,omitempty"`
+
+	// SchedulingPolicy overrides the scheduler's global policy for this model.
+	// +optional
+	SchedulingPolicy *SchedulingPolicy `json:"schedulingPolicy,omitempty"`
+
+	// TensorParallelSize is the number of GPUs each replica shards the model across.
+	// Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TensorParallelSize *int32 `json:"tensorParallelSize,omitempty"`
+
+	// Rollout rolls out changes to Model as a canary. Without it, the pods are replaced at
+	// once.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+}
+
+// AutoscalingSpec scales a model's pods so that each serves about its target load. With
+// both targets set, the one that needs more pods wins.
+type AutoscalingSpec struct {
+	// MinReplicas is the fewest pods to run.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the most pods to run.
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetTokensPerSecond is the generation throughput each pod should serve. Only
+	// flexinfer-gateway measures it; without the gateway it is unsupported and ignored.
+	// +optional
+	TargetTokensPerSecond *resource.Quantity `json:"targetTokensPerSecond,omitempty"`
+
+	// TargetInFlightRequests is the number of requests each pod should be serving or
+	// queueing, going by the backend's own metrics. Ollama does not report them.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetInFlightRequests *int32 `json:"targetInFlightRequests,omitempty"`
+
+	// ScaleUpStabilizationWindow is how long the load must call for more pods before
+	// they are added. Defaults to 0.
+	// +optional
+	ScaleUpStabilizationWindow *metav1.Duration `json:"scaleUpStabilizationWindow,omitempty"`
+
+	// ScaleDownStabilizationWindow is how long the load must call for fewer pods before
+	// they are removed. Defaults to 5m.
+	// +optional
+	ScaleDownStabilizationWindow *metav1.Duration `json:"scaleDownStabilizationWindow,omitempty"`
+}
+
+// RolloutSpec runs a new model as a canary next to the current, stable one. The canary is
+// promoted once it has served requests for AnalysisDuration within its error and throughput
+// bounds, and rolled back as soon as it falls outside them. With CanaryWeight at 50 and a
+// long AnalysisDuration, it runs an A/B comparison of the two models.
+type RolloutSpec struct {
+	// CanaryWeight is the percentage of the gateway's requests for the model that the
+	// canary serves.
+	// +kubebuilder:default=10
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	CanaryWeight *int32 `json:"canaryWeight,omitempty"`
+
+	// CanaryReplicas is the number of canary pods.
+	// +kubebuilder:default=1
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	CanaryReplicas *int32 `json:"canaryReplicas,omitempty"`
+
+	// AnalysisDuration is how long the canary serves requests before it is promoted.
+	// Defaults to 10m.
+	// +optional
+	AnalysisDuration *metav1.Duration `json:"analysisDuration,omitempty"`
+
+	// MinRequests is the fewest requests the canary must serve before it is promoted.
+	// +kubebuilder:default=20
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinRequests *int32 `json:"minRequests,omitempty"`
+
+	// MaxErrorRate is the highest fraction of the canary's requests that may fail.
+	// Defaults to 0.05.
+	// +optional
+	MaxErrorRate *resource.Quantity `json:"maxErrorRate,omitempty"`
+
+	// MinTokensPerSecondRatio is the lowest the canary's tokens per second may be, as a
+	// fraction of the stable model's benchmark. Defaults to 0.8.
+	// +optional
+	MinTokensPerSecondRatio *resource.Quantity `json:"minTokensPerSecondRatio,omitempty"`
+}
+
+// CanarySuffix names a ModelDeployment's canary Deployment and Service: <name>-canary.
+const CanarySuffix = "-canary"
+
+// DefaultCanaryWeight is the RolloutSpec.CanaryWeight used when it is unset.
+const DefaultCanaryWeight = 10
+
+// AnnotationLastRequest is when the activator last forwarded a request to a ModelDeployment,
+// in RFC 3339. The activator refreshes it every 30s or so while the model is in use, and at
+// once when a request arrives for a model that is scaled to zero.
+const AnnotationLastRequest = "flexinfer.ai/last-request"
+
+// SchedulingPolicy tunes how the scheduler places a model's pods. The controller stamps it
+// onto the pods as the AnnotationSchedulingPolicy annotation.
+type SchedulingPolicy struct {
+	// Weights replace the scheduler's global scoring weights for this model.
+	// +optional
+	Weights *SchedulingWeights `json:"weights,omitempty"`
+
+	// PreferredDeviceClasses lists device classes, e.g. nvidia-sm-89-24gi, in order of
+	// preference.
+	// +optional
+	PreferredDeviceClasses []string `json:"preferredDeviceClasses,omitempty"`
+
+	// MaxCostPerHour excludes nodes whose hourly cost, as published by the agent, is higher.
+	// +optional
+	MaxCostPerHour *resource.Quantity `json:"maxCostPerHour,omitempty"`
+
+	// Placement is Spread to prefer idle nodes or Pack to prefer busy ones.
+	// +kubebuilder:default=Spread
+	// +kubebuilder:validation:Enum=Spread;Pack
+	// +optional
+	Placement Placement `json:"placement,omitempty"`
+}
+
+// SchedulingWeights is how much each scoring factor counts. Only their ratios matter.
+type SchedulingWeights struct {
+	// TokensPerSecond weights the model's benchmarked throughput on the node.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TokensPerSecond int32 `json:"tokensPerSecond,omitempty"`
+
+	// Utilization weights the node's GPU utilization, in the direction Placement asks for.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Utilization int32 `json:"utilization,omitempty"`
+
+	// Cost weights the node's hourly cost.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Cost int32 `json:"cost,omitempty"`
+
+	// DeviceClass weights PreferredDeviceClasses. It defaults to the TokensPerSecond weight
+	// when PreferredDeviceClasses is set.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DeviceClass int32 `json:"deviceClass,omitempty"`
+
+	// Topology weights how well connected the node's free GPUs are. It defaults to the
+	// TokensPerSecond weight for tensor-parallel models.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Topology int32 `json:"topology,omitempty"`
+}
+
+// Placement is how the scheduler spreads a model's pods across nodes.
+type Placement string
+
+// Placements for SchedulingPolicy.Placement.
+const (
+	PlacementSpread Placement = "Spread"
+	PlacementPack   Placement = "Pack"
+)
+
+// AnnotationSchedulingPolicy carries a ModelDeployment's SchedulingPolicy, as JSON, on its
+// pods for the scheduler to read.
+const AnnotationSchedulingPolicy = "flexinfer.ai/scheduling-policy"
+
+// ModelRequirements describes the hardware a model needs. Unset fields are estimated from
+// the model name and backend, e.g. llama3:70b on ollama is a 4-bit 70B-parameter model.
+type ModelRequirements struct {
+	// VRAM is the accelerator memory the model needs in total, across all of its GPUs.
+	// +optional
+	VRAM *resource.Quantity `json:"vram,omitempty"`
+
+	// Quantization is the precision of the model's weights. int4 requires nodes with
+	// INT4 tensor support.
+	// +kubebuilder:validation:Enum=fp16;int8;int4
+	// +optional
+	Quantization string `json:"quantization,omitempty"`
+
+	// GPUVendor restricts the model to one GPU vendor: NVIDIA, AMD or Intel.
+	// +kubebuilder:validation:Enum=NVIDIA;AMD;Intel
+	// +optional
+	GPUVendor string `json:"gpuVendor,omitempty"`
+
+	// GPUArchitectures restricts the model to the listed architectures, e.g. sm_80 or gfx90a.
+	// +optional
+	GPUArchitectures []string `json:"gpuArchitectures,omitempty"`
+
+	// GPUCount is the number of GPUs on one node the model is split across.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	GPUCount *int32 `json:"gpuCount,omitempty"`
 }
 
+// Quantization levels for ModelRequirements.Quantization.
+const (
+	QuantizationFP16 = "fp16"
+	QuantizationINT8 = "int8"
+	QuantizationINT4 = "int4"
+)
+
 // BenchmarkSpec defines the tuning knobs for the benchmarking process.
 type BenchmarkSpec struct {
 	// WarmupIterations is the number of warmup iterations to run before the main benchmark.
@@ -52,21 +269,246 @@ type BenchmarkSpec struct {
 	WarmupIterations *int32 `json:"warmupIterations,omitempty"`
 
 	// MinDuration is the minimum duration for the benchmark.
-	// The benchmark will run for at least this duration or for a minimum number of iterations, whichever comes first.
+	// The benchmark keeps issuing requests until at least this much time has passed and every prompt has run once.
 	// +optional
 	MinDuration *metav1.Duration `json:"minDuration,omitempty"`
+
+	// Prompts is the prompt set sent to the backend during the benchmark.
+	// Defaults to a small built-in set when empty.
+	// +optional
+	Prompts []string `json:"prompts,omitempty"`
+
+	// MaxTokens caps the number of tokens generated per benchmark request.
+	// +kubebuilder:default=128
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxTokens *int32 `json:"maxTokens,omitempty"`
+
+	// RetryLimit is the number of times a failed benchmark Job is retried.
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	RetryLimit *int32 `json:"retryLimit,omitempty"`
+
+	// DeployOnFailure deploys the model without benchmark results once retries run out.
+	// +optional
+	DeployOnFailure bool `json:"deployOnFailure,omitempty"`
+
+	// Schedule re-runs the benchmark at an interval such as "24h" or on a cron schedule
+	// such as "0 3 * * *".
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+}
+
+// AnnotationRebenchmark requests a fresh benchmark of a ModelDeployment whenever its
+// value changes, e.g. `kubectl annotate md/llama flexinfer.ai/rebenchmark=$(date +%s)`.
+const AnnotationRebenchmark = "flexinfer.ai/rebenchmark"
+
+// DeviceClassBenchmark is the benchmark result for one device class.
+type DeviceClassBenchmark struct {
+	// DeviceClass identifies the hardware, e.g. nvidia-sm-89-24gi or cpu.
+	DeviceClass string `json:"deviceClass"`
+
+	// TokensPerSecond is the measured throughput on this device class.
+	// +optional
+	TokensPerSecond string `json:"tokensPerSecond,omitempty"`
+
+	// LastBenchmarkTime is when this result was recorded.
+	// +optional
+	LastBenchmarkTime *metav1.Time `json:"lastBenchmarkTime,omitempty"`
 }
 
+// ModelDeploymentPhase is a coarse summary of where a ModelDeployment is in its lifecycle.
+// +kubebuilder:validation:Enum=Pending;Benchmarking;Provisioning;Deploying;Ready;Failed
+type ModelDeploymentPhase string
+
+const (
+	// PhasePending means the ModelDeployment has not been reconciled yet.
+	PhasePending ModelDeploymentPhase = "Pending"
+	// PhaseBenchmarking means the model is being benchmarked.
+	PhaseBenchmarking ModelDeploymentPhase = "Benchmarking"
+	// PhaseProvisioning means the model cache volume is not yet bound.
+	PhaseProvisioning ModelDeploymentPhase = "Provisioning"
+	// PhaseDeploying means the backend pods are pulling the model or starting.
+	PhaseDeploying ModelDeploymentPhase = "Deploying"
+	// PhaseReady means the desired number of replicas are serving.
+	PhaseReady ModelDeploymentPhase = "Ready"
+	// PhaseFailed means the ModelDeployment is Degraded.
+	PhaseFailed ModelDeploymentPhase = "Failed"
+)
+
+// Condition types reported in ModelDeploymentStatus.Conditions.
+const (
+	// ConditionBenchmarked is True once benchmark results are available. It is False with
+	// reason BenchmarkUnavailable when the model was deployed after every benchmark failed.
+	ConditionBenchmarked = "Benchmarked"
+	// ConditionStorageReady is True once the model cache volume is bound.
+	ConditionStorageReady = "StorageReady"
+	// ConditionAvailable is True when all desired replicas are ready.
+	ConditionAvailable = "Available"
+	// ConditionDegraded is True when the ModelDeployment cannot make progress
+	// without a change to its spec or the cluster.
+	ConditionDegraded = "Degraded"
+	// ConditionScalingActive is True while the autoscaler can read the model's load. It is
+	// only set when Spec.Autoscaling is.
+	ConditionScalingActive = "ScalingActive"
+	// ConditionRolloutComplete is True once the pods serve Spec.Model, and False while a
+	// canary of it is in progress or after it was rolled back. It is only set when
+	// Spec.Rollout is.
+	ConditionRolloutComplete = "RolloutComplete"
+)
+
+// Condition reasons reported in ModelDeploymentStatus.Conditions.
+const (
+	// ReasonUnknownBackend is set when Spec.Backend names no registered driver.
+	ReasonUnknownBackend = "UnknownBackend"
+	// ReasonAsExpected is set when a negative-polarity condition is False.
+	ReasonAsExpected = "AsExpected"
+	// ReasonBenchmarkRunning is set while the benchmark Job is running.
+	ReasonBenchmarkRunning = "BenchmarkRunning"
+	// ReasonBenchmarkComplete is set once benchmark results exist.
+	ReasonBenchmarkComplete = "BenchmarkComplete"
+	// ReasonBenchmarkRetrying is set while a failed benchmark Job waits to be retried.
+	ReasonBenchmarkRetrying = "BenchmarkRetrying"
+	// ReasonBenchmarkFailed is set once a benchmark has failed more than RetryLimit times.
+	ReasonBenchmarkFailed = "BenchmarkFailed"
+	// ReasonBenchmarkUnavailable is set when the model is deployed without benchmark results.
+	ReasonBenchmarkUnavailable = "BenchmarkUnavailable"
+	// ReasonInvalidSchedule is set when Spec.Benchmark.Schedule cannot be parsed.
+	ReasonInvalidSchedule = "InvalidSchedule"
+	// ReasonClaimPending is set while the model cache PVC is unbound.
+	ReasonClaimPending = "ClaimPending"
+	// ReasonClaimBound is set once the model cache PVC is bound.
+	ReasonClaimBound = "ClaimBound"
+	// ReasonReplicasNotReady is set while fewer replicas are ready than desired.
+	ReasonReplicasNotReady = "ReplicasNotReady"
+	// ReasonReplicasReady is set once all desired replicas are ready.
+	ReasonReplicasReady = "ReplicasReady"
+	// ReasonScaledToZero is set when the ModelDeployment has zero replicas.
+	ReasonScaledToZero = "ScaledToZero"
+	// ReasonProgressDeadlineExceeded mirrors the Deployment's rollout timeout.
+	ReasonProgressDeadlineExceeded = "ProgressDeadlineExceeded"
+	// ReasonValidMetrics is set while the autoscaler reads the model's load.
+	ReasonValidMetrics = "ValidMetrics"
+	// ReasonMetricsUnavailable is set when the model's load cannot be read; the number of
+	// pods is left as it is.
+	ReasonMetricsUnavailable = "MetricsUnavailable"
+	// ReasonMissingTarget is set when Spec.Autoscaling sets neither target.
+	ReasonMissingTarget = "MissingTarget"
+	// ReasonModelServing is set when no canary has been rolled out, or Spec.Model was set back
+	// to the stable model during one.
+	ReasonModelServing = "ModelServing"
+	// ReasonCanaryProgressing is set while a canary is benchmarked, started or analyzed.
+	ReasonCanaryProgressing = "CanaryProgressing"
+	// ReasonCanaryPromoted is set once the canary's model has replaced the stable one.
+	ReasonCanaryPromoted = "CanaryPromoted"
+	// ReasonCanaryRolledBack is set when the canary was removed and the stable model kept.
+	// It stays until Spec.Model changes.
+	ReasonCanaryRolledBack = "CanaryRolledBack"
+)
+
 // ModelDeploymentStatus defines the observed state of ModelDeployment
 type ModelDeploymentStatus struct {
+	// Phase is a coarse summary of the ModelDeployment's lifecycle, derived from Conditions.
+	// +optional
+	Phase ModelDeploymentPhase `json:"phase,omitempty"`
+
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// ReadyReplicas is the number of backend pods ready to serve.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+
+	// DesiredReplicas is the number of backend pods the controller last asked for.
+	// +optional
+	DesiredReplicas int32 `json:"desiredReplicas,omitempty"`
+
+	// LastScaleTime is when the autoscaler or IdleTimeout last changed the number of pods.
+	// +optional
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+
 	// Conditions represent the latest available observations of the ModelDeployment's state.
+	// +listType=map
+	// +listMapKey=type
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 
-	// TokensPerSecond is the measured tokens per second for the model on a specific device class.
+	// TokensPerSecond is the measured tokens per second for the model on its fastest device class.
 	// Stored as a string to avoid precision issues with floats.
 	// +optional
 	TokensPerSecond string `json:"tokensPerSecond,omitempty"`
+
+	// DeviceClass is the device class TokensPerSecond was measured on.
+	// +optional
+	DeviceClass string `json:"deviceClass,omitempty"`
+
+	// LastBenchmarkTime is when the benchmark behind TokensPerSecond completed.
+	// +optional
+	LastBenchmarkTime *metav1.Time `json:"lastBenchmarkTime,omitempty"`
+
+	// ScheduledBenchmarkTime is the most recent activation of Spec.Benchmark.Schedule.
+	// +optional
+	ScheduledBenchmarkTime *metav1.Time `json:"scheduledBenchmarkTime,omitempty"`
+
+	// Benchmarks holds the results for each device class in the cluster.
+	// +listType=map
+	// +listMapKey=deviceClass
+	// +optional
+	Benchmarks []DeviceClassBenchmark `json:"benchmarks,omitempty"`
+
+	// Rollout is the state of the latest canary. It is only set when Spec.Rollout is.
+	// +optional
+	Rollout *RolloutStatus `json:"rollout,omitempty"`
+}
+
+// RolloutPhase is where a canary is in its rollout.
+// +kubebuilder:validation:Enum=Progressing;Promoted;RolledBack
+type RolloutPhase string
+
+const (
+	// RolloutProgressing means the canary is being benchmarked, started or analyzed.
+	RolloutProgressing RolloutPhase = "Progressing"
+	// RolloutPromoted means the canary's model replaced the stable one.
+	RolloutPromoted RolloutPhase = "Promoted"
+	// RolloutRolledBack means the canary was removed and the stable model kept.
+	RolloutRolledBack RolloutPhase = "RolledBack"
+)
+
+// RolloutStatus is the state of a ModelDeployment's canary.
+type RolloutStatus struct {
+	// StableModel is the model the stable pods serve.
+	// +optional
+	StableModel string `json:"stableModel,omitempty"`
+
+	// CanaryModel is the model being rolled out, or last rolled out.
+	// +optional
+	CanaryModel string `json:"canaryModel,omitempty"`
+
+	// Phase is where the canary is in its rollout.
+	// +optional
+	Phase RolloutPhase `json:"phase,omitempty"`
+
+	// StartTime is when the canary's pods became ready and its analysis started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// BaselineTokensPerSecond is the stable model's benchmarked tokens per second.
+	// +optional
+	BaselineTokensPerSecond string `json:"baselineTokensPerSecond,omitempty"`
+
+	// CanaryRequests is the number of requests the canary has served since StartTime.
+	// +optional
+	CanaryRequests int64 `json:"canaryRequests,omitempty"`
+
+	// CanaryErrors is the number of those requests that failed with a server error.
+	// +optional
+	CanaryErrors int64 `json:"canaryErrors,omitempty"`
+
+	// CanaryTokensPerSecond is the canary's tokens per second, measured per request.
+	// +optional
+	CanaryTokensPerSecond string `json:"canaryTokensPerSecond,omitempty"`
 }
 
 //+kubebuilder:object:root=true
@@ -74,7 +516,10 @@ type ModelDeploymentStatus struct {
 //+kubebuilder:printcolumn:name="Backend",type="string",JSONPath=".spec.backend"
 //+kubebuilder:printcolumn:name="Model",type="string",JSONPath=".spec.model"
 //+kubebuilder:printcolumn:name="Replicas",type="integer",JSONPath=".spec.replicas"
+//+kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyReplicas"
 //+kubebuilder:printcolumn:name="TPS",type="number",JSONPath=".status.tokensPerSecond"
+//+kubebuilder:printcolumn:name="Phase",type="string",JSONPath=".status.phase"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // ModelDeployment is the Schema for the modeldeployments API
 type ModelDeployment struct {
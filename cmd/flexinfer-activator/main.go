@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/activator"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+func main() {
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	addr := flag.String("addr", ":8080", "The address requests for /<namespace>/<modeldeployment>/... are served on.")
+	metricsPort := flag.Int("metrics-port", 9090, "Prometheus scrape port.")
+	timeout := flag.Duration("timeout", activator.DefaultTimeout, "How long to hold a request for a model scaled to zero to come up.")
+	flag.Parse()
+
+	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := log.Log.WithName("setup")
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(aiv1alpha1.AddToScheme(scheme))
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "Failed to create client")
+		os.Exit(1)
+	}
+
+	metrics.NewExporter().Run(fmt.Sprintf(":%d", *metricsPort))
+
+	setupLog.Info("Starting flexinfer-activator", "addr", *addr, "timeout", *timeout)
+	a := &activator.Activator{Client: c, Timeout: *timeout}
+	if err := http.ListenAndServe(*addr, a); err != nil {
+		setupLog.Error(err, "Activator stopped")
+		os.Exit(1)
+	}
+}
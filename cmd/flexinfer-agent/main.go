@@ -4,9 +4,12 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 	"time"
 
 	"github.com/flexinfer/flexinfer/agents/agent"
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
 	"github.com/flexinfer/flexinfer/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -17,16 +20,20 @@ func main() {
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
-
-	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-	setupLog := log.Log.WithName("setup")
 
 	interval := flag.Duration("interval", 30*time.Second, "How often to re-probe hardware.")
 	metricsPort := flag.Int("metrics-port", 9100, "Prometheus scrape port.")
 	labelPrefix := flag.String("label-prefix", "flexinfer.ai/", "Customize if conflicts with other labelers.")
+	hostRoot := flag.String("host-root", "/", "Where the host filesystem is mounted; hardware is probed under its /sys and /proc.")
+	telemetry := flag.String("telemetry", "auto", "GPU telemetry source: auto, dcgm, nvidia-smi, rocm-smi, hwmon or none.")
+	dcgmURL := flag.String("dcgm-url", "http://localhost:9400/metrics", "DCGM exporter endpoint scraped for NVIDIA telemetry.")
+	utilWindow := flag.Duration("util-window", agent.DefaultUtilizationWindow, "Time constant of the smoothed GPU utilization annotation.")
+	priceTable := flag.String("price-table", "", "YAML file of hourly prices by instance type or GPU model, for the cost annotation.")
 	flag.Parse()
 
+	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := log.Log.WithName("setup")
+
 	setupLog.Info("Starting FlexInfer agent", "interval", *interval, "metricsPort", *metricsPort, "labelPrefix", *labelPrefix)
 
 	// Start the metrics exporter
@@ -34,9 +41,22 @@ func main() {
 	exporter.Run(fmt.Sprintf(":%d", *metricsPort))
 	setupLog.Info("Metrics exporter started")
 
-	nodeAgent, err := agent.NewAgent(*labelPrefix)
+	collector, err := newCollector(*telemetry, *dcgmURL, *hostRoot)
+	if err != nil {
+		setupLog.Error(err, "Invalid telemetry source")
+		os.Exit(1)
+	}
+
+	nodeAgent, err := agent.NewAgent(agent.Options{
+		LabelPrefix:       *labelPrefix,
+		HostRoot:          *hostRoot,
+		Telemetry:         collector,
+		UtilizationWindow: *utilWindow,
+		PriceTable:        *priceTable,
+	})
 	if err != nil {
 		setupLog.Error(err, "Failed to create agent")
+		os.Exit(1)
 	}
 
 	ctx := context.Background()
@@ -44,8 +64,33 @@ func main() {
 		if err := nodeAgent.ProbeAndLabel(ctx); err != nil {
 			setupLog.Error(err, "Error probing and labeling node")
 		}
-		// Placeholder for emitting metrics
-		metrics.GPUTemperature.WithLabelValues("0", "test-node").Set(65.5)
+		if err := nodeAgent.ReportUsage(ctx); err != nil {
+			setupLog.Error(err, "Error reporting node usage")
+		}
 		time.Sleep(*interval)
 	}
 }
+
+// newCollector returns the telemetry collector for source, or nil for none.
+func newCollector(source, dcgmURL, hostRoot string) (metrics.Collector, error) {
+	dcgm := &metrics.DCGMCollector{URL: dcgmURL, Client: &http.Client{Timeout: 5 * time.Second}}
+	nvidiaSMI := &metrics.NvidiaSMICollector{Run: hwprobe.Exec}
+	rocmSMI := &metrics.ROCmSMICollector{Run: hwprobe.Exec}
+	hwmon := &metrics.HwmonCollector{Root: hostRoot}
+
+	switch source {
+	case "auto":
+		return metrics.Chain(dcgm, nvidiaSMI, rocmSMI, hwmon), nil
+	case "dcgm":
+		return dcgm, nil
+	case "nvidia-smi":
+		return nvidiaSMI, nil
+	case "rocm-smi":
+		return rocmSMI, nil
+	case "hwmon":
+		return hwmon, nil
+	case "none":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unknown telemetry source %q", source)
+}
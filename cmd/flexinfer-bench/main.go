@@ -4,43 +4,98 @@ import (
 	"context"
 	"flag"
 	"os"
+	"strings"
 
 	"github.com/flexinfer/flexinfer/agents/benchmarker"
+	"github.com/flexinfer/flexinfer/pkg/backend"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
+// terminationLog is the default path of a container's termination message.
+const terminationLog = "/dev/termination-log"
+
+// promptList collects repeated --prompt flags.
+type promptList []string
+
+func (p *promptList) String() string { return strings.Join(*p, ",") }
+
+func (p *promptList) Set(v string) error {
+	*p = append(*p, v)
+	return nil
+}
+
 func main() {
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
-	flag.Parse()
-
-	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
-	setupLog := log.Log.WithName("setup")
 
+	defaults := benchmarker.DefaultOptions()
+	var prompts promptList
 	model := flag.String("model", "", "The model to benchmark.")
 	configMapName := flag.String("configmap", "", "The name of the ConfigMap to store results in.")
+	modelDeployment := flag.String("modeldeployment", "", "Name of the ModelDeployment being benchmarked; used to label the results.")
+	deviceClass := flag.String("device-class", "", "Device class the benchmark runs on, recorded with the results.")
+	backendName := flag.String("backend", "", "Name of the backend driver serving the model, recorded with the results.")
+	revision := flag.String("revision", "", "Revision of the benchmark request, recorded with the results.")
+	endpoint := flag.String("endpoint", defaults.Endpoint, "Base URL of the backend to benchmark.")
+	api := flag.String("api", string(defaults.API), "Protocol the backend speaks: ollama or openai.")
+	readyPath := flag.String("ready-path", defaults.ReadyPath, "Path polled until the backend is ready.")
+	warmup := flag.Int("warmup", defaults.WarmupIterations, "Number of unmeasured warmup requests.")
+	minDuration := flag.Duration("min-duration", defaults.MinDuration, "Minimum time to spend issuing measured requests.")
+	maxTokens := flag.Int("max-tokens", defaults.MaxTokens, "Maximum tokens to generate per request.")
+	readyTimeout := flag.Duration("ready-timeout", defaults.ReadyTimeout, "How long to wait for the backend to become ready.")
+	flag.Var(&prompts, "prompt", "A prompt to benchmark with. May be repeated; defaults to a built-in set.")
 	flag.Parse()
 
+	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := log.Log.WithName("setup")
+
 	if *model == "" || *configMapName == "" {
 		setupLog.Error(nil, "Both --model and --configmap flags are required.")
 		os.Exit(1)
 	}
+	if *api != string(backend.APIOllama) && *api != string(backend.APIOpenAI) {
+		setupLog.Error(nil, "Unsupported --api value.", "api", *api)
+		os.Exit(1)
+	}
+
+	benchOpts := defaults
+	benchOpts.ModelDeployment = *modelDeployment
+	benchOpts.DeviceClass = *deviceClass
+	benchOpts.Backend = *backendName
+	benchOpts.Revision = *revision
+	benchOpts.Endpoint = *endpoint
+	benchOpts.API = backend.API(*api)
+	benchOpts.ReadyPath = *readyPath
+	benchOpts.WarmupIterations = *warmup
+	benchOpts.MinDuration = *minDuration
+	benchOpts.MaxTokens = *maxTokens
+	benchOpts.ReadyTimeout = *readyTimeout
+	if len(prompts) > 0 {
+		benchOpts.Prompts = prompts
+	}
 
-	setupLog.Info("Starting benchmark", "model", *model)
+	setupLog.Info("Starting benchmark", "model", *model, "endpoint", benchOpts.Endpoint)
 
-	bm, err := benchmarker.NewBenchmarker()
+	bm, err := benchmarker.NewBenchmarker(benchOpts)
 	if err != nil {
 		setupLog.Error(err, "Failed to create benchmarker")
-		os.Exit(1)
+		fail(err)
 	}
 
 	if err := bm.Run(context.Background(), *model, *configMapName); err != nil {
 		setupLog.Error(err, "Benchmark failed")
-		os.Exit(1)
+		fail(err)
 	}
 
 	setupLog.Info("Benchmark completed successfully", "model", *model)
 }
+
+// fail exits after writing err to the container's termination log, where the
+// controller picks it up for the ModelDeployment's Benchmarked condition.
+func fail(err error) {
+	_ = os.WriteFile(terminationLog, []byte(err.Error()), 0o644)
+	os.Exit(1)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/activator"
+	"github.com/flexinfer/flexinfer/pkg/gateway"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+func main() {
+	opts := zap.Options{
+		Development: true,
+	}
+	opts.BindFlags(flag.CommandLine)
+	addr := flag.String("addr", ":8080", "The address the OpenAI-compatible API is served on.")
+	metricsPort := flag.Int("metrics-port", 9090, "Prometheus scrape port.")
+	namespace := flag.String("namespace", "", "Only route to ModelDeployments in this namespace; empty for all.")
+	refresh := flag.Duration("refresh", 10*time.Second, "How often to re-read the ModelDeployments to route to.")
+	timeout := flag.Duration("activation-timeout", activator.DefaultTimeout, "How long to hold a request for a model scaled to zero to come up.")
+	policy := flag.String("policy", string(gateway.PolicyPowerOfTwo), "How requests are balanced across a model's pods: power-of-two or least-load.")
+	prefixAffinity := flag.Int("prefix-affinity", 0, "Send prompts sharing their first this many bytes to the same pod; 0 disables.")
+	scrape := flag.Duration("scrape-interval", 2*time.Second, "How often each pod's load is read from its metrics.")
+	flag.Parse()
+
+	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	setupLog := log.Log.WithName("setup")
+
+	if p := gateway.Policy(*policy); p != gateway.PolicyPowerOfTwo && p != gateway.PolicyLeastLoad {
+		setupLog.Error(fmt.Errorf("unknown policy %q", *policy), "Invalid --policy")
+		os.Exit(1)
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(aiv1alpha1.AddToScheme(scheme))
+	c, err := client.New(ctrl.GetConfigOrDie(), client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "Failed to create client")
+		os.Exit(1)
+	}
+
+	metrics.NewExporter().Run(fmt.Sprintf(":%d", *metricsPort))
+
+	ctx := context.Background()
+	routes := &gateway.Table{Client: c, Namespace: *namespace}
+	go routes.Run(ctx, *refresh)
+	g := &gateway.Gateway{
+		Routes:         routes,
+		Activator:      &activator.Activator{Client: c, Timeout: *timeout},
+		Policy:         gateway.Policy(*policy),
+		PrefixAffinity: *prefixAffinity,
+	}
+	go g.RecordTokens(ctx, 5*time.Second)
+	go g.ScrapeReplicas(ctx, *scrape)
+
+	setupLog.Info("Starting flexinfer-gateway", "addr", *addr, "namespace", *namespace, "policy", *policy)
+	if err := http.ListenAndServe(*addr, g); err != nil {
+		setupLog.Error(err, "Gateway stopped")
+		os.Exit(1)
+	}
+}
@@ -18,7 +18,9 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -34,6 +36,7 @@ import (
 
 	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
 	"github.com/flexinfer/flexinfer/controllers"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -53,11 +56,16 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var prometheusURL string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&prometheusURL, "prometheus-url", "",
+		"The Prometheus server autoscaled ModelDeployments read their load from, and canaries their error rate and "+
+			"throughput, e.g. http://prometheus.monitoring:9090. Without it autoscaled models stay at their current size "+
+			"and canaries are promoted once ready for their analysis duration.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,9 +86,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	var load metrics.LoadSource
+	var canary metrics.CanarySource
+	if prometheusURL != "" {
+		source := &metrics.PrometheusLoadSource{URL: prometheusURL, Client: &http.Client{Timeout: 10 * time.Second}}
+		load, canary = source, source
+	}
 	if err = (&controllers.ModelDeploymentReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
+		Load:   load,
+		Canary: canary,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ModelDeployment")
 		os.Exit(1)
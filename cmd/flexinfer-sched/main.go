@@ -2,9 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"net/http"
+	"os"
 
 	"github.com/flexinfer/flexinfer/scheduler"
+	"github.com/flexinfer/flexinfer/scheduler/plugin"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -14,8 +18,40 @@ func main() {
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
+	mode := flag.String("mode", "extender", "How to run: extender serves the HTTP extender; plugin runs kube-scheduler with the FlexInfer plugin built in, passing it the arguments after --.")
+	printConfig := flag.Bool("print-config", false, "Print a sample KubeSchedulerConfiguration that uses this extender and exit.")
+	urlPrefix := flag.String("url-prefix", "http://flexinfer-sched.flexinfer-system.svc:8888", "Extender URL written by --print-config.")
+	nodeCacheCapable := flag.Bool("node-cache-capable", true, "Whether the configuration written by --print-config has kube-scheduler send node names instead of Node objects.")
 	flag.Parse()
 
+	if *mode != "extender" && *mode != "plugin" {
+		fmt.Fprintf(os.Stderr, "unknown --mode %q: must be extender or plugin\n", *mode)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		out, err := scheduler.KubeSchedulerConfigurationYAML(*urlPrefix, *nodeCacheCapable)
+		if *mode == "plugin" {
+			out, err = scheduler.KubeSchedulerPluginConfigurationYAML()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	if *mode == "plugin" {
+		// kube-scheduler parses its own flags and sets up its own logging.
+		command := app.NewSchedulerCommand(app.WithPlugin(plugin.Name, plugin.New))
+		command.SetArgs(flag.Args())
+		if err := command.Execute(); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
 	log.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 	setupLog := log.Log.WithName("setup")
 
@@ -24,10 +60,14 @@ func main() {
 	sched, err := scheduler.NewScheduler()
 	if err != nil {
 		setupLog.Error(err, "Failed to create scheduler")
+		os.Exit(1)
 	}
 
-	http.HandleFunc("/filter", sched.Filter)
+	http.HandleFunc("/"+scheduler.FilterVerb, sched.Filter)
+	http.HandleFunc("/"+scheduler.PrioritizeVerb, sched.Prioritize)
+	http.HandleFunc("/"+scheduler.PreemptVerb, sched.Preempt)
 	http.HandleFunc("/score", sched.Score)
+	http.HandleFunc("/debug/scores", sched.DebugScores)
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
@@ -0,0 +1,211 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+const (
+	// autoscaleInterval is how often the load on autoscaled models is read.
+	autoscaleInterval = 15 * time.Second
+	// defaultScaleDownStabilization is used when ScaleDownStabilizationWindow is unset.
+	defaultScaleDownStabilization = 5 * time.Minute
+	// scaleTolerance is how far the load per pod may stray from its target, as a
+	// fraction of it, before the number of pods changes.
+	scaleTolerance = 0.1
+)
+
+// desiredReplicas returns the number of pods m's Deployment, which runs current,
+// should have. A model idle for longer than Spec.IdleTimeout has none. Without
+// Spec.Autoscaling that is Spec.Replicas. Otherwise it is what the load
+// recommends, stabilized and kept within the bounds, and the ScalingActive
+// condition records whether the load could be read; if it could not, the
+// number of pods is left as it is.
+func (r *ModelDeploymentReconciler) desiredReplicas(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver, current int32) int32 {
+	key := types.NamespacedName{Name: m.Name, Namespace: m.Namespace}
+	if m.Spec.IdleTimeout != nil && untilIdle(m, time.Now()) <= 0 {
+		r.scaleHistory.forget(key)
+		return 0
+	}
+	a := m.Spec.Autoscaling
+	if a == nil {
+		meta.RemoveStatusCondition(&m.Status.Conditions, aiv1alpha1.ConditionScalingActive)
+		r.scaleHistory.forget(key)
+		return *m.Spec.Replicas
+	}
+	bound := func(n int32) int32 {
+		return max(minReplicas(a), min(n, a.MaxReplicas))
+	}
+
+	if a.TargetTokensPerSecond == nil && a.TargetInFlightRequests == nil {
+		setCondition(m, aiv1alpha1.ConditionScalingActive, metav1.ConditionFalse, aiv1alpha1.ReasonMissingTarget,
+			"autoscaling sets neither targetTokensPerSecond nor targetInFlightRequests")
+		return bound(current)
+	}
+	if r.Load == nil {
+		setCondition(m, aiv1alpha1.ConditionScalingActive, metav1.ConditionFalse, aiv1alpha1.ReasonMetricsUnavailable,
+			"the manager has no metrics source; start it with --prometheus-url")
+		return bound(current)
+	}
+
+	q := metrics.LoadQuery{Namespace: m.Namespace, Deployment: m.Name}
+	if qr, ok := driver.(backend.QueueReporter); ok {
+		q.QueueMetrics = qr.QueueMetrics()
+	}
+	load, err := r.Load.Load(ctx, q)
+	if err != nil {
+		setCondition(m, aiv1alpha1.ConditionScalingActive, metav1.ConditionFalse, aiv1alpha1.ReasonMetricsUnavailable,
+			fmt.Sprintf("failed to read the model's load: %v", err))
+		return bound(current)
+	}
+	recommended, ok := recommend(a, current, load)
+	if !ok {
+		setCondition(m, aiv1alpha1.ConditionScalingActive, metav1.ConditionFalse, aiv1alpha1.ReasonMetricsUnavailable,
+			"no load has been reported for the model's targets")
+		return bound(current)
+	}
+	setCondition(m, aiv1alpha1.ConditionScalingActive, metav1.ConditionTrue, aiv1alpha1.ReasonValidMetrics,
+		fmt.Sprintf("the load calls for %d replicas", recommended))
+
+	up := time.Duration(0)
+	if a.ScaleUpStabilizationWindow != nil {
+		up = a.ScaleUpStabilizationWindow.Duration
+	}
+	down := defaultScaleDownStabilization
+	if a.ScaleDownStabilizationWindow != nil {
+		down = a.ScaleDownStabilizationWindow.Duration
+	}
+	return bound(r.scaleHistory.stabilize(key, current, recommended, time.Now(), up, down))
+}
+
+// untilIdle returns how long until m, which sets Spec.IdleTimeout, has gone
+// that long without a request through the activator, or how long ago it did
+// as a negative duration. A model that has never had one counts from its
+// creation.
+func untilIdle(m *aiv1alpha1.ModelDeployment, now time.Time) time.Duration {
+	last := m.CreationTimestamp.Time
+	if t, err := time.Parse(time.RFC3339, m.Annotations[aiv1alpha1.AnnotationLastRequest]); err == nil && t.After(last) {
+		last = t
+	}
+	return last.Add(m.Spec.IdleTimeout.Duration).Sub(now)
+}
+
+// minReplicas returns a's MinReplicas, defaulting to 1.
+func minReplicas(a *aiv1alpha1.AutoscalingSpec) int32 {
+	if a.MinReplicas == nil {
+		return 1
+	}
+	return *a.MinReplicas
+}
+
+// recommend returns the number of pods that brings the load on each to its
+// targets in a, taking whichever target needs the most. Within
+// scaleTolerance of a target the current number is kept. It returns false if
+// no target set in a has a known load.
+func recommend(a *aiv1alpha1.AutoscalingSpec, current int32, load metrics.Load) (int32, bool) {
+	var recommended int32
+	found := false
+	consider := func(total, target float64) {
+		if math.IsNaN(total) || target <= 0 {
+			return
+		}
+		found = true
+		n := current
+		if current == 0 || math.Abs(total/(target*float64(current))-1) > scaleTolerance {
+			n = int32(math.Ceil(total / target))
+		}
+		recommended = max(recommended, n)
+	}
+	if a.TargetTokensPerSecond != nil {
+		consider(load.TokensPerSecond, a.TargetTokensPerSecond.AsApproximateFloat64())
+	}
+	if a.TargetInFlightRequests != nil {
+		consider(load.InFlightRequests, float64(*a.TargetInFlightRequests))
+	}
+	return recommended, found
+}
+
+// scaleRecommendation is what the load called for at one time.
+type scaleRecommendation struct {
+	at       time.Time
+	replicas int32
+}
+
+// scaleHistory keeps each autoscaled model's recent recommendations so that
+// brief spikes and dips in its load do not add and remove pods. The zero value
+// is ready to use.
+type scaleHistory struct {
+	mu      sync.Mutex
+	history map[types.NamespacedName][]scaleRecommendation
+}
+
+// stabilize records recommended for key at now and returns the number of pods
+// to run instead of current. As with the HorizontalPodAutoscaler, pods are
+// only added up to the lowest recommendation within the up window and only
+// removed down to the highest within the down window.
+func (h *scaleHistory) stabilize(key types.NamespacedName, current, recommended int32, now time.Time, up, down time.Duration) int32 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.history == nil {
+		h.history = map[types.NamespacedName][]scaleRecommendation{}
+	}
+
+	upTo, downTo := recommended, recommended
+	kept := []scaleRecommendation{{at: now, replicas: recommended}}
+	for _, rec := range h.history[key] {
+		age := now.Sub(rec.at)
+		if age < up {
+			upTo = min(upTo, rec.replicas)
+		}
+		if age < down {
+			downTo = max(downTo, rec.replicas)
+		}
+		if age < max(up, down) {
+			kept = append(kept, rec)
+		}
+	}
+	h.history[key] = kept
+
+	switch {
+	case current < upTo:
+		return upTo
+	case current > downTo:
+		return downTo
+	}
+	return current
+}
+
+// forget drops key's recommendations, once it is deleted, idle or no longer
+// autoscaled.
+func (h *scaleHistory) forget(key types.NamespacedName) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.history, key)
+}
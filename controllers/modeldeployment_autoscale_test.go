@@ -0,0 +1,194 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+func TestRecommend(t *testing.T) {
+	tps := resource.MustParse("100")
+	a := &aiv1alpha1.AutoscalingSpec{MaxReplicas: 10, TargetTokensPerSecond: &tps, TargetInFlightRequests: pointer.Int32(8)}
+	nan := math.NaN()
+
+	for _, tc := range []struct {
+		name    string
+		current int32
+		load    metrics.Load
+		want    int32
+		wantOK  bool
+	}{
+		{name: "throughput needs more pods", current: 2, load: metrics.Load{TokensPerSecond: 450, InFlightRequests: 4}, want: 5, wantOK: true},
+		{name: "queue needs more pods", current: 2, load: metrics.Load{TokensPerSecond: 150, InFlightRequests: 40}, want: 5, wantOK: true},
+		{name: "within tolerance", current: 4, load: metrics.Load{TokensPerSecond: 430, InFlightRequests: nan}, want: 4, wantOK: true},
+		{name: "idle", current: 3, load: metrics.Load{TokensPerSecond: 0, InFlightRequests: 0}, want: 0, wantOK: true},
+		{name: "unknown load", current: 3, load: metrics.Load{TokensPerSecond: nan, InFlightRequests: nan}, wantOK: false},
+	} {
+		got, ok := recommend(a, tc.current, tc.load)
+		if ok != tc.wantOK || (ok && got != tc.want) {
+			t.Errorf("%s: recommend = %d, %v; want %d, %v", tc.name, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestStabilize(t *testing.T) {
+	var h scaleHistory
+	key := types.NamespacedName{Name: "chat", Namespace: "default"}
+	start := time.Now()
+	up, down := 30*time.Second, 2*time.Minute
+
+	steps := []struct {
+		after       time.Duration
+		current     int32
+		recommended int32
+		want        int32
+	}{
+		{after: 0, current: 2, recommended: 2, want: 2},
+		// A spike only adds pods once it outlasts the up window.
+		{after: 15 * time.Second, current: 2, recommended: 6, want: 2},
+		{after: 45 * time.Second, current: 2, recommended: 6, want: 6},
+		// A dip only removes them once it outlasts the down window.
+		{after: time.Minute, current: 6, recommended: 1, want: 6},
+		{after: 2 * time.Minute, current: 6, recommended: 1, want: 6},
+		{after: 3 * time.Minute, current: 6, recommended: 1, want: 1},
+	}
+	for i, s := range steps {
+		if got := h.stabilize(key, s.current, s.recommended, start.Add(s.after), up, down); got != s.want {
+			t.Errorf("step %d: stabilize = %d, want %d", i, got, s.want)
+		}
+	}
+
+	h.forget(key)
+	if got := h.stabilize(key, 6, 1, start.Add(3*time.Minute), up, down); got != 1 {
+		t.Errorf("expected a forgotten model to scale at once, got %d", got)
+	}
+}
+
+func TestDesiredReplicas(t *testing.T) {
+	vllm, _ := backend.Lookup("vllm")
+	newModel := func(a *aiv1alpha1.AutoscalingSpec) *aiv1alpha1.ModelDeployment {
+		return &aiv1alpha1.ModelDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+			Spec:       aiv1alpha1.ModelDeploymentSpec{Backend: "vllm", Model: "meta-llama/Llama-3-8B", Replicas: pointer.Int32(3), Autoscaling: a},
+		}
+	}
+	scalingActive := func(m *aiv1alpha1.ModelDeployment) string {
+		c := meta.FindStatusCondition(m.Status.Conditions, aiv1alpha1.ConditionScalingActive)
+		if c == nil {
+			return ""
+		}
+		return string(c.Status) + "/" + c.Reason
+	}
+	noWindow := &metav1.Duration{}
+
+	load := &metrics.FakeLoadSource{Value: metrics.Load{TokensPerSecond: math.NaN(), InFlightRequests: 50}}
+	r := &ModelDeploymentReconciler{Load: load}
+	m := newModel(&aiv1alpha1.AutoscalingSpec{
+		MinReplicas:                  pointer.Int32(2),
+		MaxReplicas:                  4,
+		TargetInFlightRequests:       pointer.Int32(10),
+		ScaleDownStabilizationWindow: noWindow,
+	})
+	if got := r.desiredReplicas(context.Background(), m, vllm, 2); got != 4 {
+		t.Errorf("expected 50 requests in flight to be capped at 4 replicas, got %d", got)
+	}
+	if got := scalingActive(m); got != "True/"+aiv1alpha1.ReasonValidMetrics {
+		t.Errorf("ScalingActive = %s", got)
+	}
+	if q := load.Queries[0]; q.Namespace != "default" || q.Deployment != "chat" || len(q.QueueMetrics) != 2 {
+		t.Errorf("unexpected load query %+v", q)
+	}
+
+	load.Value.InFlightRequests = 0
+	if got := r.desiredReplicas(context.Background(), m, vllm, 4); got != 2 {
+		t.Errorf("expected an idle model to scale down to MinReplicas, got %d", got)
+	}
+
+	// Without a reading the size is left alone, within the bounds.
+	load.Err = errors.New("connection refused")
+	if got := r.desiredReplicas(context.Background(), m, vllm, 3); got != 3 {
+		t.Errorf("expected the size to be kept when the load is unknown, got %d", got)
+	}
+	if got := scalingActive(m); got != "False/"+aiv1alpha1.ReasonMetricsUnavailable {
+		t.Errorf("ScalingActive = %s", got)
+	}
+	if got := (&ModelDeploymentReconciler{}).desiredReplicas(context.Background(), m, vllm, 7); got != 4 {
+		t.Errorf("expected the size to be kept within MaxReplicas, got %d", got)
+	}
+
+	m = newModel(&aiv1alpha1.AutoscalingSpec{MaxReplicas: 4})
+	r.desiredReplicas(context.Background(), m, vllm, 1)
+	if got := scalingActive(m); got != "False/"+aiv1alpha1.ReasonMissingTarget {
+		t.Errorf("ScalingActive = %s", got)
+	}
+
+	// Dropping autoscaling goes back to Replicas.
+	m.Spec.Autoscaling = nil
+	if got := r.desiredReplicas(context.Background(), m, vllm, 1); got != 3 {
+		t.Errorf("expected Replicas without autoscaling, got %d", got)
+	}
+	if got := scalingActive(m); got != "" {
+		t.Errorf("expected no ScalingActive condition without autoscaling, got %s", got)
+	}
+}
+
+func TestDesiredReplicasIdle(t *testing.T) {
+	vllm, _ := backend.Lookup("vllm")
+	now := time.Now()
+	m := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default", CreationTimestamp: metav1.NewTime(now.Add(-time.Hour))},
+		Spec: aiv1alpha1.ModelDeploymentSpec{
+			Backend:     "vllm",
+			Model:       "meta-llama/Llama-3-8B",
+			Replicas:    pointer.Int32(2),
+			IdleTimeout: &metav1.Duration{Duration: 10 * time.Minute},
+		},
+	}
+	r := &ModelDeploymentReconciler{}
+	if got := r.desiredReplicas(context.Background(), m, vllm, 2); got != 0 {
+		t.Errorf("expected a model without requests for an hour to scale to zero, got %d", got)
+	}
+
+	// A request through the activator brings it back.
+	m.Annotations = map[string]string{aiv1alpha1.AnnotationLastRequest: now.UTC().Format(time.RFC3339)}
+	if got := r.desiredReplicas(context.Background(), m, vllm, 0); got != 2 {
+		t.Errorf("expected an activated model to scale back to Replicas, got %d", got)
+	}
+	if d := untilIdle(m, now); d <= 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("expected the model to go idle in 10m, got %s", d)
+	}
+
+	m.Spec.Autoscaling = &aiv1alpha1.AutoscalingSpec{MinReplicas: pointer.Int32(1), MaxReplicas: 4, TargetInFlightRequests: pointer.Int32(10)}
+	r.Load = &metrics.FakeLoadSource{Value: metrics.Load{TokensPerSecond: math.NaN(), InFlightRequests: math.NaN()}}
+	if got := r.desiredReplicas(context.Background(), m, vllm, 0); got != 1 {
+		t.Errorf("expected an activated autoscaled model to start at MinReplicas, got %d", got)
+	}
+}
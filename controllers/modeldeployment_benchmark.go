@@ -0,0 +1,613 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/flexinfer/flexinfer/agents/benchmarker"
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/deviceclass"
+)
+
+const (
+	// labelDeviceClass records which device class a benchmark Job measures.
+	labelDeviceClass = "flexinfer.ai/device-class"
+	// labelBenchmarkRevision records which revision of the benchmark request a Job answers.
+	labelBenchmarkRevision = "flexinfer.ai/benchmark-revision"
+	// annotationBenchmarkAttempt numbers the benchmark Jobs of a device class, starting at 1.
+	annotationBenchmarkAttempt = "flexinfer.ai/benchmark-attempt"
+
+	benchmarkContainerName = "flexinfer-bench"
+
+	// benchmarkPollInterval is how often running benchmarks are checked on.
+	benchmarkPollInterval = 30 * time.Second
+	// A failed benchmark is retried after benchmarkBackoffBase, doubling with
+	// each attempt up to benchmarkBackoffMax.
+	benchmarkBackoffBase = 30 * time.Second
+	benchmarkBackoffMax  = 10 * time.Minute
+
+	defaultBenchmarkRetryLimit = 3
+)
+
+// reconcileBenchmarks makes sure a benchmark Job exists for every device
+// class in the cluster and collects their results into status. A nil class
+// stands for a single unpinned benchmark, used when no node carries agent
+// labels. Failed Jobs are retried with exponential backoff up to
+// BenchmarkSpec.RetryLimit times.
+//
+// Results are tied to a revision of the benchmark request, which changes with
+// the model, backend, backend image, rebenchmark annotation and schedule.
+// Results for another model or backend are discarded; otherwise outdated
+// results stay in use while a fresh Job replaces them.
+//
+// It returns true once the deployment may proceed: at least one class is
+// done, so the model can be deployed while newly-seen classes are still
+// benchmarking, or every class failed and DeployOnFailure is set. The returned
+// duration is when the benchmarks need checking on again, zero if never.
+func (r *ModelDeploymentReconciler) reconcileBenchmarks(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver) (bool, time.Duration, error) {
+	log := log.FromContext(ctx)
+
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		log.Error(err, "Failed to list Nodes")
+		return false, 0, err
+	}
+	var classes []*deviceclass.Class
+	for _, c := range deviceclass.Distinct(nodes.Items) {
+		c := c
+		classes = append(classes, &c)
+	}
+	if len(classes) == 0 {
+		classes = []*deviceclass.Class{nil}
+	}
+
+	retryLimit := int32(defaultBenchmarkRetryLimit)
+	if b := m.Spec.Benchmark; b != nil && b.RetryLimit != nil {
+		retryLimit = *b.RetryLimit
+	}
+
+	requeue, err := updateBenchmarkSchedule(m, time.Now())
+	if err != nil {
+		setCondition(m, aiv1alpha1.ConditionDegraded, metav1.ConditionTrue, aiv1alpha1.ReasonInvalidSchedule, err.Error())
+	} else {
+		clearDegraded(m, aiv1alpha1.ReasonInvalidSchedule)
+	}
+	revision := r.benchmarkRevision(m, driver)
+	if err := r.deleteOutdatedBenchmarkJobs(ctx, m, revision); err != nil {
+		return false, 0, err
+	}
+
+	var results []aiv1alpha1.DeviceClassBenchmark
+	var waiting, failures []string
+	finished, exhausted := 0, 0
+	for _, class := range classes {
+		name := className(class)
+
+		cm := &corev1.ConfigMap{}
+		err := r.Get(ctx, types.NamespacedName{Name: benchmarker.ResultsConfigMapName(m.Name, name), Namespace: m.Namespace}, cm)
+		hasResults := false
+		switch {
+		case err == nil && !benchmarkResultsMatch(cm, m, driver):
+			// Results for another model or backend say nothing about this one.
+			log.Info("Deleting outdated Benchmark ConfigMap", "ConfigMap.Name", cm.Name)
+			if err := r.Delete(ctx, cm); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Failed to delete Benchmark ConfigMap", "ConfigMap.Name", cm.Name)
+				return false, 0, err
+			}
+		case err == nil:
+			results = append(results, benchmarkFromConfigMap(cm, name))
+			if cm.Data[benchmarker.KeyRevision] == revision {
+				continue
+			}
+			// Keep using these results until the refresh succeeds.
+			hasResults = true
+		case !errors.IsNotFound(err):
+			log.Error(err, "Failed to get Benchmark ConfigMap", "deviceClass", name)
+			return false, 0, err
+		}
+
+		// No current results: make sure a Job is running for this class.
+		job, err := r.latestBenchmarkJob(ctx, m, name, revision)
+		if err != nil {
+			log.Error(err, "Failed to list Benchmark Jobs", "deviceClass", name)
+			return false, 0, err
+		}
+		if job == nil {
+			if job, err = r.createBenchmarkJob(ctx, m, driver, class, revision, 1); err != nil {
+				return false, 0, err
+			}
+			waiting = append(waiting, job.Name)
+			requeue = minRequeue(requeue, benchmarkPollInterval)
+			continue
+		}
+
+		// The results ConfigMap may not be in our cache yet when the Job
+		// reports success; its watch will bring us back.
+		if job.Status.Succeeded > 0 {
+			if !hasResults {
+				finished++
+			}
+			continue
+		}
+		failedAt, failed := jobFailedTime(job)
+		if !failed {
+			waiting = append(waiting, job.Name)
+			requeue = minRequeue(requeue, benchmarkPollInterval)
+			continue
+		}
+
+		attempt := benchmarkAttempt(job)
+		failures = append(failures, fmt.Sprintf("%s failed: %s", job.Name, r.benchmarkFailureMessage(ctx, job)))
+		if attempt > retryLimit {
+			exhausted++
+			continue
+		}
+		if wait := time.Until(failedAt.Add(benchmarkBackoff(attempt))); wait > 0 {
+			requeue = minRequeue(requeue, wait)
+			continue
+		}
+		if job, err = r.createBenchmarkJob(ctx, m, driver, class, revision, attempt+1); err != nil {
+			return false, 0, err
+		}
+		waiting = append(waiting, job.Name)
+		requeue = minRequeue(requeue, benchmarkPollInterval)
+	}
+
+	recordBenchmarkResults(m, results)
+
+	done := len(results) + finished
+	switch {
+	case done > 0:
+		msg := fmt.Sprintf("%d/%d device classes benchmarked", done, len(classes))
+		if len(waiting) > 0 {
+			msg += fmt.Sprintf("; waiting for %s", strings.Join(waiting, ", "))
+		}
+		if len(failures) > 0 {
+			msg += "; " + strings.Join(failures, "; ")
+		}
+		setCondition(m, aiv1alpha1.ConditionBenchmarked, metav1.ConditionTrue, aiv1alpha1.ReasonBenchmarkComplete, msg)
+		clearDegraded(m, aiv1alpha1.ReasonBenchmarkFailed)
+		return true, requeue, nil
+	case exhausted == len(classes):
+		msg := strings.Join(failures, "; ")
+		if m.Spec.Benchmark != nil && m.Spec.Benchmark.DeployOnFailure {
+			log.Info("Benchmark retries exhausted, deploying without results")
+			setCondition(m, aiv1alpha1.ConditionBenchmarked, metav1.ConditionFalse, aiv1alpha1.ReasonBenchmarkUnavailable,
+				"Deployed without benchmark results: "+msg)
+			clearDegraded(m, aiv1alpha1.ReasonBenchmarkFailed)
+			return true, requeue, nil
+		}
+		// Nothing left to retry; a new revision, e.g. from the rebenchmark
+		// annotation, starts over.
+		setCondition(m, aiv1alpha1.ConditionBenchmarked, metav1.ConditionFalse, aiv1alpha1.ReasonBenchmarkFailed, msg)
+		setCondition(m, aiv1alpha1.ConditionDegraded, metav1.ConditionTrue, aiv1alpha1.ReasonBenchmarkFailed,
+			fmt.Sprintf("Benchmark failed after %d retries: %s", retryLimit, msg))
+		return false, requeue, nil
+	case len(failures) > 0:
+		setCondition(m, aiv1alpha1.ConditionBenchmarked, metav1.ConditionFalse, aiv1alpha1.ReasonBenchmarkRetrying,
+			strings.Join(failures, "; "))
+	default:
+		setCondition(m, aiv1alpha1.ConditionBenchmarked, metav1.ConditionFalse, aiv1alpha1.ReasonBenchmarkRunning,
+			fmt.Sprintf("Waiting for benchmark Jobs to complete: %s", strings.Join(waiting, ", ")))
+	}
+	clearDegraded(m, aiv1alpha1.ReasonBenchmarkFailed)
+	return false, requeue, nil
+}
+
+// benchmarkRevision identifies the benchmark request m currently makes of
+// driver. It is short enough to be part of Job names.
+func (r *ModelDeploymentReconciler) benchmarkRevision(m *aiv1alpha1.ModelDeployment, driver backend.Driver) string {
+	var scheduled string
+	if t := m.Status.ScheduledBenchmarkTime; t != nil {
+		scheduled = t.UTC().Format(time.RFC3339)
+	}
+	h := sha256.New()
+	for _, v := range []string{m.Spec.Model, driver.Name(), r.backendImage(driver), m.Annotations[aiv1alpha1.AnnotationRebenchmark], scheduled} {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// updateBenchmarkSchedule advances Status.ScheduledBenchmarkTime to the most
+// recent activation of the benchmark schedule and returns the time until the
+// next one, or zero if there is no schedule.
+func updateBenchmarkSchedule(m *aiv1alpha1.ModelDeployment, now time.Time) (time.Duration, error) {
+	if m.Spec.Benchmark == nil || m.Spec.Benchmark.Schedule == "" {
+		return 0, nil
+	}
+	schedule, err := parseBenchmarkSchedule(m.Spec.Benchmark.Schedule)
+	if err != nil {
+		return 0, err
+	}
+	last := m.CreationTimestamp.Time
+	if t := m.Status.ScheduledBenchmarkTime; t != nil {
+		last = t.Time
+	}
+	for next := schedule.Next(last); !next.After(now); next = schedule.Next(next) {
+		last = next
+		t := metav1.NewTime(next)
+		m.Status.ScheduledBenchmarkTime = &t
+	}
+	return schedule.Next(last).Sub(now), nil
+}
+
+// parseBenchmarkSchedule accepts either an interval such as "24h" or a
+// standard cron expression.
+func parseBenchmarkSchedule(spec string) (cron.Schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < time.Minute {
+			return nil, fmt.Errorf("benchmark schedule %q: interval must be at least 1m", spec)
+		}
+		return cron.Every(d), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("benchmark schedule %q is neither an interval nor a cron expression: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// benchmarkResultsMatch reports whether cm holds results for m's model and
+// backend.
+func benchmarkResultsMatch(cm *corev1.ConfigMap, m *aiv1alpha1.ModelDeployment, driver backend.Driver) bool {
+	return cm.Data[benchmarker.KeyModel] == m.Spec.Model && cm.Data[benchmarker.KeyBackend] == driver.Name()
+}
+
+// deleteOutdatedBenchmarkJobs deletes m's benchmark Jobs for revisions other
+// than revision, so they cannot overwrite newer results.
+func (r *ModelDeploymentReconciler) deleteOutdatedBenchmarkJobs(ctx context.Context, m *aiv1alpha1.ModelDeployment, revision string) error {
+	log := log.FromContext(ctx)
+
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(m.Namespace), client.MatchingLabels{benchmarker.LabelModelDeployment: m.Name}); err != nil {
+		log.Error(err, "Failed to list Benchmark Jobs")
+		return err
+	}
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+		if job.Labels[labelBenchmarkRevision] == revision || job.DeletionTimestamp != nil {
+			continue
+		}
+		log.Info("Deleting outdated Benchmark Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			log.Error(err, "Failed to delete Benchmark Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// latestBenchmarkJob returns the benchmark Job with the highest attempt
+// number for class and revision, or nil if there is none.
+func (r *ModelDeploymentReconciler) latestBenchmarkJob(ctx context.Context, m *aiv1alpha1.ModelDeployment, class, revision string) (*batchv1.Job, error) {
+	jobs := &batchv1.JobList{}
+	if err := r.List(ctx, jobs, client.InNamespace(m.Namespace), client.MatchingLabels{
+		benchmarker.LabelModelDeployment: m.Name,
+		labelDeviceClass:                 class,
+		labelBenchmarkRevision:           revision,
+	}); err != nil {
+		return nil, err
+	}
+	var latest *batchv1.Job
+	for i := range jobs.Items {
+		if latest == nil || benchmarkAttempt(&jobs.Items[i]) > benchmarkAttempt(latest) {
+			latest = &jobs.Items[i]
+		}
+	}
+	return latest, nil
+}
+
+// createBenchmarkJob creates the Job for the given attempt at benchmarking
+// class for revision. Job names are deterministic, so an attempt our cache
+// has not seen yet is not created twice.
+func (r *ModelDeploymentReconciler) createBenchmarkJob(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver, class *deviceclass.Class, revision string, attempt int32) (*batchv1.Job, error) {
+	log := log.FromContext(ctx)
+
+	job := r.jobForBenchmark(m, driver, class, revision, attempt)
+	log.Info("Creating a new Benchmark Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name, "attempt", attempt)
+	if err := r.Create(ctx, job); err != nil && !errors.IsAlreadyExists(err) {
+		log.Error(err, "Failed to create new Benchmark Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
+		return nil, err
+	}
+	return job, nil
+}
+
+// benchmarkFailureMessage returns why a benchmark Job failed, preferring the
+// benchmark container's termination message over the Job's own condition.
+func (r *ModelDeploymentReconciler) benchmarkFailureMessage(ctx context.Context, job *batchv1.Job) string {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list Benchmark Pods", "Job.Name", job.Name)
+	}
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name == benchmarkContainerName && cs.State.Terminated != nil && cs.State.Terminated.Message != "" {
+				return strings.TrimSpace(cs.State.Terminated.Message)
+			}
+		}
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue && c.Message != "" {
+			return c.Message
+		}
+	}
+	return "unknown error"
+}
+
+// jobFailedTime returns when job failed, if it has.
+func jobFailedTime(job *batchv1.Job) (time.Time, bool) {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// benchmarkAttempt returns the attempt number of a benchmark Job.
+func benchmarkAttempt(job *batchv1.Job) int32 {
+	n, err := strconv.ParseInt(job.Annotations[annotationBenchmarkAttempt], 10, 32)
+	if err != nil || n < 1 {
+		return 1
+	}
+	return int32(n)
+}
+
+// benchmarkBackoff returns how long to wait before retrying a benchmark whose
+// attempt-th Job failed.
+func benchmarkBackoff(attempt int32) time.Duration {
+	d := benchmarkBackoffBase
+	for i := int32(1); i < attempt && d < benchmarkBackoffMax; i++ {
+		d *= 2
+	}
+	if d > benchmarkBackoffMax {
+		d = benchmarkBackoffMax
+	}
+	return d
+}
+
+// minRequeue returns the sooner of two requeue delays, where zero means never.
+func minRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// benchmarkFromConfigMap reads one device class's results ConfigMap.
+func benchmarkFromConfigMap(cm *corev1.ConfigMap, class string) aiv1alpha1.DeviceClassBenchmark {
+	b := aiv1alpha1.DeviceClassBenchmark{
+		DeviceClass:     class,
+		TokensPerSecond: cm.Data[benchmarker.KeyTokensPerSecond],
+	}
+	// Unpinned benchmarks report whatever class they happened to land on.
+	if b.DeviceClass == "" {
+		b.DeviceClass = cm.Data[benchmarker.KeyDeviceClass]
+	}
+	if ts, err := time.Parse(time.RFC3339, cm.Data[benchmarker.KeyTimestamp]); err == nil {
+		t := metav1.NewTime(ts)
+		b.LastBenchmarkTime = &t
+	}
+	return b
+}
+
+// recordBenchmarkResults stores the per-class results in status and
+// summarizes the fastest class in the top-level fields.
+func recordBenchmarkResults(m *aiv1alpha1.ModelDeployment, results []aiv1alpha1.DeviceClassBenchmark) {
+	sort.Slice(results, func(i, j int) bool { return results[i].DeviceClass < results[j].DeviceClass })
+	m.Status.Benchmarks = results
+
+	best, bestTPS := -1, -1.0
+	for i, b := range results {
+		if tps, err := strconv.ParseFloat(b.TokensPerSecond, 64); err == nil && tps > bestTPS {
+			best, bestTPS = i, tps
+		}
+	}
+	if best < 0 {
+		m.Status.TokensPerSecond = ""
+		m.Status.DeviceClass = ""
+		m.Status.LastBenchmarkTime = nil
+		return
+	}
+	m.Status.TokensPerSecond = results[best].TokensPerSecond
+	m.Status.DeviceClass = results[best].DeviceClass
+	m.Status.LastBenchmarkTime = results[best].LastBenchmarkTime
+}
+
+// jobForBenchmark returns the attempt-th benchmark Job object for class and
+// revision, or an unpinned one if class is nil. The backend runs as a native
+// sidecar so the benchmark container can drive it over localhost and the Job
+// completes once the benchmark exits.
+func (r *ModelDeploymentReconciler) jobForBenchmark(m *aiv1alpha1.ModelDeployment, driver backend.Driver, class *deviceclass.Class, revision string, attempt int32) *batchv1.Job {
+	name := className(class)
+	sidecarRestart := corev1.ContainerRestartPolicyAlways
+	sidecar := backend.ServingContainer(driver, m.Spec.Model, r.backendImage(driver))
+	sidecar.RestartPolicy = &sidecarRestart
+	sidecar.Resources = servingResources(m)
+
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      benchmarkJobName(m, name, revision, attempt),
+			Namespace: m.Namespace,
+			Labels: map[string]string{
+				benchmarker.LabelModelDeployment: m.Name,
+				labelDeviceClass:                 name,
+				labelBenchmarkRevision:           revision,
+			},
+			Annotations: map[string]string{
+				annotationBenchmarkAttempt: strconv.Itoa(int(attempt)),
+			},
+		},
+		Spec: batchv1.JobSpec{
+			// Retries are ours, with backoff and a termination message per attempt.
+			BackoffLimit: pointer.Int32(0),
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{sidecar},
+					Containers: []corev1.Container{{
+						Image: "flexinfer-bench:latest", // This will be built locally
+						Name:  benchmarkContainerName,
+						Args:  benchmarkArgs(m, driver, name, revision),
+						// flexinfer-bench writes its error to the termination log;
+						// fall back to its output if it died before it could.
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						Env: []corev1.EnvVar{{
+							Name: "POD_NAMESPACE",
+							ValueFrom: &corev1.EnvVarSource{
+								FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
+							},
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name:         backend.ModelCacheVolume,
+						VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+					}},
+					RestartPolicy: corev1.RestartPolicyNever,
+				},
+			},
+		},
+	}
+	if class != nil {
+		class.Pin(&job.Spec.Template.Spec)
+	}
+	ctrl.SetControllerReference(m, job, r.Scheme)
+	return job
+}
+
+// benchmarkArgs translates the ModelDeployment's BenchmarkSpec into
+// flexinfer-bench flags.
+func benchmarkArgs(m *aiv1alpha1.ModelDeployment, driver backend.Driver, class, revision string) []string {
+	args := []string{
+		"--model", m.Spec.Model,
+		"--configmap", benchmarker.ResultsConfigMapName(m.Name, class),
+		"--modeldeployment", m.Name,
+		"--backend", driver.Name(),
+		"--revision", revision,
+		"--endpoint", fmt.Sprintf("http://localhost:%d", driver.Port()),
+		"--api", string(driver.API()),
+	}
+	if class != "" {
+		args = append(args, "--device-class", class)
+	}
+	if probe := driver.ReadinessProbe(); probe != nil && probe.HTTPGet != nil {
+		args = append(args, "--ready-path", probe.HTTPGet.Path)
+	}
+	b := m.Spec.Benchmark
+	if b == nil {
+		return args
+	}
+	if b.WarmupIterations != nil {
+		args = append(args, "--warmup", strconv.Itoa(int(*b.WarmupIterations)))
+	}
+	if b.MinDuration != nil {
+		args = append(args, "--min-duration", b.MinDuration.Duration.String())
+	}
+	if b.MaxTokens != nil {
+		args = append(args, "--max-tokens", strconv.Itoa(int(*b.MaxTokens)))
+	}
+	for _, p := range b.Prompts {
+		args = append(args, "--prompt", p)
+	}
+	return args
+}
+
+// benchmarkJobName returns the name of the attempt-th benchmark Job for
+// class and revision; an empty class is the unpinned benchmark.
+//
+// The Job controller copies the name into the job-name label of its pods, so
+// it must fit in a label value. Longer names have the ModelDeployment's name
+// cut short, or everything if the rest alone is too long, and a hash of the
+// full name appended to keep them apart.
+func benchmarkJobName(m *aiv1alpha1.ModelDeployment, class, revision string, attempt int32) string {
+	suffix := "-benchmark-" + revision
+	if class != "" {
+		suffix += "-" + class
+	}
+	if attempt > 1 {
+		suffix += fmt.Sprintf("-retry-%d", attempt-1)
+	}
+	name := m.Name + suffix
+	if len(name) <= validation.DNS1123LabelMaxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	hash := "-" + hex.EncodeToString(sum[:])[:8]
+	if keep := validation.DNS1123LabelMaxLength - len(hash) - len(suffix); keep > 0 {
+		return strings.TrimRight(m.Name[:keep], "-.") + hash + suffix
+	}
+	return strings.TrimRight(name[:validation.DNS1123LabelMaxLength-len(hash)], "-.") + hash
+}
+
+// className returns the name of class, or "" for the unpinned benchmark.
+func className(class *deviceclass.Class) string {
+	if class == nil {
+		return ""
+	}
+	return class.String()
+}
+
+// isBenchmarkResults reports whether obj is a benchmark results ConfigMap.
+func isBenchmarkResults(obj client.Object) bool {
+	_, ok := obj.GetLabels()[benchmarker.LabelModelDeployment]
+	return ok
+}
+
+// benchmarkResultsToModelDeployment maps a results ConfigMap to the
+// ModelDeployment that requested it.
+func benchmarkResultsToModelDeployment(ctx context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      obj.GetLabels()[benchmarker.LabelModelDeployment],
+		Namespace: obj.GetNamespace(),
+	}}}
+}
+
+// allModelDeployments enqueues every ModelDeployment in the cluster.
+func (r *ModelDeploymentReconciler) allModelDeployments(ctx context.Context, obj client.Object) []reconcile.Request {
+	list := &aiv1alpha1.ModelDeploymentList{}
+	if err := r.List(ctx, list); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list ModelDeployments")
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, m := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: m.Name, Namespace: m.Namespace}})
+	}
+	return requests
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/flexinfer/flexinfer/agents/benchmarker"
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+)
+
+func TestUpdateBenchmarkSchedule(t *testing.T) {
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)},
+		Spec:       aiv1alpha1.ModelDeploymentSpec{Benchmark: &aiv1alpha1.BenchmarkSpec{Schedule: "0 3 * * *"}},
+	}
+
+	// Before the first activation nothing is scheduled yet.
+	wait, err := updateBenchmarkSchedule(m, created.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Status.ScheduledBenchmarkTime != nil {
+		t.Fatalf("expected no scheduled benchmark, got %v", m.Status.ScheduledBenchmarkTime)
+	}
+	if wait != 2*time.Hour {
+		t.Fatalf("expected to wait 2h, got %v", wait)
+	}
+
+	// Missed activations collapse into the most recent one.
+	wait, err = updateBenchmarkSchedule(m, created.Add(50*time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := created.Add(27 * time.Hour); !m.Status.ScheduledBenchmarkTime.Time.Equal(want) {
+		t.Fatalf("expected scheduled benchmark at %v, got %v", want, m.Status.ScheduledBenchmarkTime.Time)
+	}
+	if wait != time.Hour {
+		t.Fatalf("expected to wait 1h, got %v", wait)
+	}
+}
+
+func TestParseBenchmarkSchedule(t *testing.T) {
+	for _, spec := range []string{"24h", "90m", "0 3 * * *", "@daily"} {
+		if _, err := parseBenchmarkSchedule(spec); err != nil {
+			t.Errorf("%q: unexpected error: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"10s", "tomorrow", "61 * * * *"} {
+		if _, err := parseBenchmarkSchedule(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestBenchmarkBackoff(t *testing.T) {
+	for attempt, want := range map[int32]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		10: 10 * time.Minute,
+	} {
+		if got := benchmarkBackoff(attempt); got != want {
+			t.Errorf("attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
+
+func TestBenchmarkJobName(t *testing.T) {
+	short := &aiv1alpha1.ModelDeployment{ObjectMeta: metav1.ObjectMeta{Name: "llama"}}
+	if got := benchmarkJobName(short, "nvidia-a100", "0123abcd", 2); got != "llama-benchmark-0123abcd-nvidia-a100-retry-1" {
+		t.Errorf("unexpected name for a short ModelDeployment: %s", got)
+	}
+
+	// Two ModelDeployments whose names only differ past the cut.
+	prefix := strings.Repeat("a", 60)
+	long := &aiv1alpha1.ModelDeployment{ObjectMeta: metav1.ObjectMeta{Name: prefix + "-x"}}
+	other := &aiv1alpha1.ModelDeployment{ObjectMeta: metav1.ObjectMeta{Name: prefix + "-y"}}
+	seen := map[string]bool{}
+	for _, class := range []string{"", "nvidia-a100", strings.Repeat("amd-mi300x-", 6) + "192gi"} {
+		for _, attempt := range []int32{1, 2} {
+			for _, m := range []*aiv1alpha1.ModelDeployment{long, other} {
+				name := benchmarkJobName(m, class, "0123abcd", attempt)
+				if len(name) > validation.DNS1123LabelMaxLength {
+					t.Errorf("%s is longer than %d characters", name, validation.DNS1123LabelMaxLength)
+				}
+				if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+					t.Errorf("%s is not a valid label: %v", name, errs)
+				}
+				if seen[name] {
+					t.Errorf("%s is not unique", name)
+				}
+				seen[name] = true
+			}
+		}
+		// Results are read back by ConfigMap name, which is not shortened.
+		if benchmarker.ResultsConfigMapName(long.Name, class) == benchmarker.ResultsConfigMapName(other.Name, class) {
+			t.Errorf("results of %s and %s share a ConfigMap", long.Name, other.Name)
+		}
+	}
+}
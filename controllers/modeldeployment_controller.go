@@ -18,29 +18,47 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
 )
 
 // ModelDeploymentReconciler reconciles a ModelDeployment object
 type ModelDeploymentReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Load reports the traffic on autoscaled models. Without it they stay at
+	// their current size.
+	Load metrics.LoadSource
+	// Canary reports how canaries of new models have served. Without it they
+	// are promoted once they have been ready for their analysis duration.
+	Canary metrics.CanarySource
+
+	scaleHistory scaleHistory
 }
 
 //+kubebuilder:rbac:groups=ai.flexinfer,resources=modeldeployments,verbs=get;list;watch;create;update;patch;delete
@@ -51,6 +69,8 @@ type ModelDeploymentReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -63,46 +83,59 @@ func (r *ModelDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Info("ModelDeployment resource not found. Ignoring since object must be deleted")
+			r.scaleHistory.forget(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		log.Error(err, "Failed to get ModelDeployment")
 		return ctrl.Result{}, err
 	}
 
-	// Check if a benchmark has been run
-	benchmarkCM := &corev1.ConfigMap{}
-	err = r.Get(ctx, types.NamespacedName{Name: r.benchmarkConfigMapName(modelDeployment), Namespace: modelDeployment.Namespace}, benchmarkCM)
-	if err != nil && errors.IsNotFound(err) {
-		// If the ConfigMap is not found, it means we need to run a benchmark.
-		// Check if a benchmark job is already running
-		benchmarkJob := &batchv1.Job{}
-		err = r.Get(ctx, types.NamespacedName{Name: r.benchmarkJobName(modelDeployment), Namespace: modelDeployment.Namespace}, benchmarkJob)
-		if err != nil && errors.IsNotFound(err) {
-			// If the Job is not found, create it
-			job := r.jobForBenchmark(modelDeployment)
-			log.Info("Creating a new Benchmark Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
-			if err = r.Create(ctx, job); err != nil {
-				log.Error(err, "Failed to create new Benchmark Job", "Job.Namespace", job.Namespace, "Job.Name", job.Name)
-				return ctrl.Result{}, err
+	// Each step records its conditions in memory; write the status back once,
+	// and only if something changed.
+	original := modelDeployment.Status.DeepCopy()
+	result, err := r.reconcileModelDeployment(ctx, modelDeployment)
+	modelDeployment.Status.ObservedGeneration = modelDeployment.Generation
+	modelDeployment.Status.Phase = phaseFor(modelDeployment)
+	if !equality.Semantic.DeepEqual(original, &modelDeployment.Status) {
+		if updateErr := r.Status().Update(ctx, modelDeployment); updateErr != nil {
+			log.Error(updateErr, "Failed to update ModelDeployment status")
+			if err == nil {
+				err = updateErr
 			}
-			return ctrl.Result{Requeue: true}, nil
-		} else if err != nil {
-			log.Error(err, "Failed to get Benchmark Job")
-			return ctrl.Result{}, err
 		}
+	}
+	return result, err
+}
 
-		// If the job is found, check its status
-		if benchmarkJob.Status.Succeeded > 0 {
-			log.Info("Benchmark job completed successfully")
-		} else {
-			// If the job is still running, requeue the request.
-			log.Info("Benchmark job is still running")
-			return ctrl.Result{RequeueAfter: 30 * time.Second}, nil
-		}
-	} else if err != nil {
-		log.Error(err, "Failed to get Benchmark ConfigMap")
+// reconcileModelDeployment walks the benchmark -> PVC -> Deployment -> Service
+// flow, creating whatever is missing and recording progress as conditions.
+func (r *ModelDeploymentReconciler) reconcileModelDeployment(ctx context.Context, modelDeployment *aiv1alpha1.ModelDeployment) (ctrl.Result, error) {
+	log := log.FromContext(ctx)
+
+	// Resolve the backend driver before creating anything that depends on it.
+	driver, ok := backend.Lookup(modelDeployment.Spec.Backend)
+	if !ok {
+		msg := fmt.Sprintf("backend %q is not supported; valid backends are: %s",
+			modelDeployment.Spec.Backend, strings.Join(backend.Names(), ", "))
+		log.Info("Unknown backend", "backend", modelDeployment.Spec.Backend)
+		setCondition(modelDeployment, aiv1alpha1.ConditionDegraded, metav1.ConditionTrue, aiv1alpha1.ReasonUnknownBackend, msg)
+		// The spec must change before we can make progress; that triggers a new reconcile.
+		return ctrl.Result{}, nil
+	}
+	clearDegraded(modelDeployment, aiv1alpha1.ReasonUnknownBackend)
+
+	// Start any canary of a new model before its benchmark results replace
+	// those of the stable model.
+	beginRollout(modelDeployment)
+
+	// Make sure every device class has been benchmarked
+	benchmarked, benchmarkRequeue, err := r.reconcileBenchmarks(ctx, modelDeployment, driver)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
+	if !benchmarked {
+		return ctrl.Result{RequeueAfter: benchmarkRequeue}, nil
+	}
 
 	// Check if the pvc already exists, if not create a new one
 	pvc := &corev1.PersistentVolumeClaim{}
@@ -115,24 +148,37 @@ func (r *ModelDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 			log.Error(err, "Failed to create new Pvc", "Pvc.Namespace", pvc.Namespace, "Pvc.Name", pvc.Name)
 			return ctrl.Result{}, err
 		}
+		setCondition(modelDeployment, aiv1alpha1.ConditionStorageReady, metav1.ConditionFalse, aiv1alpha1.ReasonClaimPending,
+			fmt.Sprintf("PersistentVolumeClaim %s created", pvc.Name))
 		// Pvc created successfully - return and requeue
 		return ctrl.Result{Requeue: true}, nil
 	} else if err != nil {
 		log.Error(err, "Failed to get Pvc")
 		return ctrl.Result{}, err
 	}
+	// Don't block on binding: claims using WaitForFirstConsumer storage only
+	// bind once the Deployment's pod is scheduled.
+	if pvc.Status.Phase == corev1.ClaimBound {
+		setCondition(modelDeployment, aiv1alpha1.ConditionStorageReady, metav1.ConditionTrue, aiv1alpha1.ReasonClaimBound,
+			fmt.Sprintf("PersistentVolumeClaim %s is bound", pvc.Name))
+	} else {
+		setCondition(modelDeployment, aiv1alpha1.ConditionStorageReady, metav1.ConditionFalse, aiv1alpha1.ReasonClaimPending,
+			fmt.Sprintf("PersistentVolumeClaim %s is %s", pvc.Name, pvc.Status.Phase))
+	}
 
 	// Check if the deployment already exists, if not create a new one
 	found := &appsv1.Deployment{}
 	err = r.Get(ctx, types.NamespacedName{Name: modelDeployment.Name, Namespace: modelDeployment.Namespace}, found)
 	if err != nil && errors.IsNotFound(err) {
 		// Define a new deployment
-		dep := r.deploymentForModelDeployment(modelDeployment)
+		dep := r.deploymentForModelDeployment(modelDeployment, driver)
 		log.Info("Creating a new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
 		if err = r.Create(ctx, dep); err != nil {
 			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
 			return ctrl.Result{}, err
 		}
+		setCondition(modelDeployment, aiv1alpha1.ConditionAvailable, metav1.ConditionFalse, aiv1alpha1.ReasonReplicasNotReady,
+			fmt.Sprintf("Deployment %s created", dep.Name))
 		// Deployment created successfully - return and requeue
 		return ctrl.Result{Requeue: true}, nil
 	} else if err != nil {
@@ -140,24 +186,53 @@ func (r *ModelDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		return ctrl.Result{}, err
 	}
 
-	// Ensure the deployment size is the same as the spec
-	size := modelDeployment.Spec.Replicas
-	if *found.Spec.Replicas != *size {
-		found.Spec.Replicas = size
+	// Ensure the deployment size is the same as the spec, or what the
+	// autoscaler recommends
+	size := r.desiredReplicas(ctx, modelDeployment, driver, *found.Spec.Replicas)
+	modelDeployment.Status.DesiredReplicas = size
+	if *found.Spec.Replicas != size {
+		if modelDeployment.Spec.Autoscaling != nil || modelDeployment.Spec.IdleTimeout != nil {
+			log.Info("Scaling Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name,
+				"from", *found.Spec.Replicas, "to", size)
+		}
+		found.Spec.Replicas = &size
 		if err = r.Update(ctx, found); err != nil {
 			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
 			return ctrl.Result{}, err
 		}
+		if modelDeployment.Spec.Autoscaling != nil || modelDeployment.Spec.IdleTimeout != nil {
+			now := metav1.Now()
+			modelDeployment.Status.LastScaleTime = &now
+		}
 		// Spec updated - return and requeue
 		return ctrl.Result{Requeue: true}, nil
 	}
 
+	// Keep the scheduling policy on the pods in step with the spec; changing
+	// it rolls the pods so they are placed under the new policy.
+	if policy := schedulingPolicyAnnotation(modelDeployment); found.Spec.Template.Annotations[aiv1alpha1.AnnotationSchedulingPolicy] != policy {
+		if policy == "" {
+			delete(found.Spec.Template.Annotations, aiv1alpha1.AnnotationSchedulingPolicy)
+		} else {
+			if found.Spec.Template.Annotations == nil {
+				found.Spec.Template.Annotations = map[string]string{}
+			}
+			found.Spec.Template.Annotations[aiv1alpha1.AnnotationSchedulingPolicy] = policy
+		}
+		if err = r.Update(ctx, found); err != nil {
+			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{Requeue: true}, nil
+	}
+	r.updateAvailability(modelDeployment, found)
+
 	// Check if the service already exists, if not create a new one
 	service := &corev1.Service{}
 	err = r.Get(ctx, types.NamespacedName{Name: modelDeployment.Name, Namespace: modelDeployment.Namespace}, service)
 	if err != nil && errors.IsNotFound(err) {
 		// Define a new service
-		svc := r.serviceForModelDeployment(modelDeployment)
+		svc := r.serviceForModelDeployment(modelDeployment, driver)
 		log.Info("Creating a new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
 		if err = r.Create(ctx, svc); err != nil {
 			log.Error(err, "Failed to create new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
@@ -170,18 +245,87 @@ func (r *ModelDeploymentReconciler) Reconcile(ctx context.Context, req ctrl.Requ
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	// Roll out a change of model, in one go or as a canary.
+	rolloutRequeue, err := r.reconcileRollout(ctx, modelDeployment, driver, found)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// Come back for device classes still benchmarking or waiting to retry, to
+	// read the load on autoscaled models, to scale idle ones to zero and to
+	// check on canaries.
+	requeue := minRequeue(benchmarkRequeue, rolloutRequeue)
+	if modelDeployment.Spec.Autoscaling != nil {
+		requeue = minRequeue(requeue, autoscaleInterval)
+	}
+	if modelDeployment.Spec.IdleTimeout != nil {
+		if d := untilIdle(modelDeployment, time.Now()); d > 0 {
+			requeue = minRequeue(requeue, d)
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
+}
+
+// updateAvailability copies the Deployment's ready replica count into status
+// and sets the Available and Degraded conditions from it.
+func (r *ModelDeploymentReconciler) updateAvailability(m *aiv1alpha1.ModelDeployment, dep *appsv1.Deployment) {
+	m.Status.ReadyReplicas = dep.Status.ReadyReplicas
+	desired := *dep.Spec.Replicas
+
+	switch {
+	case desired == 0 && m.Spec.IdleTimeout != nil:
+		setCondition(m, aiv1alpha1.ConditionAvailable, metav1.ConditionFalse, aiv1alpha1.ReasonScaledToZero,
+			fmt.Sprintf("No requests for %s; the next one through the activator scales it up", m.Spec.IdleTimeout.Duration))
+	case desired == 0:
+		setCondition(m, aiv1alpha1.ConditionAvailable, metav1.ConditionFalse, aiv1alpha1.ReasonScaledToZero, "Replicas is 0")
+	case dep.Status.ReadyReplicas >= desired:
+		setCondition(m, aiv1alpha1.ConditionAvailable, metav1.ConditionTrue, aiv1alpha1.ReasonReplicasReady,
+			fmt.Sprintf("%d/%d replicas ready", dep.Status.ReadyReplicas, desired))
+	default:
+		setCondition(m, aiv1alpha1.ConditionAvailable, metav1.ConditionFalse, aiv1alpha1.ReasonReplicasNotReady,
+			fmt.Sprintf("%d/%d replicas ready", dep.Status.ReadyReplicas, desired))
+	}
+
+	for _, c := range dep.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			setCondition(m, aiv1alpha1.ConditionDegraded, metav1.ConditionTrue, aiv1alpha1.ReasonProgressDeadlineExceeded, c.Message)
+			return
+		}
+	}
+	clearDegraded(m, aiv1alpha1.ReasonProgressDeadlineExceeded)
 }
 
 // deploymentForModelDeployment returns a ModelDeployment Deployment object
-func (r *ModelDeploymentReconciler) deploymentForModelDeployment(m *aiv1alpha1.ModelDeployment) *appsv1.Deployment {
+func (r *ModelDeploymentReconciler) deploymentForModelDeployment(m *aiv1alpha1.ModelDeployment, driver backend.Driver) *appsv1.Deployment {
 	ls := labelsForModelDeployment(m.Name)
 	replicas := m.Spec.Replicas
+	if m.Spec.Autoscaling != nil {
+		replicas = pointer.Int32(minReplicas(m.Spec.Autoscaling))
+	}
+	image := r.backendImage(driver)
+
+	container := backend.ServingContainer(driver, m.Spec.Model, image)
+	container.Resources = servingResources(m)
+	if tp, ok := driver.(backend.TensorParallel); ok && m.Spec.TensorParallelSize != nil && *m.Spec.TensorParallelSize > 1 {
+		container.Args = append(container.Args, tp.TensorParallelArgs(int(*m.Spec.TensorParallelSize))...)
+		requestGPUs(&container.Resources, m)
+	}
+
+	var annotations map[string]string
+	if policy := schedulingPolicyAnnotation(m); policy != "" {
+		annotations = map[string]string{aiv1alpha1.AnnotationSchedulingPolicy: policy}
+	}
+
+	var initContainers []corev1.Container
+	if pull := driver.PullContainer(m.Spec.Model, image); pull != nil {
+		initContainers = append(initContainers, *pull)
+	}
 
 	dep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      m.Name,
-			Namespace: m.Namespace,
+			Name:        m.Name,
+			Namespace:   m.Namespace,
+			Annotations: map[string]string{annotationModel: m.Spec.Model},
 		},
 		Spec: appsv1.DeploymentSpec{
 			Replicas: replicas,
@@ -190,33 +334,14 @@ func (r *ModelDeploymentReconciler) deploymentForModelDeployment(m *aiv1alpha1.M
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: ls,
+					Labels:      ls,
+					Annotations: annotations,
 				},
 				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Image: r.getBackendImage(),
-						Name:  "llm-backend",
-						Ports: []corev1.ContainerPort{{
-							ContainerPort: 11434,
-							Name:          "http",
-						}},
-						Resources: corev1.ResourceRequirements{
-							Requests: corev1.ResourceList{
-								corev1.ResourceCPU:    m.Spec.Resources.Requests[corev1.ResourceCPU],
-								corev1.ResourceMemory: m.Spec.Resources.Requests[corev1.ResourceMemory],
-							},
-							Limits: corev1.ResourceList{
-								corev1.ResourceCPU:    m.Spec.Resources.Limits[corev1.ResourceCPU],
-								corev1.ResourceMemory: m.Spec.Resources.Limits[corev1.ResourceMemory],
-							},
-						},
-						VolumeMounts: []corev1.VolumeMount{{
-							Name:      "model-cache",
-							MountPath: "/models",
-						}},
-					}},
+					InitContainers: initContainers,
+					Containers:     []corev1.Container{container},
 					Volumes: []corev1.Volume{{
-						Name: "model-cache",
+						Name: backend.ModelCacheVolume,
 						VolumeSource: corev1.VolumeSource{
 							PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
 								ClaimName: m.Name,
@@ -232,8 +357,81 @@ func (r *ModelDeploymentReconciler) deploymentForModelDeployment(m *aiv1alpha1.M
 	return dep
 }
 
+// gpuResources are the extended resources device plugins advertise GPUs as.
+var gpuResources = []corev1.ResourceName{"nvidia.com/gpu", "amd.com/gpu", "gpu.intel.com/i915"}
+
+// servingResources returns the resources of the container serving m: the
+// cpu, memory and GPUs in Spec.Resources, without the storage meant for the
+// model cache PVC, which a container cannot request.
+func servingResources(m *aiv1alpha1.ModelDeployment) corev1.ResourceRequirements {
+	container := func(name corev1.ResourceName) bool {
+		if name == corev1.ResourceCPU || name == corev1.ResourceMemory || strings.HasPrefix(string(name), "nvidia.com/mig-") {
+			return true
+		}
+		for _, gpu := range gpuResources {
+			if name == gpu {
+				return true
+			}
+		}
+		return false
+	}
+	out := corev1.ResourceRequirements{Requests: corev1.ResourceList{}, Limits: corev1.ResourceList{}}
+	for name, q := range m.Spec.Resources.Requests {
+		if container(name) {
+			out.Requests[name] = q
+		}
+	}
+	for name, q := range m.Spec.Resources.Limits {
+		if container(name) {
+			out.Limits[name] = q
+		}
+	}
+	return out
+}
+
+// requestGPUs asks for the GPUs a tensor-parallel m is sharded across, of
+// its Requirements.GPUVendor or else NVIDIA, unless res already asks for GPUs.
+// The device plugin only gives a pod the GPUs it requests; which of the
+// node's GPUs those are is up to the plugin, not the scheduler.
+func requestGPUs(res *corev1.ResourceRequirements, m *aiv1alpha1.ModelDeployment) {
+	for _, gpu := range gpuResources {
+		if _, ok := res.Limits[gpu]; ok {
+			return
+		}
+	}
+	for name := range res.Limits {
+		if strings.HasPrefix(string(name), "nvidia.com/mig-") {
+			return
+		}
+	}
+	n, gpu := *m.Spec.TensorParallelSize, corev1.ResourceName("nvidia.com/gpu")
+	if req := m.Spec.Requirements; req != nil {
+		if req.GPUCount != nil {
+			n = *req.GPUCount
+		}
+		switch req.GPUVendor {
+		case "AMD":
+			gpu = "amd.com/gpu"
+		case "Intel":
+			gpu = "gpu.intel.com/i915"
+		}
+	}
+	res.Limits[gpu] = *resource.NewQuantity(int64(n), resource.DecimalSI)
+}
+
+// schedulingPolicyAnnotation returns m's SchedulingPolicy as JSON for the
+// AnnotationSchedulingPolicy annotation, or "" if it has none.
+func schedulingPolicyAnnotation(m *aiv1alpha1.ModelDeployment) string {
+	if m.Spec.SchedulingPolicy == nil {
+		return ""
+	}
+	// A struct of plain fields always marshals.
+	b, _ := json.Marshal(m.Spec.SchedulingPolicy)
+	return string(b)
+}
+
 // serviceForModelDeployment returns a ModelDeployment Service object
-func (r *ModelDeploymentReconciler) serviceForModelDeployment(m *aiv1alpha1.ModelDeployment) *corev1.Service {
+func (r *ModelDeploymentReconciler) serviceForModelDeployment(m *aiv1alpha1.ModelDeployment, driver backend.Driver) *corev1.Service {
 	ls := labelsForModelDeployment(m.Name)
 
 	svc := &corev1.Service{
@@ -244,7 +442,7 @@ func (r *ModelDeploymentReconciler) serviceForModelDeployment(m *aiv1alpha1.Mode
 		Spec: corev1.ServiceSpec{
 			Selector: ls,
 			Ports: []corev1.ServicePort{{
-				Port:       11434,
+				Port:       driver.Port(),
 				TargetPort: intstr.FromString("http"),
 				Name:       "http",
 			}},
@@ -278,47 +476,18 @@ func (r *ModelDeploymentReconciler) pvcForModelDeployment(m *aiv1alpha1.ModelDep
 	return pvc
 }
 
-// jobForBenchmark returns a benchmark Job object
-func (r *ModelDeploymentReconciler) jobForBenchmark(m *aiv1alpha1.ModelDeployment) *batchv1.Job {
-	job := &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      r.benchmarkJobName(m),
-			Namespace: m.Namespace,
-		},
-		Spec: batchv1.JobSpec{
-			Template: corev1.PodTemplateSpec{
-				Spec: corev1.PodSpec{
-					Containers: []corev1.Container{{
-						Image: "flexinfer-bench:latest", // This will be built locally
-						Name:  "flexinfer-bench",
-						Args: []string{
-							"--model", m.Spec.Model,
-							"--configmap", r.benchmarkConfigMapName(m),
-						},
-					}},
-					RestartPolicy: corev1.RestartPolicyNever,
-				},
-			},
-		},
+// backendImage returns the container image for driver. It can be overridden
+// per backend with BACKEND_IMAGE_<NAME>, e.g. BACKEND_IMAGE_VLLM. For Ollama,
+// the only backend before there were several, DEFAULT_BACKEND_IMAGE is still
+// honoured when BACKEND_IMAGE_OLLAMA is unset.
+func (r *ModelDeploymentReconciler) backendImage(driver backend.Driver) string {
+	if image, ok := os.LookupEnv("BACKEND_IMAGE_" + strings.ToUpper(driver.Name())); ok {
+		return image
 	}
-	ctrl.SetControllerReference(m, job, r.Scheme)
-	return job
-}
-
-func (r *ModelDeploymentReconciler) benchmarkJobName(m *aiv1alpha1.ModelDeployment) string {
-	return fmt.Sprintf("%s-benchmark", m.Name)
-}
-
-func (r *ModelDeploymentReconciler) benchmarkConfigMapName(m *aiv1alpha1.ModelDeployment) string {
-	return fmt.Sprintf("%s-benchmark-results", m.Name)
-}
-
-// getBackendImage returns the backend image from the environment variable or a default.
-func (r *ModelDeploymentReconciler) getBackendImage() string {
-	if image, ok := os.LookupEnv("DEFAULT_BACKEND_IMAGE"); ok {
+	if image, ok := os.LookupEnv("DEFAULT_BACKEND_IMAGE"); ok && driver.Name() == "ollama" {
 		return image
 	}
-	return "ghcr.io/flexinfer/ollama:latest"
+	return driver.Image()
 }
 
 // labelsForModelDeployment returns the labels for selecting the resources
@@ -335,5 +504,14 @@ func (r *ModelDeploymentReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Owns(&corev1.Service{}).
 		Owns(&corev1.PersistentVolumeClaim{}).
 		Owns(&batchv1.Job{}).
+		// Results ConfigMaps are written by the benchmark Job, not owned by us;
+		// map them back through their ModelDeployment label.
+		Watches(&corev1.ConfigMap{},
+			handler.EnqueueRequestsFromMapFunc(benchmarkResultsToModelDeployment),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isBenchmarkResults))).
+		// A node with new hardware may introduce a device class to benchmark.
+		Watches(&corev1.Node{},
+			handler.EnqueueRequestsFromMapFunc(r.allModelDeployments),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
 		Complete(r)
 }
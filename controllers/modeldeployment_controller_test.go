@@ -18,6 +18,8 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"testing"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -25,12 +27,17 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
 )
 
 var _ = Describe("ModelDeployment controller", func() {
@@ -69,12 +76,18 @@ var _ = Describe("ModelDeployment controller", func() {
 			Expect(k8sClient.Create(ctx, md)).Should(Succeed())
 
 			// We check for the benchmark job first, as it's the first thing the reconciler creates.
-			jobLookupKey := types.NamespacedName{Name: ModelDeploymentName + "-benchmark", Namespace: ModelDeploymentNamespace}
-			createdJob := &batchv1.Job{}
-			Eventually(func() bool {
-				err := k8sClient.Get(ctx, jobLookupKey, createdJob)
-				return err == nil
-			}, timeout, interval).Should(BeTrue())
+			var createdJob *batchv1.Job
+			Eventually(func() []batchv1.Job {
+				jobs := benchmarkJobs(ctx, md)
+				if len(jobs) > 0 {
+					createdJob = &jobs[0]
+				}
+				return jobs
+			}, timeout, interval).Should(HaveLen(1))
+			revision := createdJob.Labels["flexinfer.ai/benchmark-revision"]
+			Expect(revision).NotTo(BeEmpty())
+			Expect(createdJob.Spec.Template.Spec.InitContainers).To(HaveLen(1))
+			Expect(createdJob.Spec.Template.Spec.Containers[0].Args).To(ContainElements("--model", "test-model", "--endpoint"))
 
 			// Manually update the job status to have one completion.
 			By("By updating the benchmark job status")
@@ -87,8 +100,16 @@ var _ = Describe("ModelDeployment controller", func() {
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      ModelDeploymentName + "-benchmark-results",
 					Namespace: ModelDeploymentNamespace,
+					Labels:    map[string]string{"modeldeployment_cr": ModelDeploymentName},
+				},
+				Data: map[string]string{
+					"model":           "test-model",
+					"backend":         "ollama",
+					"revision":        revision,
+					"tokensPerSecond": "150.75",
+					"deviceClass":     "nvidia-sm89-24gi",
+					"timestamp":       "2025-01-01T00:00:00Z",
 				},
-				Data: map[string]string{"tokensPerSecond": "150.75"},
 			}
 			Expect(k8sClient.Create(ctx, benchmarkCM)).Should(Succeed())
 
@@ -118,6 +139,251 @@ var _ = Describe("ModelDeployment controller", func() {
 				return err == nil
 			}, timeout, interval).Should(BeTrue())
 			Expect(createdPVC.Spec.Resources.Requests[corev1.ResourceStorage]).To(Equal(resource.MustParse("1Gi")))
+
+			// envtest runs no PV controller, so the claim never binds.
+			By("By checking the ModelDeployment status")
+			mdLookupKey := types.NamespacedName{Name: ModelDeploymentName, Namespace: ModelDeploymentNamespace}
+			fetched := &aiv1alpha1.ModelDeployment{}
+			Eventually(func() aiv1alpha1.ModelDeploymentPhase {
+				if err := k8sClient.Get(ctx, mdLookupKey, fetched); err != nil {
+					return ""
+				}
+				return fetched.Status.Phase
+			}, timeout, interval).Should(Equal(aiv1alpha1.PhaseProvisioning))
+			Expect(meta.IsStatusConditionTrue(fetched.Status.Conditions, aiv1alpha1.ConditionBenchmarked)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(fetched.Status.Conditions, aiv1alpha1.ConditionStorageReady)).To(BeTrue())
+			Expect(meta.IsStatusConditionFalse(fetched.Status.Conditions, aiv1alpha1.ConditionDegraded)).To(BeTrue())
+			Expect(fetched.Status.ObservedGeneration).To(Equal(fetched.Generation))
+			Expect(fetched.Status.TokensPerSecond).To(Equal("150.75"))
+			Expect(fetched.Status.DeviceClass).To(Equal("nvidia-sm89-24gi"))
+			Expect(fetched.Status.LastBenchmarkTime).NotTo(BeNil())
+			Expect(fetched.Status.Benchmarks).To(HaveLen(1))
+
+			By("By requesting a rebenchmark")
+			fetched.Annotations = map[string]string{aiv1alpha1.AnnotationRebenchmark: "1"}
+			Expect(k8sClient.Update(ctx, fetched)).Should(Succeed())
+			Eventually(func() string {
+				for _, job := range benchmarkJobs(ctx, md) {
+					if job.DeletionTimestamp == nil {
+						return job.Labels["flexinfer.ai/benchmark-revision"]
+					}
+				}
+				return ""
+			}, timeout, interval).ShouldNot(Or(BeEmpty(), Equal(revision)))
+			// The previous results stay in use until the new benchmark succeeds.
+			Expect(k8sClient.Get(ctx, mdLookupKey, fetched)).Should(Succeed())
+			Expect(fetched.Status.TokensPerSecond).To(Equal("150.75"))
+			Expect(meta.IsStatusConditionTrue(fetched.Status.Conditions, aiv1alpha1.ConditionBenchmarked)).To(BeTrue())
+		})
+	})
+
+	Context("When creating a ModelDeployment with an unknown backend", func() {
+		It("Should report Degraded and not start a benchmark", func() {
+			ctx := context.Background()
+			md := &aiv1alpha1.ModelDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "unknown-backend",
+					Namespace: ModelDeploymentNamespace,
+				},
+				Spec: aiv1alpha1.ModelDeploymentSpec{
+					Backend:  "tensorrt",
+					Model:    "test-model",
+					Replicas: pointer.Int32(1),
+				},
+			}
+			Expect(k8sClient.Create(ctx, md)).Should(Succeed())
+
+			lookupKey := types.NamespacedName{Name: md.Name, Namespace: md.Namespace}
+			Eventually(func() string {
+				fetched := &aiv1alpha1.ModelDeployment{}
+				if err := k8sClient.Get(ctx, lookupKey, fetched); err != nil {
+					return ""
+				}
+				c := meta.FindStatusCondition(fetched.Status.Conditions, aiv1alpha1.ConditionDegraded)
+				if c == nil || c.Status != metav1.ConditionTrue {
+					return ""
+				}
+				return c.Reason
+			}, timeout, interval).Should(Equal(aiv1alpha1.ReasonUnknownBackend))
+
+			Expect(benchmarkJobs(ctx, md)).To(BeEmpty())
+		})
+	})
+
+	Context("When every benchmark attempt fails", func() {
+		It("Should deploy anyway if DeployOnFailure is set", func() {
+			ctx := context.Background()
+			md := &aiv1alpha1.ModelDeployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "benchmark-fails",
+					Namespace: ModelDeploymentNamespace,
+				},
+				Spec: aiv1alpha1.ModelDeploymentSpec{
+					Backend:  "ollama",
+					Model:    "test-model",
+					Replicas: pointer.Int32(1),
+					Benchmark: &aiv1alpha1.BenchmarkSpec{
+						RetryLimit:      pointer.Int32(0),
+						DeployOnFailure: true,
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, md)).Should(Succeed())
+
+			var job *batchv1.Job
+			Eventually(func() []batchv1.Job {
+				jobs := benchmarkJobs(ctx, md)
+				if len(jobs) > 0 {
+					job = &jobs[0]
+				}
+				return jobs
+			}, timeout, interval).Should(HaveLen(1))
+			Expect(*job.Spec.BackoffLimit).To(Equal(int32(0)))
+
+			By("By marking the benchmark job failed")
+			job.Status.Failed = 1
+			job.Status.Conditions = append(job.Status.Conditions, batchv1.JobCondition{
+				Type:               batchv1.JobFailed,
+				Status:             corev1.ConditionTrue,
+				Reason:             "BackoffLimitExceeded",
+				Message:            "Job has reached the specified backoff limit",
+				LastTransitionTime: metav1.Now(),
+			})
+			Expect(k8sClient.Status().Update(ctx, job)).Should(Succeed())
+
+			Eventually(func() error {
+				return k8sClient.Get(ctx, types.NamespacedName{Name: md.Name, Namespace: md.Namespace}, &appsv1.Deployment{})
+			}, timeout, interval).Should(Succeed())
+
+			fetched := &aiv1alpha1.ModelDeployment{}
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: md.Name, Namespace: md.Namespace}, fetched); err != nil {
+					return ""
+				}
+				c := meta.FindStatusCondition(fetched.Status.Conditions, aiv1alpha1.ConditionBenchmarked)
+				if c == nil {
+					return ""
+				}
+				return c.Reason
+			}, timeout, interval).Should(Equal(aiv1alpha1.ReasonBenchmarkUnavailable))
+			Expect(meta.FindStatusCondition(fetched.Status.Conditions, aiv1alpha1.ConditionBenchmarked).Message).To(ContainSubstring("backoff limit"))
+			Expect(meta.IsStatusConditionFalse(fetched.Status.Conditions, aiv1alpha1.ConditionDegraded)).To(BeTrue())
 		})
 	})
 })
+
+// benchmarkJobs lists the benchmark Jobs of md.
+func benchmarkJobs(ctx context.Context, md *aiv1alpha1.ModelDeployment) []batchv1.Job {
+	jobs := &batchv1.JobList{}
+	Expect(k8sClient.List(ctx, jobs, client.InNamespace(md.Namespace), client.MatchingLabels{"modeldeployment_cr": md.Name})).Should(Succeed())
+	return jobs.Items
+}
+
+func TestDeploymentCarriesSchedulingPolicy(t *testing.T) {
+	r := &ModelDeploymentReconciler{Scheme: scheme.Scheme}
+	driver, _ := backend.Lookup("ollama")
+	md := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+		Spec:       aiv1alpha1.ModelDeploymentSpec{Backend: "ollama", Model: "llama3:8b", Replicas: pointer.Int32(1)},
+	}
+	if dep := r.deploymentForModelDeployment(md, driver); dep.Spec.Template.Annotations != nil {
+		t.Errorf("expected no annotations without a policy, got %v", dep.Spec.Template.Annotations)
+	}
+
+	cost := resource.MustParse("2.5")
+	md.Spec.SchedulingPolicy = &aiv1alpha1.SchedulingPolicy{
+		Weights:                &aiv1alpha1.SchedulingWeights{TokensPerSecond: 9, Cost: 1},
+		PreferredDeviceClasses: []string{"nvidia-sm-89-24gi"},
+		MaxCostPerHour:         &cost,
+		Placement:              aiv1alpha1.PlacementPack,
+	}
+	dep := r.deploymentForModelDeployment(md, driver)
+	var stamped aiv1alpha1.SchedulingPolicy
+	if err := json.Unmarshal([]byte(dep.Spec.Template.Annotations[aiv1alpha1.AnnotationSchedulingPolicy]), &stamped); err != nil {
+		t.Fatalf("decode policy annotation: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(&stamped, md.Spec.SchedulingPolicy) {
+		t.Errorf("stamped policy %+v, want %+v", stamped, *md.Spec.SchedulingPolicy)
+	}
+}
+
+func TestDeploymentShardsTensorParallelModels(t *testing.T) {
+	r := &ModelDeploymentReconciler{Scheme: scheme.Scheme}
+	md := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default"},
+		Spec: aiv1alpha1.ModelDeploymentSpec{
+			Backend:            "vllm",
+			Model:              "meta-llama/Llama-3.1-70B-Instruct",
+			Replicas:           pointer.Int32(1),
+			TensorParallelSize: pointer.Int32(4),
+		},
+	}
+	vllm, _ := backend.Lookup("vllm")
+	container := r.deploymentForModelDeployment(md, vllm).Spec.Template.Spec.Containers[0]
+	if args, n := container.Args, len(container.Args); n < 2 || args[n-2] != "--tensor-parallel-size" || args[n-1] != "4" {
+		t.Errorf("expected vLLM to be started with --tensor-parallel-size 4, got %v", args)
+	}
+	if q := container.Resources.Limits["nvidia.com/gpu"]; q.Value() != 4 {
+		t.Errorf("expected the pod to request 4 GPUs, got %v", container.Resources.Limits)
+	}
+
+	// GPUs asked for explicitly are left as they are.
+	md.Spec.Requirements = &aiv1alpha1.ModelRequirements{GPUVendor: "AMD"}
+	md.Spec.Resources.Limits = corev1.ResourceList{"amd.com/gpu": resource.MustParse("8")}
+	container = r.deploymentForModelDeployment(md, vllm).Spec.Template.Spec.Containers[0]
+	if !equality.Semantic.DeepEqual(container.Resources.Limits, md.Spec.Resources.Limits) {
+		t.Errorf("expected the pod to request 8 AMD GPUs, got %v", container.Resources.Limits)
+	}
+
+	// Backends that cannot shard a model are started as usual.
+	ollama, _ := backend.Lookup("ollama")
+	dep := r.deploymentForModelDeployment(md, ollama)
+	if args := dep.Spec.Template.Spec.Containers[0].Args; !equality.Semantic.DeepEqual(args, ollama.Args(md.Spec.Model)) {
+		t.Errorf("expected ollama's usual arguments, got %v", args)
+	}
+}
+
+func TestServingResources(t *testing.T) {
+	md := &aiv1alpha1.ModelDeployment{Spec: aiv1alpha1.ModelDeploymentSpec{Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:     resource.MustParse("4"),
+			corev1.ResourceMemory:  resource.MustParse("16Gi"),
+			corev1.ResourceStorage: resource.MustParse("50Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("32Gi"),
+			"nvidia.com/gpu":      resource.MustParse("1"),
+		},
+	}}}
+	got := servingResources(md)
+	want := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("4"),
+			corev1.ResourceMemory: resource.MustParse("16Gi"),
+		},
+		Limits: corev1.ResourceList{
+			corev1.ResourceMemory: resource.MustParse("32Gi"),
+			"nvidia.com/gpu":      resource.MustParse("1"),
+		},
+	}
+	if !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("servingResources = %+v, want %+v", got, want)
+	}
+}
+
+func TestBackendImage(t *testing.T) {
+	r := &ModelDeploymentReconciler{}
+	ollama, _ := backend.Lookup("ollama")
+	vllm, _ := backend.Lookup("vllm")
+	t.Setenv("DEFAULT_BACKEND_IMAGE", "registry.example.com/ollama:pinned")
+	if got := r.backendImage(ollama); got != "registry.example.com/ollama:pinned" {
+		t.Errorf("expected DEFAULT_BACKEND_IMAGE for ollama, got %s", got)
+	}
+	if got := r.backendImage(vllm); got != vllm.Image() {
+		t.Errorf("expected vLLM's own image, got %s", got)
+	}
+	t.Setenv("BACKEND_IMAGE_OLLAMA", "registry.example.com/ollama:latest")
+	if got := r.backendImage(ollama); got != "registry.example.com/ollama:latest" {
+		t.Errorf("expected BACKEND_IMAGE_OLLAMA to take precedence, got %s", got)
+	}
+}
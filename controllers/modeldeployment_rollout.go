@@ -0,0 +1,412 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+const (
+	// annotationModel records on a Deployment the model its pods serve.
+	annotationModel = "flexinfer.ai/model"
+
+	// rolloutInterval is how often a canary is checked on.
+	rolloutInterval         = 30 * time.Second
+	defaultAnalysisDuration = 10 * time.Minute
+	defaultMinRequests      = 20
+)
+
+var (
+	defaultMaxErrorRate            = resource.MustParse("0.05")
+	defaultMinTokensPerSecondRatio = resource.MustParse("0.8")
+)
+
+// canaryVerdict is what is decided about a canary after looking at how it
+// has served.
+type canaryVerdict int
+
+const (
+	canaryAnalyzing canaryVerdict = iota
+	canaryPromote
+	canaryRollBack
+)
+
+// servingModel returns the model m's stable pods serve: Spec.Model, unless a
+// canary of it is still being rolled out.
+func servingModel(m *aiv1alpha1.ModelDeployment) string {
+	if r := m.Status.Rollout; r != nil && r.StableModel != "" {
+		return r.StableModel
+	}
+	return m.Spec.Model
+}
+
+// beginRollout starts a canary of Spec.Model when it no longer matches the
+// model the stable pods serve. It runs before the benchmark step, which
+// replaces the stable model's results with the new model's, so that the
+// canary is judged against the stable model's throughput.
+func beginRollout(m *aiv1alpha1.ModelDeployment) {
+	r := m.Status.Rollout
+	if m.Spec.Rollout == nil || r == nil || r.StableModel == "" || m.Spec.Model == r.StableModel || m.Spec.Model == r.CanaryModel {
+		return
+	}
+	// Mid-rollout, the results in status may be the previous canary's.
+	if r.Phase != aiv1alpha1.RolloutProgressing {
+		r.BaselineTokensPerSecond = m.Status.TokensPerSecond
+	}
+	startCanary(m)
+}
+
+// startCanary resets m's rollout status for a canary of Spec.Model.
+func startCanary(m *aiv1alpha1.ModelDeployment) {
+	r := m.Status.Rollout
+	r.CanaryModel = m.Spec.Model
+	r.Phase = aiv1alpha1.RolloutProgressing
+	r.StartTime = nil
+	r.CanaryRequests, r.CanaryErrors, r.CanaryTokensPerSecond = 0, 0, ""
+	setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryProgressing,
+		fmt.Sprintf("Rolling out %s as a canary next to %s", r.CanaryModel, r.StableModel))
+}
+
+// reconcileRollout keeps the pods serving Spec.Model. Without Spec.Rollout the
+// stable Deployment is switched to a new model in one go; with it, the new
+// model runs as a canary next to the stable one until it is promoted or
+// rolled back. It returns when the rollout needs checking on again, zero if
+// never.
+func (r *ModelDeploymentReconciler) reconcileRollout(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver, stable *appsv1.Deployment) (time.Duration, error) {
+	log := log.FromContext(ctx)
+
+	stableModel, ok := stable.Annotations[annotationModel]
+	if !ok {
+		// Deployments from before the model was recorded on them were
+		// created for what is, as far as we can tell, still Spec.Model.
+		stableModel = m.Spec.Model
+		metav1.SetMetaDataAnnotation(&stable.ObjectMeta, annotationModel, stableModel)
+		if err := r.Update(ctx, stable); err != nil {
+			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", stable.Namespace, "Deployment.Name", stable.Name)
+			return 0, err
+		}
+	}
+
+	if m.Spec.Rollout == nil {
+		if m.Status.Rollout != nil && m.Status.Rollout.Phase == aiv1alpha1.RolloutProgressing {
+			if err := r.deleteCanary(ctx, m); err != nil {
+				return 0, err
+			}
+		}
+		m.Status.Rollout = nil
+		meta.RemoveStatusCondition(&m.Status.Conditions, aiv1alpha1.ConditionRolloutComplete)
+		if stableModel != m.Spec.Model {
+			log.Info("Replacing model", "from", stableModel, "to", m.Spec.Model)
+			return 0, r.setModel(ctx, m, driver, stable, m.Spec.Model)
+		}
+		return 0, nil
+	}
+
+	if m.Status.Rollout == nil {
+		m.Status.Rollout = &aiv1alpha1.RolloutStatus{}
+	}
+	status := m.Status.Rollout
+	status.StableModel = stableModel
+	switch {
+	case m.Spec.Model == stableModel:
+		if status.Phase == aiv1alpha1.RolloutProgressing {
+			if err := r.deleteCanary(ctx, m); err != nil {
+				return 0, err
+			}
+			setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionTrue, aiv1alpha1.ReasonModelServing,
+				fmt.Sprintf("Model was set back to %s; the canary of %s was removed", stableModel, status.CanaryModel))
+			// Setting the canary's model again starts a new canary of it.
+			status.Phase = aiv1alpha1.RolloutRolledBack
+			status.CanaryModel = ""
+		} else if meta.FindStatusCondition(m.Status.Conditions, aiv1alpha1.ConditionRolloutComplete) == nil {
+			setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionTrue, aiv1alpha1.ReasonModelServing,
+				fmt.Sprintf("Pods serve %s", stableModel))
+		}
+		return 0, nil
+	case m.Spec.Model == status.CanaryModel && status.Phase == aiv1alpha1.RolloutRolledBack:
+		// Stay on the stable model until Spec.Model changes again.
+		return 0, nil
+	case m.Spec.Model != status.CanaryModel:
+		// The stable model was not known when Spec.Model changed, so there is
+		// no baseline to judge the canary's throughput against.
+		startCanary(m)
+	}
+	return r.progressCanary(ctx, m, driver, stable)
+}
+
+// progressCanary runs m's canary and promotes or rolls it back once it has
+// served enough requests to judge.
+func (r *ModelDeploymentReconciler) progressCanary(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver, stable *appsv1.Deployment) (time.Duration, error) {
+	log := log.FromContext(ctx)
+	status := m.Status.Rollout
+	key := types.NamespacedName{Name: m.Name + aiv1alpha1.CanarySuffix, Namespace: m.Namespace}
+
+	want := r.canaryDeployment(m, driver, status.CanaryModel)
+	canary := &appsv1.Deployment{}
+	err := r.Get(ctx, key, canary)
+	if err != nil && errors.IsNotFound(err) {
+		log.Info("Creating a new canary Deployment", "Deployment.Namespace", want.Namespace, "Deployment.Name", want.Name, "model", status.CanaryModel)
+		if err = r.Create(ctx, want); err != nil {
+			log.Error(err, "Failed to create new Deployment", "Deployment.Namespace", want.Namespace, "Deployment.Name", want.Name)
+			return 0, err
+		}
+		setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryProgressing,
+			fmt.Sprintf("Canary Deployment %s created for %s", want.Name, status.CanaryModel))
+		return rolloutInterval, nil
+	} else if err != nil {
+		log.Error(err, "Failed to get canary Deployment")
+		return 0, err
+	}
+	// Keep the canary on the model being rolled out, at its size.
+	if canary.Annotations[annotationModel] != status.CanaryModel || *canary.Spec.Replicas != *want.Spec.Replicas {
+		canary.Annotations = want.Annotations
+		canary.Spec.Replicas = want.Spec.Replicas
+		canary.Spec.Template = want.Spec.Template
+		if err = r.Update(ctx, canary); err != nil {
+			log.Error(err, "Failed to update Deployment", "Deployment.Namespace", canary.Namespace, "Deployment.Name", canary.Name)
+			return 0, err
+		}
+		status.StartTime = nil
+		return rolloutInterval, nil
+	}
+	if *canary.Spec.Replicas == 0 {
+		// An idle model's canary is scaled to zero with it. Its analysis
+		// starts over once requests bring the model back.
+		status.StartTime = nil
+		setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryProgressing,
+			fmt.Sprintf("Canary of %s scaled to zero while the model is idle", status.CanaryModel))
+		return 0, nil
+	}
+
+	// The gateway finds the canary's pods through its Service.
+	if err = r.Get(ctx, key, &corev1.Service{}); err != nil && errors.IsNotFound(err) {
+		svc := r.serviceForModelDeployment(m, driver)
+		svc.Name = key.Name
+		svc.Spec.Selector = labelsForCanary(m.Name)
+		log.Info("Creating a new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+		if err = r.Create(ctx, svc); err != nil {
+			log.Error(err, "Failed to create new Service", "Service.Namespace", svc.Namespace, "Service.Name", svc.Name)
+			return 0, err
+		}
+	} else if err != nil {
+		log.Error(err, "Failed to get canary Service")
+		return 0, err
+	}
+
+	for _, c := range canary.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Status == corev1.ConditionFalse && c.Reason == "ProgressDeadlineExceeded" {
+			return 0, r.rollBack(ctx, m, fmt.Sprintf("its pods did not become ready: %s", c.Message))
+		}
+	}
+	if canary.Status.ReadyReplicas < *canary.Spec.Replicas {
+		setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryProgressing,
+			fmt.Sprintf("%d/%d canary replicas of %s ready", canary.Status.ReadyReplicas, *canary.Spec.Replicas, status.CanaryModel))
+		return rolloutInterval, nil
+	}
+
+	now := time.Now()
+	if status.StartTime == nil {
+		start := metav1.NewTime(now)
+		status.StartTime = &start
+	}
+	elapsed := now.Sub(status.StartTime.Time)
+	stats := metrics.CanaryStats{TokensPerSecond: math.NaN()}
+	if r.Canary != nil {
+		stats, err = r.Canary.Canary(ctx, metrics.CanaryQuery{Namespace: m.Namespace, ModelDeployment: m.Name, Since: elapsed})
+		if err != nil {
+			setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryProgressing,
+				fmt.Sprintf("failed to read the canary's metrics: %v", err))
+			return rolloutInterval, nil
+		}
+		status.CanaryRequests, status.CanaryErrors = int64(stats.Requests), int64(stats.Errors)
+		status.CanaryTokensPerSecond = ""
+		if !math.IsNaN(stats.TokensPerSecond) {
+			status.CanaryTokensPerSecond = strconv.FormatFloat(stats.TokensPerSecond, 'f', 2, 64)
+		}
+	}
+
+	verdict, msg := judgeCanary(m.Spec.Rollout, status.BaselineTokensPerSecond, stats, elapsed, r.Canary != nil)
+	switch verdict {
+	case canaryPromote:
+		return 0, r.promote(ctx, m, driver, stable, msg)
+	case canaryRollBack:
+		return 0, r.rollBack(ctx, m, msg)
+	}
+	setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryProgressing, msg)
+	return min(rolloutInterval, max(analysisDuration(m.Spec.Rollout)-elapsed, time.Second)), nil
+}
+
+// judgeCanary decides, from stats gathered over elapsed, whether the canary is
+// promoted, rolled back or analyzed further, and says why. It is rolled back
+// as soon as too many of its requests fail, and otherwise promoted after the
+// analysis duration if it kept up with the stable model's benchmarked
+// throughput. Without metrics, it is promoted on readiness alone.
+func judgeCanary(spec *aiv1alpha1.RolloutSpec, baseline string, stats metrics.CanaryStats, elapsed time.Duration, measured bool) (canaryVerdict, string) {
+	analysis := analysisDuration(spec)
+	if !measured {
+		if elapsed < analysis {
+			return canaryAnalyzing, fmt.Sprintf("Canary ready for %s of %s; without metrics it is promoted on readiness alone",
+				elapsed.Round(time.Second), analysis)
+		}
+		return canaryPromote, fmt.Sprintf("ready for %s", analysis)
+	}
+
+	minRequests := float64(defaultMinRequests)
+	if spec.MinRequests != nil {
+		minRequests = float64(*spec.MinRequests)
+	}
+	maxErrorRate := defaultMaxErrorRate
+	if spec.MaxErrorRate != nil {
+		maxErrorRate = *spec.MaxErrorRate
+	}
+	if stats.Requests >= minRequests && stats.Errors/stats.Requests > maxErrorRate.AsApproximateFloat64() {
+		return canaryRollBack, fmt.Sprintf("%.0f of %.0f requests failed, more than the %s allowed",
+			stats.Errors, stats.Requests, maxErrorRate.String())
+	}
+	if elapsed < analysis {
+		return canaryAnalyzing, fmt.Sprintf("Analyzing canary for %s of %s: %.0f requests, %.0f failed",
+			elapsed.Round(time.Second), analysis, stats.Requests, stats.Errors)
+	}
+	if stats.Requests < minRequests {
+		return canaryAnalyzing, fmt.Sprintf("Waiting for the canary to serve %.0f requests; it has served %.0f",
+			minRequests, stats.Requests)
+	}
+
+	ratio := defaultMinTokensPerSecondRatio
+	if spec.MinTokensPerSecondRatio != nil {
+		ratio = *spec.MinTokensPerSecondRatio
+	}
+	if b, err := strconv.ParseFloat(baseline, 64); err == nil && b > 0 && !math.IsNaN(stats.TokensPerSecond) {
+		if floor := b * ratio.AsApproximateFloat64(); stats.TokensPerSecond < floor {
+			return canaryRollBack, fmt.Sprintf("%.1f tokens/s is below %s of the stable model's benchmarked %.1f",
+				stats.TokensPerSecond, ratio.String(), b)
+		}
+	}
+	return canaryPromote, fmt.Sprintf("%.0f requests, %.0f failed, in %s", stats.Requests, stats.Errors, elapsed.Round(time.Second))
+}
+
+// promote switches the stable pods to the canary's model and removes the canary.
+func (r *ModelDeploymentReconciler) promote(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver, stable *appsv1.Deployment, reason string) error {
+	status := m.Status.Rollout
+	log.FromContext(ctx).Info("Promoting canary", "model", status.CanaryModel, "reason", reason)
+	if err := r.setModel(ctx, m, driver, stable, status.CanaryModel); err != nil {
+		return err
+	}
+	if err := r.deleteCanary(ctx, m); err != nil {
+		return err
+	}
+	status.StableModel = status.CanaryModel
+	status.Phase = aiv1alpha1.RolloutPromoted
+	setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionTrue, aiv1alpha1.ReasonCanaryPromoted,
+		fmt.Sprintf("Promoted %s: %s", status.CanaryModel, reason))
+	return nil
+}
+
+// rollBack removes the canary, keeping the stable model.
+func (r *ModelDeploymentReconciler) rollBack(ctx context.Context, m *aiv1alpha1.ModelDeployment, reason string) error {
+	status := m.Status.Rollout
+	log.FromContext(ctx).Info("Rolling back canary", "model", status.CanaryModel, "reason", reason)
+	if err := r.deleteCanary(ctx, m); err != nil {
+		return err
+	}
+	status.Phase = aiv1alpha1.RolloutRolledBack
+	setCondition(m, aiv1alpha1.ConditionRolloutComplete, metav1.ConditionFalse, aiv1alpha1.ReasonCanaryRolledBack,
+		fmt.Sprintf("Rolled back %s, keeping %s: %s", status.CanaryModel, status.StableModel, reason))
+	return nil
+}
+
+// setModel switches dep's pods to model.
+func (r *ModelDeploymentReconciler) setModel(ctx context.Context, m *aiv1alpha1.ModelDeployment, driver backend.Driver, dep *appsv1.Deployment, model string) error {
+	want := r.deploymentForModelDeployment(withModel(m, model), driver)
+	dep.Spec.Template = want.Spec.Template
+	metav1.SetMetaDataAnnotation(&dep.ObjectMeta, annotationModel, model)
+	if err := r.Update(ctx, dep); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update Deployment", "Deployment.Namespace", dep.Namespace, "Deployment.Name", dep.Name)
+		return err
+	}
+	return nil
+}
+
+// deleteCanary removes m's canary Deployment and Service.
+func (r *ModelDeploymentReconciler) deleteCanary(ctx context.Context, m *aiv1alpha1.ModelDeployment) error {
+	objMeta := metav1.ObjectMeta{Name: m.Name + aiv1alpha1.CanarySuffix, Namespace: m.Namespace}
+	for _, obj := range []client.Object{&appsv1.Deployment{ObjectMeta: objMeta}, &corev1.Service{ObjectMeta: objMeta}} {
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			log.FromContext(ctx).Error(err, "Failed to delete canary", "Name", objMeta.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+// canaryDeployment returns the Deployment running model as m's canary, with
+// no pods while m is idle.
+func (r *ModelDeploymentReconciler) canaryDeployment(m *aiv1alpha1.ModelDeployment, driver backend.Driver, model string) *appsv1.Deployment {
+	dep := r.deploymentForModelDeployment(withModel(m, model), driver)
+	ls := labelsForCanary(m.Name)
+	dep.Name = m.Name + aiv1alpha1.CanarySuffix
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: ls}
+	dep.Spec.Template.Labels = ls
+	dep.Spec.Replicas = pointer.Int32(1)
+	if n := m.Spec.Rollout.CanaryReplicas; n != nil {
+		dep.Spec.Replicas = pointer.Int32(*n)
+	}
+	if m.Spec.IdleTimeout != nil && untilIdle(m, time.Now()) <= 0 {
+		dep.Spec.Replicas = pointer.Int32(0)
+	}
+	return dep
+}
+
+// withModel returns a copy of m with Spec.Model set to model.
+func withModel(m *aiv1alpha1.ModelDeployment, model string) *aiv1alpha1.ModelDeployment {
+	m = m.DeepCopy()
+	m.Spec.Model = model
+	return m
+}
+
+func analysisDuration(spec *aiv1alpha1.RolloutSpec) time.Duration {
+	if spec.AnalysisDuration != nil {
+		return spec.AnalysisDuration.Duration
+	}
+	return defaultAnalysisDuration
+}
+
+// labelsForCanary returns the labels of a ModelDeployment's canary pods. They
+// keep the ModelDeployment label the scheduler looks them up by, but not the
+// app label the stable Deployment and Service select.
+func labelsForCanary(name string) map[string]string {
+	return map[string]string{"app": "modeldeployment-canary", "modeldeployment_cr": name}
+}
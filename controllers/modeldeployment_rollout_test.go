@@ -0,0 +1,296 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+func TestJudgeCanary(t *testing.T) {
+	errorRate := resource.MustParse("0.1")
+	spec := &aiv1alpha1.RolloutSpec{
+		AnalysisDuration: &metav1.Duration{Duration: 5 * time.Minute},
+		MinRequests:      pointer.Int32(10),
+		MaxErrorRate:     &errorRate,
+	}
+	nan := math.NaN()
+
+	for _, tc := range []struct {
+		name     string
+		stats    metrics.CanaryStats
+		elapsed  time.Duration
+		measured bool
+		want     canaryVerdict
+	}{
+		{name: "failing early", stats: metrics.CanaryStats{Requests: 20, Errors: 5, TokensPerSecond: nan}, elapsed: time.Minute, measured: true, want: canaryRollBack},
+		{name: "too few requests to judge errors", stats: metrics.CanaryStats{Requests: 4, Errors: 4, TokensPerSecond: nan}, elapsed: time.Minute, measured: true, want: canaryAnalyzing},
+		{name: "healthy but early", stats: metrics.CanaryStats{Requests: 50, Errors: 1, TokensPerSecond: 95}, elapsed: time.Minute, measured: true, want: canaryAnalyzing},
+		{name: "too little traffic", stats: metrics.CanaryStats{Requests: 3, TokensPerSecond: 95}, elapsed: time.Hour, measured: true, want: canaryAnalyzing},
+		{name: "slower than the baseline", stats: metrics.CanaryStats{Requests: 50, Errors: 1, TokensPerSecond: 60}, elapsed: 6 * time.Minute, measured: true, want: canaryRollBack},
+		{name: "keeps up", stats: metrics.CanaryStats{Requests: 50, Errors: 1, TokensPerSecond: 95}, elapsed: 6 * time.Minute, measured: true, want: canaryPromote},
+		{name: "throughput unknown", stats: metrics.CanaryStats{Requests: 50, TokensPerSecond: nan}, elapsed: 6 * time.Minute, measured: true, want: canaryPromote},
+		{name: "unmeasured and early", elapsed: time.Minute, want: canaryAnalyzing},
+		{name: "unmeasured and ready long enough", elapsed: 6 * time.Minute, want: canaryPromote},
+	} {
+		if got, msg := judgeCanary(spec, "100.00", tc.stats, tc.elapsed, tc.measured); got != tc.want {
+			t.Errorf("%s: judgeCanary = %d (%s), want %d", tc.name, got, msg, tc.want)
+		}
+	}
+}
+
+func TestBeginRollout(t *testing.T) {
+	m := &aiv1alpha1.ModelDeployment{
+		Spec: aiv1alpha1.ModelDeploymentSpec{Model: "llama3:8b", Rollout: &aiv1alpha1.RolloutSpec{}},
+		Status: aiv1alpha1.ModelDeploymentStatus{
+			TokensPerSecond: "120.00",
+			Rollout:         &aiv1alpha1.RolloutStatus{StableModel: "llama3:8b", Phase: aiv1alpha1.RolloutPromoted},
+		},
+	}
+	beginRollout(m)
+	if m.Status.Rollout.Phase != aiv1alpha1.RolloutPromoted {
+		t.Fatalf("expected no rollout while Spec.Model is served, got %s", m.Status.Rollout.Phase)
+	}
+
+	m.Spec.Model = "llama3.1:8b"
+	beginRollout(m)
+	r := m.Status.Rollout
+	if r.Phase != aiv1alpha1.RolloutProgressing || r.CanaryModel != "llama3.1:8b" || r.BaselineTokensPerSecond != "120.00" {
+		t.Fatalf("unexpected rollout %+v", r)
+	}
+
+	// A newer model mid-rollout keeps the stable model's baseline rather than
+	// the results benchmarked for the previous canary.
+	m.Status.TokensPerSecond = "80.00"
+	m.Spec.Model = "llama3.2:8b"
+	beginRollout(m)
+	if r.CanaryModel != "llama3.2:8b" || r.BaselineTokensPerSecond != "120.00" {
+		t.Fatalf("unexpected rollout %+v", r)
+	}
+	if got := servingModel(m); got != "llama3:8b" {
+		t.Errorf("servingModel = %s, want the stable model", got)
+	}
+}
+
+func TestReconcileRollout(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := aiv1alpha1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	driver, _ := backend.Lookup("ollama")
+	m := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+		Spec:       aiv1alpha1.ModelDeploymentSpec{Backend: "ollama", Model: "llama3:8b", Replicas: pointer.Int32(2)},
+	}
+	c := fake.NewClientBuilder().WithScheme(s).Build()
+	canarySource := &metrics.FakeCanarySource{}
+	r := &ModelDeploymentReconciler{Client: c, Scheme: s, Canary: canarySource}
+
+	stable := r.deploymentForModelDeployment(m, driver)
+	if err := c.Create(ctx, stable); err != nil {
+		t.Fatal(err)
+	}
+	servedModel := func() string {
+		dep := &appsv1.Deployment{}
+		if err := c.Get(ctx, types.NamespacedName{Name: "chat", Namespace: "default"}, dep); err != nil {
+			t.Fatal(err)
+		}
+		return dep.Annotations[annotationModel]
+	}
+	canaryKey := types.NamespacedName{Name: "chat-canary", Namespace: "default"}
+	rolloutReason := func() string {
+		if c := meta.FindStatusCondition(m.Status.Conditions, aiv1alpha1.ConditionRolloutComplete); c != nil {
+			return c.Reason
+		}
+		return ""
+	}
+
+	// Without a rollout strategy a new model replaces the old in one go.
+	m.Spec.Model = "llama3.1:8b"
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if got := servedModel(); got != "llama3.1:8b" {
+		t.Fatalf("expected the stable Deployment to serve llama3.1:8b, got %s", got)
+	}
+
+	// With one, the next model runs as a canary next to it.
+	m.Spec.Rollout = &aiv1alpha1.RolloutSpec{CanaryReplicas: pointer.Int32(1), AnalysisDuration: &metav1.Duration{}}
+	m.Status.TokensPerSecond = "100.00"
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if got := rolloutReason(); got != aiv1alpha1.ReasonModelServing {
+		t.Fatalf("RolloutComplete reason = %s", got)
+	}
+	m.Spec.Model = "llama3.2:8b"
+	beginRollout(m)
+	if requeue, err := r.reconcileRollout(ctx, m, driver, stable); err != nil || requeue != rolloutInterval {
+		t.Fatalf("reconcileRollout = %v, %v", requeue, err)
+	}
+	canary := &appsv1.Deployment{}
+	if err := c.Get(ctx, canaryKey, canary); err != nil {
+		t.Fatalf("expected a canary Deployment: %v", err)
+	}
+	if canary.Annotations[annotationModel] != "llama3.2:8b" || *canary.Spec.Replicas != 1 || canary.Spec.Template.Labels["app"] != "modeldeployment-canary" {
+		t.Fatalf("unexpected canary Deployment %+v", canary.ObjectMeta)
+	}
+	if got := servedModel(); got != "llama3.1:8b" {
+		t.Fatalf("expected the stable Deployment to keep serving llama3.1:8b, got %s", got)
+	}
+
+	// A canary that fails too many requests is rolled back.
+	canary.Status.ReadyReplicas = 1
+	if err := c.Status().Update(ctx, canary); err != nil {
+		t.Fatal(err)
+	}
+	canarySource.Value = metrics.CanaryStats{Requests: 40, Errors: 10, TokensPerSecond: 90}
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if m.Status.Rollout.Phase != aiv1alpha1.RolloutRolledBack || rolloutReason() != aiv1alpha1.ReasonCanaryRolledBack {
+		t.Fatalf("expected the canary to be rolled back, got %+v", m.Status.Rollout)
+	}
+	if err := c.Get(ctx, canaryKey, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the canary Deployment to be removed, got %v", err)
+	}
+	if err := c.Get(ctx, canaryKey, &corev1.Service{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the canary Service to be removed, got %v", err)
+	}
+	// and stays rolled back until Spec.Model changes.
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, canaryKey, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected no canary after a rollback, got %v", err)
+	}
+
+	// A healthy canary is promoted.
+	m.Spec.Model = "llama3.3:8b"
+	beginRollout(m)
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Get(ctx, canaryKey, canary); err != nil {
+		t.Fatal(err)
+	}
+	canary.Status.ReadyReplicas = 1
+	if err := c.Status().Update(ctx, canary); err != nil {
+		t.Fatal(err)
+	}
+	canarySource.Value = metrics.CanaryStats{Requests: 40, Errors: 1, TokensPerSecond: 90}
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if m.Status.Rollout.Phase != aiv1alpha1.RolloutPromoted || rolloutReason() != aiv1alpha1.ReasonCanaryPromoted {
+		t.Fatalf("expected the canary to be promoted, got %+v", m.Status.Rollout)
+	}
+	if got := servedModel(); got != "llama3.3:8b" {
+		t.Fatalf("expected the stable Deployment to serve llama3.3:8b, got %s", got)
+	}
+	if err := c.Get(ctx, canaryKey, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Fatalf("expected the canary Deployment to be removed, got %v", err)
+	}
+	if q := canarySource.Queries; len(q) == 0 || q[0].ModelDeployment != "chat" || q[0].Namespace != "default" {
+		t.Errorf("unexpected canary queries %+v", q)
+	}
+}
+
+func TestIdleCanary(t *testing.T) {
+	ctx := context.Background()
+	s := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := aiv1alpha1.AddToScheme(s); err != nil {
+		t.Fatal(err)
+	}
+	driver, _ := backend.Lookup("ollama")
+	m := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default", CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Hour))},
+		Spec: aiv1alpha1.ModelDeploymentSpec{
+			Backend:     "ollama",
+			Model:       "llama3:8b",
+			IdleTimeout: &metav1.Duration{Duration: 10 * time.Minute},
+			Rollout:     &aiv1alpha1.RolloutSpec{},
+		},
+	}
+	c := fake.NewClientBuilder().WithScheme(s).Build()
+	canarySource := &metrics.FakeCanarySource{}
+	r := &ModelDeploymentReconciler{Client: c, Scheme: s, Canary: canarySource}
+	stable := r.deploymentForModelDeployment(m, driver)
+	if err := c.Create(ctx, stable); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	m.Spec.Model = "llama3.1:8b"
+	beginRollout(m)
+	canaryReplicas := func() int32 {
+		dep := &appsv1.Deployment{}
+		if err := c.Get(ctx, types.NamespacedName{Name: "chat-canary", Namespace: "default"}, dep); err != nil {
+			t.Fatal(err)
+		}
+		return *dep.Spec.Replicas
+	}
+
+	// The model has been idle for longer than its timeout, so the canary
+	// gets no pods and is not analyzed.
+	for i := 0; i < 2; i++ {
+		if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := canaryReplicas(); got != 0 {
+		t.Fatalf("expected an idle model's canary to have no pods, got %d", got)
+	}
+	if m.Status.Rollout.Phase != aiv1alpha1.RolloutProgressing || m.Status.Rollout.StartTime != nil || len(canarySource.Queries) != 0 {
+		t.Fatalf("expected the analysis to wait, got %+v after %d queries", m.Status.Rollout, len(canarySource.Queries))
+	}
+
+	// A request brings it back with the model.
+	metav1.SetMetaDataAnnotation(&m.ObjectMeta, aiv1alpha1.AnnotationLastRequest, time.Now().UTC().Format(time.RFC3339))
+	if _, err := r.reconcileRollout(ctx, m, driver, stable); err != nil {
+		t.Fatal(err)
+	}
+	if got := canaryReplicas(); got != 1 {
+		t.Fatalf("expected the canary to be scaled back up, got %d", got)
+	}
+}
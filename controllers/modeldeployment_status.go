@@ -0,0 +1,73 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+)
+
+// setCondition records a condition on the ModelDeployment's in-memory status.
+// The status is written back once at the end of Reconcile.
+func setCondition(m *aiv1alpha1.ModelDeployment, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&m.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: m.Generation,
+	})
+}
+
+// clearDegraded sets Degraded to False if it is unset or was set for reason.
+// Each step only clears the reasons it owns, so a Degraded condition raised by
+// one step is not flipped back and forth by another within a reconcile.
+func clearDegraded(m *aiv1alpha1.ModelDeployment, reason string) {
+	c := meta.FindStatusCondition(m.Status.Conditions, aiv1alpha1.ConditionDegraded)
+	if c == nil || (c.Status == metav1.ConditionTrue && c.Reason == reason) {
+		setCondition(m, aiv1alpha1.ConditionDegraded, metav1.ConditionFalse, aiv1alpha1.ReasonAsExpected, "")
+	}
+}
+
+// phaseFor derives the ModelDeployment's phase from its conditions, following
+// the benchmark -> storage -> deployment order of Reconcile.
+func phaseFor(m *aiv1alpha1.ModelDeployment) aiv1alpha1.ModelDeploymentPhase {
+	conditions := m.Status.Conditions
+	switch {
+	case meta.IsStatusConditionTrue(conditions, aiv1alpha1.ConditionDegraded):
+		return aiv1alpha1.PhaseFailed
+	case len(conditions) == 0:
+		return aiv1alpha1.PhasePending
+	case !benchmarkSettled(conditions):
+		return aiv1alpha1.PhaseBenchmarking
+	case !meta.IsStatusConditionTrue(conditions, aiv1alpha1.ConditionStorageReady):
+		return aiv1alpha1.PhaseProvisioning
+	case !meta.IsStatusConditionTrue(conditions, aiv1alpha1.ConditionAvailable):
+		return aiv1alpha1.PhaseDeploying
+	default:
+		return aiv1alpha1.PhaseReady
+	}
+}
+
+// benchmarkSettled reports whether the benchmark step no longer blocks the
+// deployment: results exist, or the model is deployed without them.
+func benchmarkSettled(conditions []metav1.Condition) bool {
+	c := meta.FindStatusCondition(conditions, aiv1alpha1.ConditionBenchmarked)
+	return c != nil && (c.Status == metav1.ConditionTrue || c.Reason == aiv1alpha1.ReasonBenchmarkUnavailable)
+}
@@ -2,36 +2,69 @@
 package cache
 
 import (
+	"fmt"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flexinfer/flexinfer/agents/benchmarker"
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
 )
 
+// modelDeployments is the resource ModelDeployments are served as.
+var modelDeployments = aiv1alpha1.GroupVersion.WithResource("modeldeployments")
+
 // Cache is a shared cache of Kubernetes objects.
 type Cache struct {
-	nodeLister      listers.NodeLister
-	configMapLister listers.ConfigMapLister
-	stopCh          chan struct{}
+	nodeLister            listers.NodeLister
+	configMapLister       listers.ConfigMapLister
+	configMapInformer     cache.SharedIndexInformer
+	modelDeploymentLister cache.GenericLister
+	podInformer           cache.SharedIndexInformer
+	ledger                *Ledger
+	stopCh                chan struct{}
 }
 
-// NewCache creates a new Cache.
-func NewCache(kubeClient kubernetes.Interface) *Cache {
+// NewCache creates a new Cache. ModelDeployments are watched through
+// dynamicClient, as there is no typed clientset for them.
+func NewCache(kubeClient kubernetes.Interface, dynamicClient dynamic.Interface) *Cache {
 	factory := informers.NewSharedInformerFactory(kubeClient, 10*time.Minute)
 	nodeInformer := factory.Core().V1().Nodes()
 	configMapInformer := factory.Core().V1().ConfigMaps()
+	dynamicFactory := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 10*time.Minute)
+	modelDeploymentInformer := dynamicFactory.ForResource(modelDeployments)
+	// Only model pods count towards the ledger; don't cache every pod in the
+	// cluster for them.
+	podFactory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 10*time.Minute,
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.LabelSelector = benchmarker.LabelModelDeployment }))
+	podInformer := podFactory.Core().V1().Pods()
 
 	c := &Cache{
-		nodeLister:      nodeInformer.Lister(),
-		configMapLister: configMapInformer.Lister(),
-		stopCh:          make(chan struct{}),
+		nodeLister:            nodeInformer.Lister(),
+		configMapLister:       configMapInformer.Lister(),
+		configMapInformer:     configMapInformer.Informer(),
+		modelDeploymentLister: modelDeploymentInformer.Lister(),
+		podInformer:           podInformer.Informer(),
+		ledger:                NewLedger(),
+		stopCh:                make(chan struct{}),
 	}
 
 	factory.Start(c.stopCh)
+	dynamicFactory.Start(c.stopCh)
+	podFactory.Start(c.stopCh)
 	factory.WaitForCacheSync(c.stopCh)
+	dynamicFactory.WaitForCacheSync(c.stopCh)
+	podFactory.WaitForCacheSync(c.stopCh)
 
 	return c
 }
@@ -55,3 +88,74 @@ func (c *Cache) ListNodes() ([]*corev1.Node, error) {
 func (c *Cache) GetConfigMap(namespace, name string) (*corev1.ConfigMap, error) {
 	return c.configMapLister.ConfigMaps(namespace).Get(name)
 }
+
+// WatchConfigMap calls onChange with the named ConfigMap whenever it is
+// created or updated, and with nil when it is deleted. It is called right
+// away if the ConfigMap already exists.
+func (c *Cache) WatchConfigMap(namespace, name string, onChange func(*corev1.ConfigMap)) error {
+	matches := func(obj interface{}) (*corev1.ConfigMap, bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		cm, ok := obj.(*corev1.ConfigMap)
+		return cm, ok && cm.Namespace == namespace && cm.Name == name
+	}
+	_, err := c.configMapInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if cm, ok := matches(obj); ok {
+				onChange(cm)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			if cm, ok := matches(newObj); ok {
+				onChange(cm)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if _, ok := matches(obj); ok {
+				onChange(nil)
+			}
+		},
+	})
+	return err
+}
+
+// TrackClaims fills the ledger from the model pods bound to each node, using
+// claim to work out what each takes. The nodes and ModelDeployments claim
+// needs are already cached when it is first called.
+func (c *Cache) TrackClaims(claim ClaimFunc) error {
+	_, err := c.podInformer.AddEventHandler(c.ledger.handler(claim))
+	return err
+}
+
+// Usage returns what the model pods on node claim of its GPUs.
+func (c *Cache) Usage(node string) Usage {
+	return c.ledger.Usage(node)
+}
+
+// Assume records claim for pod on node while it is being bound.
+func (c *Cache) Assume(pod *corev1.Pod, node string, claim Claim) {
+	c.ledger.Assume(pod.UID, node, claim)
+}
+
+// Forget drops the claim Assume recorded for pod if it was never bound.
+func (c *Cache) Forget(pod *corev1.Pod) {
+	c.ledger.Forget(pod.UID)
+}
+
+// GetModelDeployment returns a ModelDeployment from the cache.
+func (c *Cache) GetModelDeployment(namespace, name string) (*aiv1alpha1.ModelDeployment, error) {
+	obj, err := c.modelDeploymentLister.ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object %T in ModelDeployment cache", obj)
+	}
+	md := &aiv1alpha1.ModelDeployment{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, md); err != nil {
+		return nil, fmt.Errorf("failed to convert ModelDeployment %s/%s: %w", namespace, name, err)
+	}
+	return md, nil
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Claim is what one pod takes of its node's GPUs.
+type Claim struct {
+	// VRAMBytes is the accelerator memory the pod takes.
+	VRAMBytes int64
+	// GPUs is how many GPUs the pod takes, fractional for pods that share
+	// one: a 1g MIG slice is 1/7, one of four time-slicing replicas 1/4.
+	GPUs float64
+}
+
+// Usage is what the flexinfer pods on a node claim in total.
+type Usage struct {
+	VRAMBytes int64
+	GPUs      float64
+	Pods      int
+}
+
+// ClaimFunc returns what pod claims of the node it is bound to.
+type ClaimFunc func(pod *corev1.Pod) Claim
+
+// ledgerEntry is the claim of one pod.
+type ledgerEntry struct {
+	node  string
+	claim Claim
+	// assumed is set for pods reserved by the scheduler but not yet seen
+	// bound by the pod informer.
+	assumed bool
+}
+
+// Ledger tracks the GPUs and VRAM claimed on each node, so that small models
+// can be packed onto a GPU without overcommitting its memory. It is safe for
+// concurrent use.
+type Ledger struct {
+	mu    sync.RWMutex
+	pods  map[types.UID]ledgerEntry
+	usage map[string]Usage
+}
+
+// NewLedger returns an empty Ledger.
+func NewLedger() *Ledger {
+	return &Ledger{pods: map[types.UID]ledgerEntry{}, usage: map[string]Usage{}}
+}
+
+// Usage returns what is claimed on node.
+func (l *Ledger) Usage(node string) Usage {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.usage[node]
+}
+
+// Set records that pod claims claim on node, replacing what it claimed
+// before.
+func (l *Ledger) Set(pod types.UID, node string, claim Claim) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.set(pod, ledgerEntry{node: node, claim: claim})
+}
+
+// Assume records claim for pod on node ahead of its binding, so that pods
+// scheduled meanwhile see it.
+func (l *Ledger) Assume(pod types.UID, node string, claim Claim) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.set(pod, ledgerEntry{node: node, claim: claim, assumed: true})
+}
+
+// Forget drops an assumed claim of pod, e.g. when its binding failed. Claims
+// of pods already seen bound are left alone.
+func (l *Ledger) Forget(pod types.UID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.pods[pod]; ok && e.assumed {
+		l.remove(pod)
+	}
+}
+
+// Remove drops the claim of pod.
+func (l *Ledger) Remove(pod types.UID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.remove(pod)
+}
+
+func (l *Ledger) set(pod types.UID, e ledgerEntry) {
+	l.remove(pod)
+	l.pods[pod] = e
+	u := l.usage[e.node]
+	u.VRAMBytes += e.claim.VRAMBytes
+	u.GPUs += e.claim.GPUs
+	u.Pods++
+	l.usage[e.node] = u
+}
+
+func (l *Ledger) remove(pod types.UID) {
+	e, ok := l.pods[pod]
+	if !ok {
+		return
+	}
+	delete(l.pods, pod)
+	u := l.usage[e.node]
+	u.VRAMBytes -= e.claim.VRAMBytes
+	u.GPUs -= e.claim.GPUs
+	u.Pods--
+	if u.Pods == 0 {
+		delete(l.usage, e.node)
+		return
+	}
+	l.usage[e.node] = u
+}
+
+// handler returns informer callbacks that keep l in step with bound pods,
+// using claim to work out what each takes. Pods that have not been bound
+// yet are ignored, leaving any assumed claim in place; finished pods no
+// longer hold their GPUs.
+func (l *Ledger) handler(claim ClaimFunc) cache.ResourceEventHandler {
+	update := func(obj interface{}) {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+		switch {
+		case pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed:
+			l.Remove(pod.UID)
+		case pod.Spec.NodeName != "":
+			l.Set(pod.UID, pod.Spec.NodeName, claim(pod))
+		}
+	}
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, newObj interface{}) { update(newObj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				l.Remove(pod.UID)
+			}
+		},
+	}
+}
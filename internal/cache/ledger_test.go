@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestLedger(t *testing.T) {
+	l := NewLedger()
+	small := Claim{VRAMBytes: 6 << 30, GPUs: 0.25}
+
+	l.Set("a", "gpu", small)
+	l.Assume("b", "gpu", small)
+	if got, want := l.Usage("gpu"), (Usage{VRAMBytes: 12 << 30, GPUs: 0.5, Pods: 2}); got != want {
+		t.Fatalf("Usage = %+v, want %+v", got, want)
+	}
+
+	// Forget only drops assumed claims.
+	l.Forget("a")
+	l.Forget("b")
+	if got, want := l.Usage("gpu"), (Usage{VRAMBytes: 6 << 30, GPUs: 0.25, Pods: 1}); got != want {
+		t.Errorf("Usage after Forget = %+v, want %+v", got, want)
+	}
+
+	// A pod moving nodes takes its claim along.
+	l.Set("a", "other", small)
+	if got := l.Usage("gpu"); got != (Usage{}) {
+		t.Errorf("expected nothing left on gpu, got %+v", got)
+	}
+	l.Remove("a")
+	if got := l.Usage("other"); got != (Usage{}) {
+		t.Errorf("expected nothing left on other, got %+v", got)
+	}
+}
+
+func TestLedgerHandler(t *testing.T) {
+	l := NewLedger()
+	h := l.handler(func(*corev1.Pod) Claim { return Claim{VRAMBytes: 1 << 30, GPUs: 1} })
+	pod := func(uid, node string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	// An unbound pod leaves the claim Reserve assumed for it alone.
+	l.Assume("a", "gpu", Claim{VRAMBytes: 1 << 30, GPUs: 1})
+	h.OnAdd(pod("a", "", corev1.PodPending), false)
+	h.OnUpdate(nil, pod("a", "gpu", corev1.PodRunning))
+	h.OnAdd(pod("b", "gpu", corev1.PodRunning), false)
+	if got := l.Usage("gpu"); got.Pods != 2 || got.GPUs != 2 {
+		t.Fatalf("expected two bound pods, got %+v", got)
+	}
+
+	h.OnUpdate(nil, pod("a", "gpu", corev1.PodSucceeded))
+	h.OnDelete(cache.DeletedFinalStateUnknown{Obj: pod("b", "gpu", corev1.PodRunning)})
+	if got := l.Usage("gpu"); got != (Usage{}) {
+		t.Errorf("expected finished and deleted pods to release their GPUs, got %+v", got)
+	}
+}
@@ -0,0 +1,273 @@
+// Package activator forwards requests to ModelDeployments, holding those for a
+// model that is scaled to zero until it is back up. It records when each model
+// was last used so that the controller can scale idle ones to zero.
+package activator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+const (
+	// DefaultTimeout is how long a request is held for a model to come up by
+	// default. Pulling and loading weights can take minutes.
+	DefaultTimeout = 10 * time.Minute
+	// defaultPollInterval is how often a model coming up is checked on.
+	defaultPollInterval = time.Second
+	// touchInterval is how often the last-request annotation is refreshed
+	// while a model is in use.
+	touchInterval = 30 * time.Second
+)
+
+// errNotReady is returned when a model does not come up within the timeout.
+var errNotReady = errors.New("model did not become ready in time")
+
+// Activator forwards requests for /<namespace>/<modeldeployment>/<path> to
+// <path> on the ModelDeployment's Service. Requests for a model without ready
+// pods are held while it is scaled up: the activator stamps the
+// AnnotationLastRequest annotation, which the controller takes as a request
+// to bring a model with an IdleTimeout back, and waits for the Service to
+// have endpoints.
+type Activator struct {
+	Client client.Client
+	// Timeout is how long to hold a request for a model to come up. Defaults
+	// to DefaultTimeout.
+	Timeout time.Duration
+	// PollInterval is how often a model coming up is checked on. Defaults to
+	// one second.
+	PollInterval time.Duration
+	// Target returns where requests for svc are sent. Defaults to the
+	// Service's cluster DNS name and first port.
+	Target func(svc *corev1.Service) *url.URL
+
+	mu sync.Mutex
+	// touched is when each model's annotation was last refreshed.
+	touched map[types.NamespacedName]time.Time
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Activator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, path, ok := parsePath(r.URL.Path)
+	if !ok {
+		http.Error(w, "path must be /<namespace>/<modeldeployment>/...", http.StatusNotFound)
+		return
+	}
+	target, err := a.Activate(r.Context(), key)
+	switch {
+	case apierrors.IsNotFound(err):
+		http.Error(w, fmt.Sprintf("ModelDeployment %s not found", key), http.StatusNotFound)
+		return
+	case errors.Is(err, errNotReady):
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, fmt.Sprintf("ModelDeployment %s is not ready", key), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		log.FromContext(r.Context()).Error(err, "Failed to activate ModelDeployment", "ModelDeployment", key)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = strings.TrimSuffix(target.Path, "/") + path
+			pr.Out.URL.RawPath = ""
+			pr.SetXForwarded()
+		},
+		// Stream tokens to the client as the backend generates them.
+		FlushInterval: -1,
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+// parsePath splits /<namespace>/<name>/<path> into the ModelDeployment and
+// the path on its Service.
+func parsePath(p string) (types.NamespacedName, string, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, "", false
+	}
+	path := "/"
+	if len(parts) == 3 {
+		path += parts[2]
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, path, true
+}
+
+// Activate returns the URL of key's Service once it has ready endpoints,
+// first asking the controller to scale the model up if it has none. The time
+// spent waiting is recorded as the model's cold start.
+func (a *Activator) Activate(ctx context.Context, key types.NamespacedName) (*url.URL, error) {
+	md := &aiv1alpha1.ModelDeployment{}
+	if err := a.Client.Get(ctx, key, md); err != nil {
+		return nil, err
+	}
+	svc, ready, err := a.ready(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if ready {
+		if err := a.touch(ctx, md, time.Time{}); err != nil {
+			// The model is up; at worst it is scaled down a little early.
+			log.FromContext(ctx).Error(err, "Failed to record request", "ModelDeployment", key)
+		}
+		return a.target(svc), nil
+	}
+
+	start := time.Now()
+	if err := a.touch(ctx, md, scaledDown(md)); err != nil {
+		return nil, fmt.Errorf("failed to request scale-up of %s: %w", key, err)
+	}
+	timeout, poll := a.Timeout, a.PollInterval
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	if poll <= 0 {
+		poll = defaultPollInterval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, errNotReady
+		case <-ticker.C:
+		}
+		svc, ready, err = a.ready(ctx, key)
+		if err != nil && ctx.Err() == nil {
+			return nil, err
+		}
+		if ready {
+			metrics.ColdStartSeconds.WithLabelValues(key.Namespace, key.Name).Observe(time.Since(start).Seconds())
+			return a.target(svc), nil
+		}
+	}
+}
+
+// ready returns key's Service and whether it has ready endpoints. A Service
+// not created yet has none.
+func (a *Activator) ready(ctx context.Context, key types.NamespacedName) (*corev1.Service, bool, error) {
+	svc := &corev1.Service{}
+	if err := a.Client.Get(ctx, key, svc); apierrors.IsNotFound(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	ep := &corev1.Endpoints{}
+	if err := a.Client.Get(ctx, key, ep); apierrors.IsNotFound(err) {
+		return svc, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	for _, subset := range ep.Subsets {
+		if len(subset.Addresses) > 0 {
+			return svc, true, nil
+		}
+	}
+	return svc, false, nil
+}
+
+// Touch records a request for key that was routed to its pods directly
+// rather than through Activate, at most once per touchInterval.
+func (a *Activator) Touch(ctx context.Context, key types.NamespacedName) error {
+	now := time.Now()
+	if !a.due(key, now, time.Time{}) {
+		return nil
+	}
+	md := &aiv1alpha1.ModelDeployment{}
+	if err := a.Client.Get(ctx, key, md); err != nil {
+		a.forget(key)
+		return err
+	}
+	return a.stamp(ctx, md, now)
+}
+
+// touch stamps the time on md's AnnotationLastRequest annotation, at most
+// once per touchInterval, or at once if this activator has not since md was
+// scaled down at scaledDown.
+func (a *Activator) touch(ctx context.Context, md *aiv1alpha1.ModelDeployment, scaledDown time.Time) error {
+	now := time.Now()
+	if !a.due(types.NamespacedName{Namespace: md.Namespace, Name: md.Name}, now, scaledDown) {
+		return nil
+	}
+	return a.stamp(ctx, md, now)
+}
+
+// scaledDown returns when md was last scaled, if no request has been stamped
+// on it since; a model held requests are waiting on then needs asking to come
+// back up. Otherwise it is the zero time: the stamp already asks for that.
+func scaledDown(md *aiv1alpha1.ModelDeployment) time.Time {
+	if md.Status.LastScaleTime == nil {
+		return time.Time{}
+	}
+	last := md.Status.LastScaleTime.Time
+	if t, err := time.Parse(time.RFC3339, md.Annotations[aiv1alpha1.AnnotationLastRequest]); err == nil && !t.Before(last) {
+		return time.Time{}
+	}
+	return last
+}
+
+// due reports whether key's annotation should be refreshed at now: if it was
+// last refreshed touchInterval or more ago, or before since. If so, it counts
+// it as refreshed, so that of many requests arriving together one patches.
+func (a *Activator) due(key types.NamespacedName, now, since time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.touched == nil {
+		a.touched = map[types.NamespacedName]time.Time{}
+	}
+	if t := a.touched[key]; now.Sub(t) < touchInterval && !t.Before(since) {
+		return false
+	}
+	a.touched[key] = now
+	return true
+}
+
+// forget lets the next request for key refresh its annotation, after one that
+// was due failed to.
+func (a *Activator) forget(key types.NamespacedName) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.touched, key)
+}
+
+func (a *Activator) stamp(ctx context.Context, md *aiv1alpha1.ModelDeployment, now time.Time) error {
+	patch := client.MergeFrom(md.DeepCopy())
+	metav1.SetMetaDataAnnotation(&md.ObjectMeta, aiv1alpha1.AnnotationLastRequest, now.UTC().Format(time.RFC3339))
+	if err := a.Client.Patch(ctx, md, patch); err != nil {
+		a.forget(types.NamespacedName{Namespace: md.Namespace, Name: md.Name})
+		return err
+	}
+	return nil
+}
+
+// target returns where requests for svc are sent.
+func (a *Activator) target(svc *corev1.Service) *url.URL {
+	if a.Target != nil {
+		return a.Target(svc)
+	}
+	var port int32 = 80
+	if len(svc.Spec.Ports) > 0 {
+		port = svc.Spec.Ports[0].Port
+	}
+	return &url.URL{Scheme: "http", Host: fmt.Sprintf("%s.%s.svc:%d", svc.Name, svc.Namespace, port)}
+}
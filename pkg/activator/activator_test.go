@@ -0,0 +1,216 @@
+package activator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// newActivator returns an Activator for the ModelDeployment default/chat, its
+// Service and objs, forwarding to backend.
+func newActivator(t *testing.T, backend *httptest.Server, objs ...client.Object) (*Activator, client.Client) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, aiv1alpha1.AddToScheme(scheme))
+	objs = append(objs,
+		&aiv1alpha1.ModelDeployment{ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"}},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+			Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 8000}}},
+		})
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+	return &Activator{
+		Client:       c,
+		PollInterval: 10 * time.Millisecond,
+		Target: func(svc *corev1.Service) *url.URL {
+			assert.Equal(t, int32(8000), svc.Spec.Ports[0].Port)
+			return target
+		},
+	}, c
+}
+
+func readyEndpoints() *corev1.Endpoints {
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.7"}}}},
+	}
+}
+
+func lastRequest(t *testing.T, c client.Client) string {
+	md := &aiv1alpha1.ModelDeployment{}
+	require.NoError(t, c.Get(context.Background(), types.NamespacedName{Name: "chat", Namespace: "default"}, md))
+	return md.Annotations[aiv1alpha1.AnnotationLastRequest]
+}
+
+func stubBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+}
+
+func get(t *testing.T, h http.Handler, path string) (int, string) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	body, err := io.ReadAll(rec.Result().Body)
+	require.NoError(t, err)
+	return rec.Code, string(body)
+}
+
+func TestForwardToReadyModel(t *testing.T) {
+	backend := stubBackend()
+	defer backend.Close()
+	a, c := newActivator(t, backend, readyEndpoints())
+
+	code, body := get(t, a, "/default/chat/v1/models")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "GET /v1/models", body)
+	stamped := lastRequest(t, c)
+	assert.NotEmpty(t, stamped)
+
+	// Busy models are not patched on every request.
+	require.NoError(t, c.Patch(context.Background(), &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default", Annotations: map[string]string{aiv1alpha1.AnnotationLastRequest: "old"}},
+	}, client.Merge))
+	get(t, a, "/default/chat/")
+	assert.Equal(t, "old", lastRequest(t, c))
+
+	code, _ = get(t, a, "/default/missing/v1/models")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get(t, a, "/default")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHoldUntilScaledUp(t *testing.T) {
+	backend := stubBackend()
+	defer backend.Close()
+	a, c := newActivator(t, backend)
+
+	done := make(chan string)
+	go func() {
+		_, body := get(t, a, "/default/chat/v1/completions")
+		done <- body
+	}()
+
+	// The controller scales the model up once it sees the request...
+	require.Eventually(t, func() bool { return lastRequest(t, c) != "" }, 5*time.Second, 10*time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("request was forwarded before the model was ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// ...and it is forwarded once the pod is ready.
+	require.NoError(t, c.Create(context.Background(), readyEndpoints()))
+	select {
+	case body := <-done:
+		assert.Equal(t, "GET /v1/completions", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not forwarded")
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(metrics.ColdStartSeconds, "flexinfer_cold_start_seconds"))
+}
+
+func TestHoldTimesOut(t *testing.T) {
+	backend := stubBackend()
+	defer backend.Close()
+	a, _ := newActivator(t, backend)
+	a.Timeout = 50 * time.Millisecond
+
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/default/chat/v1/models", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+}
+
+func TestTouch(t *testing.T) {
+	backend := stubBackend()
+	defer backend.Close()
+	a, c := newActivator(t, backend)
+	key := types.NamespacedName{Name: "chat", Namespace: "default"}
+
+	require.NoError(t, a.Touch(context.Background(), key))
+	assert.NotEmpty(t, lastRequest(t, c))
+
+	// Requests within touchInterval do not patch the ModelDeployment again.
+	md := &aiv1alpha1.ModelDeployment{}
+	require.NoError(t, c.Get(context.Background(), key, md))
+	md.Annotations[aiv1alpha1.AnnotationLastRequest] = "2026-01-01T00:00:00Z"
+	require.NoError(t, c.Update(context.Background(), md))
+	require.NoError(t, a.Touch(context.Background(), key))
+	assert.Equal(t, "2026-01-01T00:00:00Z", lastRequest(t, c))
+
+	assert.Error(t, a.Touch(context.Background(), types.NamespacedName{Name: "gone", Namespace: "default"}))
+}
+
+// countingClient counts the patches made through it.
+type countingClient struct {
+	client.Client
+	mu      sync.Mutex
+	patches int
+}
+
+func (c *countingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.mu.Lock()
+	c.patches++
+	c.mu.Unlock()
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func TestHeldRequestsPatchOnce(t *testing.T) {
+	backend := stubBackend()
+	defer backend.Close()
+	a, c := newActivator(t, backend)
+	a.Timeout = 50 * time.Millisecond
+	counting := &countingClient{Client: c}
+	a.Client = counting
+
+	// The model was scaled to zero after its last request.
+	key := types.NamespacedName{Name: "chat", Namespace: "default"}
+	scaleDown := func(at time.Time) {
+		md := &aiv1alpha1.ModelDeployment{}
+		require.NoError(t, c.Get(context.Background(), key, md))
+		md.Status.LastScaleTime = &metav1.Time{Time: at}
+		require.NoError(t, c.Update(context.Background(), md))
+	}
+	scaleDown(time.Now().Add(-time.Minute))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, _ := get(t, a, "/default/chat/v1/completions")
+			assert.Equal(t, http.StatusServiceUnavailable, code)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, counting.patches, "a burst of held requests asks for the scale-up once")
+	assert.NotEmpty(t, lastRequest(t, c))
+
+	// Scaled down again since, the next held request asks again.
+	scaleDown(time.Now().Add(time.Hour))
+	get(t, a, "/default/chat/v1/completions")
+	assert.Equal(t, 2, counting.patches)
+}
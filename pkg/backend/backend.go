@@ -0,0 +1,159 @@
+// Package backend defines the drivers that describe how to run each supported
+// LLM serving backend (ollama, vLLM, llama.cpp, TGI) inside a pod.
+package backend
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// ModelCacheVolume is the name of the volume holding downloaded model weights.
+	ModelCacheVolume = "model-cache"
+	// ModelCachePath is where the model cache volume is mounted in backend containers.
+	ModelCachePath = "/models"
+)
+
+// API identifies the HTTP protocol a backend speaks.
+type API string
+
+const (
+	// APIOllama is Ollama's native /api/generate protocol.
+	APIOllama API = "ollama"
+	// APIOpenAI is the OpenAI-compatible /v1/completions protocol.
+	APIOpenAI API = "openai"
+)
+
+// Driver describes how to run a particular backend for a model.
+type Driver interface {
+	// Name is the canonical backend name used in ModelDeploymentSpec.Backend.
+	Name() string
+	// Image is the default container image for the backend.
+	Image() string
+	// Port is the HTTP port the backend serves on.
+	Port() int32
+	// API is the protocol clients use to talk to the backend.
+	API() API
+	// Command returns the container entrypoint, or nil to use the image default.
+	Command(model string) []string
+	// Args returns the container arguments that serve model.
+	Args(model string) []string
+	// Env returns the environment for the serving container.
+	Env(model string) []corev1.EnvVar
+	// ReadinessProbe returns the probe that reports the backend as ready.
+	ReadinessProbe() *corev1.Probe
+	// PullContainer returns an init container that downloads model into the
+	// model cache, or nil if the backend fetches weights itself on start.
+	PullContainer(model, image string) *corev1.Container
+}
+
+// TensorParallel is implemented by drivers whose backend can shard a model
+// across several GPUs of one node.
+type TensorParallel interface {
+	// TensorParallelArgs returns the extra container arguments that shard
+	// the model across size GPUs.
+	TensorParallelArgs(size int) []string
+}
+
+// QueueReporter is implemented by drivers whose backend exports, as
+// Prometheus gauges, how many requests it is serving and queueing.
+type QueueReporter interface {
+	// QueueMetrics returns the names of the gauges that add up to the
+	// requests in flight.
+	QueueMetrics() []string
+}
+
+// KVCacheReporter is implemented by drivers whose backend exports, as a
+// Prometheus gauge, how full its KV cache is.
+type KVCacheReporter interface {
+	// KVCacheMetric returns the name of the gauge, a ratio from 0 to 1.
+	KVCacheMetric() string
+}
+
+var registry = map[string]Driver{}
+
+// Register adds a driver to the registry under its name and any aliases.
+func Register(d Driver, aliases ...string) {
+	registry[normalize(d.Name())] = d
+	for _, a := range aliases {
+		registry[normalize(a)] = d
+	}
+}
+
+// Lookup returns the driver registered for name. Names are case-insensitive.
+func Lookup(name string) (Driver, bool) {
+	d, ok := registry[normalize(name)]
+	return d, ok
+}
+
+// Names returns the canonical names of all registered drivers, sorted.
+func Names() []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, d := range registry {
+		if !seen[d.Name()] {
+			seen[d.Name()] = true
+			names = append(names, d.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ServingContainer assembles the container that serves model with driver d.
+func ServingContainer(d Driver, model, image string) corev1.Container {
+	return corev1.Container{
+		Name:    "llm-backend",
+		Image:   image,
+		Command: d.Command(model),
+		Args:    d.Args(model),
+		Env:     d.Env(model),
+		Ports: []corev1.ContainerPort{{
+			ContainerPort: d.Port(),
+			Name:          "http",
+		}},
+		ReadinessProbe: d.ReadinessProbe(),
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      ModelCacheVolume,
+			MountPath: ModelCachePath,
+		}},
+	}
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// httpGetProbe returns a readiness probe that polls path on the "http" port.
+func httpGetProbe(path string, initialDelay int32) *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromString("http"),
+			},
+		},
+		InitialDelaySeconds: initialDelay,
+		PeriodSeconds:       10,
+		FailureThreshold:    6,
+	}
+}
+
+// pullContainer returns an init container running script with the model cache
+// mounted. The model name is passed in $MODEL rather than interpolated into
+// the script.
+func pullContainer(image, model, script string, env []corev1.EnvVar) *corev1.Container {
+	return &corev1.Container{
+		Name:    "model-pull",
+		Image:   image,
+		Command: []string{"/bin/sh", "-c", script},
+		Env:     append([]corev1.EnvVar{{Name: "MODEL", Value: model}}, env...),
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      ModelCacheVolume,
+			MountPath: ModelCachePath,
+		}},
+	}
+}
@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestLookup(t *testing.T) {
+	for name, want := range map[string]string{
+		"ollama":                    "ollama",
+		"vLLM":                      "vllm",
+		"llama.cpp":                 "llamacpp",
+		"llamacpp":                  "llamacpp",
+		"TGI":                       "tgi",
+		"text-generation-inference": "tgi",
+	} {
+		d, ok := Lookup(name)
+		require.True(t, ok, name)
+		assert.Equal(t, want, d.Name(), name)
+	}
+
+	_, ok := Lookup("tensorrt")
+	assert.False(t, ok)
+}
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, []string{"llamacpp", "ollama", "tgi", "vllm"}, Names())
+}
+
+func TestServingContainer(t *testing.T) {
+	d, _ := Lookup("vllm")
+	c := ServingContainer(d, "meta-llama/Llama-3-8B", "vllm:test")
+
+	assert.Equal(t, "vllm:test", c.Image)
+	assert.Equal(t, int32(8000), c.Ports[0].ContainerPort)
+	assert.Contains(t, c.Args, "meta-llama/Llama-3-8B")
+	assert.Equal(t, "/health", c.ReadinessProbe.HTTPGet.Path)
+	assert.Equal(t, ModelCachePath, c.VolumeMounts[0].MountPath)
+}
+
+func TestPullContainer(t *testing.T) {
+	d, _ := Lookup("ollama")
+	c := d.PullContainer("llama3:8b", "ollama:test")
+	require.NotNil(t, c)
+
+	assert.Equal(t, "ollama:test", c.Image)
+	assert.Contains(t, c.Env, corev1.EnvVar{Name: "MODEL", Value: "llama3:8b"})
+	// The model name must not be spliced into the shell script.
+	assert.NotContains(t, c.Command[2], "llama3:8b")
+
+	d, _ = Lookup("llamacpp")
+	assert.Nil(t, d.PullContainer("org/repo:Q4_K_M", "llamacpp:test"))
+}
+
+func TestTensorParallel(t *testing.T) {
+	for name, want := range map[string][]string{
+		"vllm": {"--tensor-parallel-size", "4"},
+		"tgi":  {"--num-shard", "4"},
+	} {
+		d, _ := Lookup(name)
+		tp, ok := d.(TensorParallel)
+		require.True(t, ok, name)
+		assert.Equal(t, want, tp.TensorParallelArgs(4), name)
+	}
+
+	d, _ := Lookup("ollama")
+	_, ok := d.(TensorParallel)
+	assert.False(t, ok)
+}
+
+func TestQueueMetrics(t *testing.T) {
+	for _, name := range []string{"vllm", "tgi", "llamacpp"} {
+		d, _ := Lookup(name)
+		q, ok := d.(QueueReporter)
+		require.True(t, ok, name)
+		assert.Len(t, q.QueueMetrics(), 2, name)
+	}
+
+	d, _ := Lookup("ollama")
+	_, ok := d.(QueueReporter)
+	assert.False(t, ok, "ollama exports no metrics")
+}
+
+func TestKVCacheMetric(t *testing.T) {
+	for name, want := range map[string]string{"vllm": "vllm:gpu_cache_usage_perc", "llamacpp": "llamacpp:kv_cache_usage_ratio"} {
+		d, _ := Lookup(name)
+		kv, ok := d.(KVCacheReporter)
+		require.True(t, ok, name)
+		assert.Equal(t, want, kv.KVCacheMetric())
+	}
+	for _, name := range []string{"tgi", "ollama"} {
+		d, _ := Lookup(name)
+		_, ok := d.(KVCacheReporter)
+		assert.False(t, ok, name)
+	}
+}
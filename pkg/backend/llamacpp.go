@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(llamaCPP{}, "llama.cpp", "llama-cpp")
+}
+
+// llamaCPP runs GGUF models with the llama.cpp server. Models are Hugging
+// Face references in <user>/<repo>[:quant] form.
+type llamaCPP struct{}
+
+func (llamaCPP) Name() string  { return "llamacpp" }
+func (llamaCPP) Image() string { return "ghcr.io/ggml-org/llama.cpp:server" }
+func (llamaCPP) Port() int32   { return 8080 }
+func (llamaCPP) API() API      { return APIOpenAI }
+
+func (llamaCPP) Command(model string) []string { return nil }
+
+func (l llamaCPP) Args(model string) []string {
+	return []string{
+		"-hf", model,
+		"--alias", model,
+		"--host", "0.0.0.0",
+		"--port", strconv.Itoa(int(l.Port())),
+		// Export the request gauges QueueMetrics names.
+		"--metrics",
+	}
+}
+
+func (llamaCPP) QueueMetrics() []string {
+	return []string{"llamacpp:requests_processing", "llamacpp:requests_deferred"}
+}
+
+func (llamaCPP) KVCacheMetric() string { return "llamacpp:kv_cache_usage_ratio" }
+
+func (llamaCPP) Env(model string) []corev1.EnvVar {
+	return []corev1.EnvVar{{Name: "LLAMA_CACHE", Value: ModelCachePath}}
+}
+
+func (llamaCPP) ReadinessProbe() *corev1.Probe {
+	return httpGetProbe("/health", 10)
+}
+
+// PullContainer returns nil: the server downloads into LLAMA_CACHE on start
+// and reuses the cached file afterwards.
+func (llamaCPP) PullContainer(model, image string) *corev1.Container {
+	return nil
+}
@@ -0,0 +1,37 @@
+package backend
+
+import corev1 "k8s.io/api/core/v1"
+
+func init() {
+	Register(ollama{})
+}
+
+// ollama runs models with Ollama, which keeps its own model store under
+// OLLAMA_MODELS and loads models on first request.
+type ollama struct{}
+
+func (ollama) Name() string  { return "ollama" }
+func (ollama) Image() string { return "ghcr.io/flexinfer/ollama:latest" }
+func (ollama) Port() int32   { return 11434 }
+func (ollama) API() API      { return APIOllama }
+
+func (ollama) Command(model string) []string { return nil }
+func (ollama) Args(model string) []string    { return nil }
+
+func (ollama) Env(model string) []corev1.EnvVar {
+	return []corev1.EnvVar{
+		{Name: "OLLAMA_MODELS", Value: ModelCachePath},
+		{Name: "OLLAMA_HOST", Value: "0.0.0.0:11434"},
+	}
+}
+
+func (ollama) ReadinessProbe() *corev1.Probe {
+	return httpGetProbe("/", 5)
+}
+
+// PullContainer starts a temporary server, since `ollama pull` talks to the
+// daemon rather than writing to the store directly.
+func (o ollama) PullContainer(model, image string) *corev1.Container {
+	script := `ollama serve & until ollama list >/dev/null 2>&1; do sleep 1; done; ollama pull "$MODEL"`
+	return pullContainer(image, model, script, o.Env(model))
+}
@@ -0,0 +1,49 @@
+package backend
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(tgi{}, "text-generation-inference")
+}
+
+// tgi runs Hugging Face models with Text Generation Inference.
+type tgi struct{}
+
+func (tgi) Name() string  { return "tgi" }
+func (tgi) Image() string { return "ghcr.io/huggingface/text-generation-inference:latest" }
+func (tgi) Port() int32   { return 8080 }
+func (tgi) API() API      { return APIOpenAI }
+
+func (tgi) Command(model string) []string { return nil }
+
+func (t tgi) Args(model string) []string {
+	return []string{
+		"--model-id", model,
+		"--port", strconv.Itoa(int(t.Port())),
+	}
+}
+
+func (tgi) TensorParallelArgs(size int) []string {
+	return []string{"--num-shard", strconv.Itoa(size)}
+}
+
+func (tgi) QueueMetrics() []string {
+	return []string{"tgi_batch_current_size", "tgi_queue_size"}
+}
+
+func (tgi) Env(model string) []corev1.EnvVar {
+	return []corev1.EnvVar{{Name: "HUGGINGFACE_HUB_CACHE", Value: ModelCachePath}}
+}
+
+func (tgi) ReadinessProbe() *corev1.Probe {
+	return httpGetProbe("/health", 30)
+}
+
+func (t tgi) PullContainer(model, image string) *corev1.Container {
+	script := `text-generation-server download-weights "$MODEL"`
+	return pullContainer(image, model, script, t.Env(model))
+}
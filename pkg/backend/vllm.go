@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func init() {
+	Register(vllm{})
+}
+
+// vllm runs Hugging Face models with vLLM's OpenAI-compatible server.
+type vllm struct{}
+
+func (vllm) Name() string  { return "vllm" }
+func (vllm) Image() string { return "vllm/vllm-openai:latest" }
+func (vllm) Port() int32   { return 8000 }
+func (vllm) API() API      { return APIOpenAI }
+
+func (vllm) Command(model string) []string { return nil }
+
+func (v vllm) Args(model string) []string {
+	return []string{
+		"--model", model,
+		"--served-model-name", model,
+		"--download-dir", ModelCachePath,
+		"--port", strconv.Itoa(int(v.Port())),
+	}
+}
+
+func (vllm) TensorParallelArgs(size int) []string {
+	return []string{"--tensor-parallel-size", strconv.Itoa(size)}
+}
+
+func (vllm) QueueMetrics() []string {
+	return []string{"vllm:num_requests_running", "vllm:num_requests_waiting"}
+}
+
+func (vllm) KVCacheMetric() string { return "vllm:gpu_cache_usage_perc" }
+
+func (vllm) Env(model string) []corev1.EnvVar {
+	return []corev1.EnvVar{{Name: "HF_HOME", Value: ModelCachePath}}
+}
+
+func (vllm) ReadinessProbe() *corev1.Probe {
+	return httpGetProbe("/health", 30)
+}
+
+func (v vllm) PullContainer(model, image string) *corev1.Container {
+	script := `huggingface-cli download "$MODEL" --cache-dir ` + ModelCachePath
+	return pullContainer(image, model, script, v.Env(model))
+}
@@ -0,0 +1,129 @@
+// Package deviceclass groups nodes with equivalent accelerators so that a
+// model only needs to be benchmarked once per kind of hardware.
+package deviceclass
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LabelPrefix is the prefix of the node labels written by the FlexInfer agent.
+const LabelPrefix = "flexinfer.ai/"
+
+const (
+	labelVendor = LabelPrefix + "gpu.vendor"
+	labelArch   = LabelPrefix + "gpu.arch"
+	labelVRAM   = LabelPrefix + "gpu.vram"
+)
+
+// CPU is the name of the class of agent-managed nodes without an accelerator.
+const CPU = "cpu"
+
+// Class identifies a kind of accelerator. The zero value is the CPU class.
+type Class struct {
+	Vendor string
+	Arch   string
+	VRAM   string
+}
+
+// FromLabels returns the class of a node from its labels. It returns false
+// if the node carries no agent labels at all.
+func FromLabels(labels map[string]string) (Class, bool) {
+	managed := false
+	for k := range labels {
+		if strings.HasPrefix(k, LabelPrefix) {
+			managed = true
+			break
+		}
+	}
+	if !managed {
+		return Class{}, false
+	}
+	vendor, ok := labels[labelVendor]
+	if !ok {
+		return Class{}, true
+	}
+	return Class{Vendor: vendor, Arch: labels[labelArch], VRAM: labels[labelVRAM]}, true
+}
+
+// IsCPU reports whether c is the CPU class.
+func (c Class) IsCPU() bool {
+	return c.Vendor == ""
+}
+
+// String returns the class name. It is lower-case and safe to use in object
+// names and ConfigMap keys, e.g. "nvidia-sm-89-24gi".
+func (c Class) String() string {
+	if c.IsCPU() {
+		return CPU
+	}
+	var parts []string
+	for _, p := range []string{c.Vendor, c.Arch, c.VRAM} {
+		if p = sanitize(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// Pin constrains a pod to nodes of class c. The agent does not label nodes
+// with what it could not detect, so an empty field matches nodes without
+// the label.
+func (c Class) Pin(spec *corev1.PodSpec) {
+	labels := [][2]string{{labelVendor, c.Vendor}}
+	if !c.IsCPU() {
+		labels = append(labels, [2]string{labelArch, c.Arch}, [2]string{labelVRAM, c.VRAM})
+	}
+	var absent []corev1.NodeSelectorRequirement
+	for _, l := range labels {
+		if l[1] == "" {
+			absent = append(absent, corev1.NodeSelectorRequirement{Key: l[0], Operator: corev1.NodeSelectorOpDoesNotExist})
+			continue
+		}
+		if spec.NodeSelector == nil {
+			spec.NodeSelector = map[string]string{}
+		}
+		spec.NodeSelector[l[0]] = l[1]
+	}
+	if len(absent) == 0 {
+		return
+	}
+	// A node selector cannot express the absence of a label.
+	spec.Affinity = &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: absent}},
+			},
+		},
+	}
+}
+
+// Distinct returns the distinct classes of the given nodes, ignoring nodes
+// without agent labels, in first-seen order.
+func Distinct(nodes []corev1.Node) []Class {
+	seen := map[string]bool{}
+	var classes []Class
+	for _, n := range nodes {
+		c, ok := FromLabels(n.Labels)
+		if !ok || seen[c.String()] {
+			continue
+		}
+		seen[c.String()] = true
+		classes = append(classes, c)
+	}
+	return classes
+}
+
+// sanitize lower-cases s and replaces anything outside [a-z0-9] with '-'.
+func sanitize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
@@ -0,0 +1,75 @@
+package deviceclass
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func node(name string, labels map[string]string) corev1.Node {
+	return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestFromLabels(t *testing.T) {
+	c, ok := FromLabels(map[string]string{
+		"flexinfer.ai/gpu.vendor": "NVIDIA",
+		"flexinfer.ai/gpu.arch":   "sm_89",
+		"flexinfer.ai/gpu.vram":   "24Gi",
+	})
+	assert.True(t, ok)
+	assert.Equal(t, "nvidia-sm-89-24gi", c.String())
+
+	c, ok = FromLabels(map[string]string{"flexinfer.ai/cpu.avx512": "true"})
+	assert.True(t, ok)
+	assert.True(t, c.IsCPU())
+	assert.Equal(t, CPU, c.String())
+
+	_, ok = FromLabels(map[string]string{"kubernetes.io/os": "linux"})
+	assert.False(t, ok)
+}
+
+func TestDistinct(t *testing.T) {
+	gpu := map[string]string{
+		"flexinfer.ai/gpu.vendor": "AMD",
+		"flexinfer.ai/gpu.arch":   "gfx90a",
+		"flexinfer.ai/gpu.vram":   "64Gi",
+	}
+	classes := Distinct([]corev1.Node{
+		node("a", gpu),
+		node("b", gpu),
+		node("c", map[string]string{"flexinfer.ai/cpu.avx512": "false"}),
+		node("d", nil),
+	})
+
+	assert.Len(t, classes, 2)
+	assert.Equal(t, "amd-gfx90a-64gi", classes[0].String())
+	assert.Equal(t, CPU, classes[1].String())
+}
+
+func TestPin(t *testing.T) {
+	var spec corev1.PodSpec
+	Class{Vendor: "NVIDIA", Arch: "sm_89", VRAM: "24Gi"}.Pin(&spec)
+	assert.Equal(t, "NVIDIA", spec.NodeSelector["flexinfer.ai/gpu.vendor"])
+	assert.Equal(t, "sm_89", spec.NodeSelector["flexinfer.ai/gpu.arch"])
+	assert.Equal(t, "24Gi", spec.NodeSelector["flexinfer.ai/gpu.vram"])
+	assert.Nil(t, spec.Affinity)
+
+	// Nodes whose architecture or memory the agent could not detect carry no
+	// such label, rather than an empty one.
+	spec = corev1.PodSpec{}
+	Class{Vendor: "Intel"}.Pin(&spec)
+	assert.Equal(t, map[string]string{"flexinfer.ai/gpu.vendor": "Intel"}, spec.NodeSelector)
+	term := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0]
+	assert.Equal(t, []corev1.NodeSelectorRequirement{
+		{Key: "flexinfer.ai/gpu.arch", Operator: corev1.NodeSelectorOpDoesNotExist},
+		{Key: "flexinfer.ai/gpu.vram", Operator: corev1.NodeSelectorOpDoesNotExist},
+	}, term.MatchExpressions)
+
+	spec = corev1.PodSpec{}
+	Class{}.Pin(&spec)
+	assert.Empty(t, spec.NodeSelector)
+	term = spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0]
+	assert.Equal(t, corev1.NodeSelectorOpDoesNotExist, term.MatchExpressions[0].Operator)
+}
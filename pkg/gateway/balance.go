@@ -0,0 +1,343 @@
+package gateway
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// Policy chooses which replica serves a request.
+type Policy string
+
+const (
+	// PolicyLeastLoad sends each request to the least loaded replica.
+	PolicyLeastLoad Policy = "least-load"
+	// PolicyPowerOfTwo sends each request to the less loaded of two replicas
+	// chosen at random. It balances nearly as well as least-load without
+	// every request between two scrapes piling onto the same replica.
+	PolicyPowerOfTwo Policy = "power-of-two"
+)
+
+// affinitySlack is how many more requests than the least busy replica the
+// replica a prompt prefix hashes to may have before the prefix is sent
+// elsewhere.
+const affinitySlack = 2
+
+// candidate is a replica a request may be sent to, and the route it serves.
+type candidate struct {
+	route   Route
+	replica Replica
+	// track is metrics.TrackCanary for a canary's replicas, whose model
+	// route.Model then is, and metrics.TrackStable otherwise.
+	track string
+}
+
+// candidates returns the replicas a request for route may be sent to: those
+// of its canary for the canary's share of requests, and its own otherwise.
+func candidates(route Route) []candidate {
+	track, replicas := metrics.TrackStable, route.Replicas
+	if c := route.Canary; c != nil && len(c.Replicas) > 0 && (len(replicas) == 0 || rand.IntN(100) < int(c.Weight)) {
+		track, replicas = metrics.TrackCanary, c.Replicas
+		route.Model = c.Model
+	}
+	cands := make([]candidate, 0, len(replicas))
+	for _, r := range replicas {
+		cands = append(cands, candidate{route: route, replica: r, track: track})
+	}
+	return cands
+}
+
+// pod identifies the candidate's pod.
+func (c candidate) pod() types.NamespacedName {
+	return types.NamespacedName{Namespace: c.route.Key.Namespace, Name: c.replica.Pod}
+}
+
+// replicaLoad is what the gateway knows of how busy a replica is.
+type replicaLoad struct {
+	// route is the ModelDeployment the replica serves.
+	route types.NamespacedName
+	// inFlight is the requests this gateway has in flight to the replica.
+	inFlight int
+	// queued is the requests the replica reported serving or queueing when
+	// last scraped, or NaN if unknown.
+	queued float64
+	// kvCache is the fraction of the replica's KV cache in use when last
+	// scraped, or NaN if unknown.
+	kvCache float64
+}
+
+// load is a replica's load as compared when balancing.
+type load struct {
+	// busy is the requests the replica is serving: those it reported when
+	// last scraped or those this gateway has in flight, whichever is more.
+	busy    float64
+	kvCache float64
+	tps     float64
+}
+
+// less orders loads by how busy the replica is, breaking ties by KV-cache
+// usage and then by recent tokens per second.
+func (l load) less(o load) bool {
+	if l.busy != o.busy {
+		return l.busy < o.busy
+	}
+	if l.kvCache != o.kvCache {
+		return l.kvCache < o.kvCache
+	}
+	return l.tps < o.tps
+}
+
+// balancer tracks the load on each replica, keyed by namespace and pod.
+type balancer struct {
+	mu    sync.Mutex
+	loads map[types.NamespacedName]*replicaLoad
+}
+
+// get returns pod's load, adding it if it is new. b.mu must be held.
+func (b *balancer) get(pod, route types.NamespacedName) *replicaLoad {
+	if b.loads == nil {
+		b.loads = map[types.NamespacedName]*replicaLoad{}
+	}
+	l, ok := b.loads[pod]
+	if !ok {
+		l = &replicaLoad{route: route, queued: math.NaN(), kvCache: math.NaN()}
+		b.loads[pod] = l
+	}
+	return l
+}
+
+// load returns c's load, with tps its recent tokens per second.
+func (b *balancer) load(c candidate, tps float64) load {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l := b.get(c.pod(), c.route.Key)
+	out := load{busy: float64(l.inFlight), tps: tps}
+	if !math.IsNaN(l.queued) {
+		out.busy = max(out.busy, l.queued)
+	}
+	if !math.IsNaN(l.kvCache) {
+		out.kvCache = l.kvCache
+	}
+	return out
+}
+
+// start counts a request sent to c until the returned func is called.
+func (b *balancer) start(c candidate) func() {
+	b.add(c, 1)
+	return func() { b.add(c, -1) }
+}
+
+func (b *balancer) add(c candidate, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l := b.get(c.pod(), c.route.Key)
+	l.inFlight += n
+	metrics.ReplicaInFlightRequests.WithLabelValues(c.route.Key.Namespace, c.route.Key.Name, c.replica.Pod).Set(float64(l.inFlight))
+}
+
+// observe records what c reported when scraped. Either may be NaN.
+func (b *balancer) observe(c candidate, queued, kvCache float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l := b.get(c.pod(), c.route.Key)
+	l.queued, l.kvCache = queued, kvCache
+	if !math.IsNaN(kvCache) {
+		metrics.ReplicaKVCacheUsage.WithLabelValues(c.route.Key.Namespace, c.route.Key.Name, c.replica.Pod).Set(kvCache)
+	}
+}
+
+// forget drops the replicas for which keep returns false and that have no
+// requests in flight, and their series.
+func (b *balancer) forget(keep func(pod types.NamespacedName) bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for pod, l := range b.loads {
+		if l.inFlight > 0 || keep(pod) {
+			continue
+		}
+		delete(b.loads, pod)
+		metrics.ReplicaInFlightRequests.DeleteLabelValues(pod.Namespace, l.route.Name, pod.Name)
+		metrics.ReplicaKVCacheUsage.DeleteLabelValues(pod.Namespace, l.route.Name, pod.Name)
+	}
+}
+
+// pick chooses which of cands, of which there is at least one, serves a
+// request whose prompt starts with prefix.
+func (g *Gateway) pick(cands []candidate, prefix string) candidate {
+	if len(cands) == 1 {
+		return cands[0]
+	}
+	loads := make([]load, len(cands))
+	for i, c := range cands {
+		loads[i] = g.balancer.load(c, g.tokens.rate(c.pod()))
+	}
+
+	if prefix != "" {
+		// Send prompts sharing a prefix to the same replica, where it is
+		// likely still cached, unless that replica is much busier than the
+		// rest. Rendezvous hashing moves few prefixes as replicas come and go.
+		minBusy := loads[0].busy
+		for _, l := range loads[1:] {
+			minBusy = min(minBusy, l.busy)
+		}
+		order := make([]int, len(cands))
+		weights := make([]uint64, len(cands))
+		for i, c := range cands {
+			order[i] = i
+			h := fnv.New64a()
+			h.Write([]byte(prefix))
+			h.Write([]byte{0})
+			h.Write([]byte(c.pod().String()))
+			weights[i] = h.Sum64()
+		}
+		sort.Slice(order, func(i, j int) bool { return weights[order[i]] > weights[order[j]] })
+		for _, i := range order {
+			if loads[i].busy <= minBusy+affinitySlack {
+				return cands[i]
+			}
+		}
+	}
+
+	if g.Policy == PolicyLeastLoad {
+		best := 0
+		for i := range loads[1:] {
+			if loads[i+1].less(loads[best]) {
+				best = i + 1
+			}
+		}
+		return cands[best]
+	}
+	i := rand.IntN(len(cands))
+	j := rand.IntN(len(cands) - 1)
+	if j >= i {
+		j++
+	}
+	if loads[j].less(loads[i]) {
+		return cands[j]
+	}
+	return cands[i]
+}
+
+// prefix returns the first n bytes of req's prompt, or of its messages for a
+// chat completion, that prefix affinity hashes.
+func prefix(req *openAIRequest, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	var b strings.Builder
+	for _, m := range req.Messages {
+		text, _ := messageText(m.Content)
+		b.WriteString(m.Role)
+		b.WriteByte(0)
+		b.WriteString(text)
+		b.WriteByte(0)
+		if b.Len() >= n {
+			break
+		}
+	}
+	switch p := req.Prompt.(type) {
+	case string:
+		b.WriteString(p)
+	case []any:
+		if len(p) > 0 {
+			s, _ := p[0].(string)
+			b.WriteString(s)
+		}
+	}
+	s := b.String()
+	if len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
+
+// ScrapeReplicas reads how many requests each replica is serving and how
+// full its KV cache is from its /metrics every interval until ctx is done,
+// for backends that export them.
+func (g *Gateway) ScrapeReplicas(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		g.scrape(ctx, interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrape reads every replica's gauges once, each within timeout, and forgets
+// the replicas that are gone.
+func (g *Gateway) scrape(ctx context.Context, timeout time.Duration) {
+	live := map[types.NamespacedName]bool{}
+	for _, route := range g.Routes.Routes() {
+		var queue []string
+		var kvCache string
+		if driver, ok := backend.Lookup(route.Backend); ok {
+			if qr, ok := driver.(backend.QueueReporter); ok {
+				queue = qr.QueueMetrics()
+			}
+			if kr, ok := driver.(backend.KVCacheReporter); ok {
+				kvCache = kr.KVCacheMetric()
+			}
+		}
+		replicas := route.Replicas
+		if route.Canary != nil {
+			replicas = append(replicas[:len(replicas):len(replicas)], route.Canary.Replicas...)
+		}
+		for _, replica := range replicas {
+			c := candidate{route: route, replica: replica}
+			live[c.pod()] = true
+			if len(queue) == 0 && kvCache == "" {
+				continue
+			}
+			queued, usage := g.scrapeReplica(ctx, c, timeout, queue, kvCache)
+			g.balancer.observe(c, queued, usage)
+		}
+	}
+	keep := func(pod types.NamespacedName) bool { return live[pod] }
+	g.balancer.forget(keep)
+	g.tokens.forget(keep)
+}
+
+// scrapeReplica returns the sum of c's queue gauges and its KV-cache gauge,
+// each NaN if it could not be read.
+func (g *Gateway) scrapeReplica(ctx context.Context, c candidate, timeout time.Duration, queue []string, kvCache string) (float64, float64) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	names := queue
+	if kvCache != "" {
+		names = append(append([]string(nil), queue...), kvCache)
+	}
+	values, err := metrics.ScrapeGauges(ctx, g.Client, c.replica.URL.JoinPath("metrics").String(), names...)
+	if err != nil {
+		log.FromContext(ctx).V(1).Info("Failed to scrape replica", "ModelDeployment", c.route.Key, "pod", c.replica.Pod, "error", err.Error())
+		return math.NaN(), math.NaN()
+	}
+	queued := math.NaN()
+	for _, name := range queue {
+		if v, ok := values[name]; ok {
+			if math.IsNaN(queued) {
+				queued = 0
+			}
+			queued += v
+		}
+	}
+	usage, ok := values[kvCache]
+	if !ok {
+		usage = math.NaN()
+	}
+	return queued, usage
+}
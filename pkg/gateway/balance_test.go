@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// replicas returns n candidates serving default/big, pods big-0 to big-<n-1>.
+func replicas(n int) []candidate {
+	route := Route{Key: types.NamespacedName{Namespace: "default", Name: "big"}, Model: "m", Backend: "vllm"}
+	cands := make([]candidate, n)
+	for i := range cands {
+		cands[i] = candidate{route: route, replica: Replica{Pod: fmt.Sprintf("big-%d", i)}}
+	}
+	return cands
+}
+
+func TestPickLeastLoad(t *testing.T) {
+	g := &Gateway{Policy: PolicyLeastLoad}
+	cands := replicas(3)
+	g.balancer.start(cands[0])
+	g.balancer.observe(cands[0], math.NaN(), 0.5)
+	g.balancer.observe(cands[1], 1, 0.9)
+	g.balancer.observe(cands[2], 1, 0.2)
+	assert.Equal(t, "big-2", g.pick(cands, "").replica.Pod, "ties on requests are broken by KV-cache usage")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.ReplicaInFlightRequests.WithLabelValues("default", "big", "big-0")))
+
+	// The gateway's own in-flight requests count when the scrape is stale.
+	g.balancer.start(cands[2])()
+	done := g.balancer.start(cands[2])
+	g.balancer.start(cands[2])
+	assert.Equal(t, "big-0", g.pick(cands, "").replica.Pod)
+	done()
+	assert.Equal(t, 0.9, testutil.ToFloat64(metrics.ReplicaKVCacheUsage.WithLabelValues("default", "big", "big-1")))
+}
+
+func TestPickPowerOfTwo(t *testing.T) {
+	g := &Gateway{}
+	cands := replicas(3)
+	g.balancer.observe(cands[0], 5, math.NaN())
+	g.balancer.observe(cands[1], 0, math.NaN())
+	g.balancer.observe(cands[2], 1, math.NaN())
+	picked := map[string]int{}
+	for i := 0; i < 200; i++ {
+		picked[g.pick(cands, "").replica.Pod]++
+	}
+	assert.Zero(t, picked["big-0"], "the busiest replica always loses its comparison")
+	assert.NotZero(t, picked["big-2"], "the second least busy wins against the busiest")
+	assert.Greater(t, picked["big-1"], picked["big-2"])
+}
+
+func TestPrefixAffinity(t *testing.T) {
+	g := &Gateway{Policy: PolicyLeastLoad}
+	cands := replicas(4)
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		p := fmt.Sprintf("system prompt %d", i)
+		first := g.pick(cands, p)
+		assert.Equal(t, first, g.pick(cands, p), "a prefix keeps going to the same replica")
+		seen[first.replica.Pod] = true
+	}
+	assert.Greater(t, len(seen), 1, "prefixes are spread over the replicas")
+
+	// The prefix moves once its replica is affinitySlack requests busier than
+	// the least busy, and its fallback is stable too.
+	warm := g.pick(cands, "system prompt 0")
+	for i := 0; i < affinitySlack; i++ {
+		g.balancer.start(warm)
+	}
+	assert.Equal(t, warm, g.pick(cands, "system prompt 0"))
+	g.balancer.start(warm)
+	moved := g.pick(cands, "system prompt 0")
+	assert.NotEqual(t, warm, moved)
+	assert.Equal(t, moved, g.pick(cands, "system prompt 0"))
+}
+
+func TestPrefix(t *testing.T) {
+	chat := &openAIRequest{Messages: []openAIMessage{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hello"}}}
+	assert.Equal(t, "system\x00Be brief.\x00user", prefix(chat, 21))
+	assert.Equal(t, "", prefix(chat, 0))
+	assert.Equal(t, "Once upon", prefix(&openAIRequest{Prompt: []any{"Once upon a time"}}, 9))
+	assert.Equal(t, "Hi", prefix(&openAIRequest{Prompt: "Hi"}, 9))
+}
+
+func TestScrapeReplicas(t *testing.T) {
+	pod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/metrics", r.URL.Path)
+		fmt.Fprint(w, `vllm:num_requests_running 2
+vllm:num_requests_waiting 3
+vllm:gpu_cache_usage_perc 0.5
+`)
+	}))
+	defer pod.Close()
+	md := &aiv1alpha1.ModelDeployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default"},
+		Spec:       aiv1alpha1.ModelDeploymentSpec{Backend: "vllm", Model: "meta-llama/Llama-3.1-70B-Instruct"},
+	}
+	g := &Gateway{Routes: newTable(t, md, endpoints(t, "big", pod))}
+	routes := g.Routes.Routes()
+	require.Len(t, routes, 1)
+	require.Len(t, routes[0].Replicas, 1)
+	u, err := url.Parse(pod.URL)
+	require.NoError(t, err)
+	assert.Equal(t, Replica{Pod: "big-0", Node: "node-0", URL: u}, routes[0].Replicas[0])
+
+	g.scrape(context.Background(), time.Second)
+	c := candidate{route: routes[0], replica: routes[0].Replicas[0]}
+	assert.Equal(t, load{busy: 5, kvCache: 0.5}, g.balancer.load(c, 0))
+	assert.Equal(t, 0.5, testutil.ToFloat64(metrics.ReplicaKVCacheUsage.WithLabelValues("default", "big", "big-0")))
+
+	// Pods that are gone are forgotten.
+	g.Routes = newTable(t, md)
+	g.scrape(context.Background(), time.Second)
+	assert.Empty(t, g.balancer.loads)
+}
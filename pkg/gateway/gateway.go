@@ -0,0 +1,270 @@
+// Package gateway serves an OpenAI-compatible API in front of every
+// ModelDeployment, routing each request by its model to the ModelDeployment
+// serving it and translating it for backends that speak another protocol.
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexinfer/flexinfer/pkg/activator"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// maxRequestBody bounds the size of a request body.
+const maxRequestBody = 16 << 20
+
+// Gateway serves /v1/chat/completions, /v1/completions and /v1/models.
+type Gateway struct {
+	Routes *Table
+	// Activator, if set, brings ModelDeployments scaled to zero back up before
+	// requests are forwarded to them, and records their use.
+	Activator *activator.Activator
+	// Client sends the requests translated for backends and scrapes their
+	// metrics. Defaults to http.DefaultClient.
+	Client *http.Client
+	// Policy chooses which replica serves each request. Defaults to
+	// PolicyPowerOfTwo.
+	Policy Policy
+	// PrefixAffinity is how many bytes of a prompt are hashed to send
+	// prompts sharing them to the same replica, whose cache likely still
+	// holds them. 0 disables prefix affinity.
+	PrefixAffinity int
+
+	tokens   tokenMeter
+	balancer balancer
+}
+
+// ServeHTTP implements http.Handler.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/models":
+		g.serveModels(w, r)
+	case "/v1/chat/completions":
+		g.serveCompletion(w, r, true)
+	case "/v1/completions":
+		g.serveCompletion(w, r, false)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown path %s", r.URL.Path))
+	}
+}
+
+// RecordTokens sets flexinfer_tokens_per_second from the tokens generated
+// through the gateway every interval until ctx is done.
+func (g *Gateway) RecordTokens(ctx context.Context, interval time.Duration) {
+	g.tokens.run(ctx, interval)
+}
+
+func (g *Gateway) serveModels(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET")
+		return
+	}
+	data := []any{}
+	for _, m := range g.Routes.Models() {
+		data = append(data, map[string]any{"id": m, "object": "model", "created": 0, "owned_by": "flexinfer"})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+// serveCompletion routes a chat completion, if chat is set, or completion
+// request by its model.
+func (g *Gateway) serveCompletion(w http.ResponseWriter, r *http.Request, chat bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
+	var req openAIRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if req.Model == "" {
+		writeError(w, http.StatusBadRequest, "model is required")
+		return
+	}
+	routes := g.Routes.Lookup(req.Model)
+	if len(routes) == 0 {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("model %q is not served", req.Model))
+		return
+	}
+	var cands []candidate
+	for _, route := range routes {
+		cands = append(cands, candidates(route)...)
+	}
+
+	var c candidate
+	if len(cands) > 0 {
+		c = g.pick(cands, prefix(&req, g.PrefixAffinity))
+		if g.Activator != nil {
+			if err := g.Activator.Touch(r.Context(), c.route.Key); err != nil {
+				// The model is up; at worst it is scaled down a little early.
+				log.FromContext(r.Context()).Error(err, "Failed to record request", "ModelDeployment", c.route.Key)
+			}
+		}
+		c.route.URL = c.replica.URL
+		defer g.balancer.start(c)()
+	} else {
+		// No replica was ready at the last refresh: the model may be scaled
+		// to zero, so hold the request until its Service has endpoints.
+		c.route, c.track = routes[0], metrics.TrackStable
+		if g.Activator == nil {
+			writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("model %q has no ready replicas", req.Model))
+			return
+		}
+		target, err := g.Activator.Activate(r.Context(), c.route.Key)
+		switch {
+		case apierrors.IsNotFound(err):
+			writeError(w, http.StatusNotFound, fmt.Sprintf("model %q is not served", req.Model))
+			return
+		case err != nil:
+			log.FromContext(r.Context()).Error(err, "Failed to activate ModelDeployment", "ModelDeployment", c.route.Key)
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		c.route.URL = target
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	start := time.Now()
+	var tokens int
+	if c.route.API == backend.APIOllama {
+		tokens = g.serveOllama(sw, r, c.route, &req, chat)
+	} else {
+		tokens = g.forward(sw, r, c, body, req.Stream)
+	}
+	if r.Context().Err() == nil {
+		g.record(c, sw.status, tokens, start)
+	}
+}
+
+// record counts a request c answered with status after generating tokens
+// since start.
+func (g *Gateway) record(c candidate, status, tokens int, start time.Time) {
+	now := time.Now()
+	g.tokens.add(c.route, c.replica, tokens, now)
+	ns, name := c.route.Key.Namespace, c.route.Key.Name
+	switch {
+	case status >= http.StatusInternalServerError:
+		metrics.RequestErrors.WithLabelValues(ns, name, c.track).Inc()
+	case status < http.StatusBadRequest:
+		metrics.RequestDurationSeconds.WithLabelValues(ns, name, c.track).Observe(now.Sub(start).Seconds())
+		metrics.GeneratedTokens.WithLabelValues(ns, name, c.track).Add(float64(tokens))
+	}
+}
+
+// forward passes an OpenAI-compatible request through to c, naming the model
+// as the backend knows it, and streams the response back. It returns the
+// number of tokens generated.
+func (g *Gateway) forward(w http.ResponseWriter, r *http.Request, c candidate, body []byte, stream bool) int {
+	route := c.route
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return 0
+	}
+	fields["model"] = route.Model
+	body, err := json.Marshal(fields)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return 0
+	}
+
+	generated := 0
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(route.URL)
+			pr.Out.URL.Path = strings.TrimSuffix(route.URL.Path, "/") + r.URL.Path
+			pr.Out.URL.RawPath = ""
+			pr.Out.Body = io.NopCloser(bytes.NewReader(body))
+			pr.Out.ContentLength = int64(len(body))
+			pr.Out.Header.Set("Content-Type", "application/json")
+			pr.SetXForwarded()
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			if resp.StatusCode == http.StatusOK {
+				resp.Body = &countingBody{ReadCloser: resp.Body, stream: stream, done: func(tokens int) {
+					generated = tokens
+				}}
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if !errors.Is(err, context.Canceled) {
+				log.FromContext(r.Context()).Error(err, "Failed to forward request", "ModelDeployment", route.Key)
+			}
+			writeError(w, http.StatusBadGateway, err.Error())
+		},
+		// Stream tokens to the client as the backend generates them.
+		FlushInterval: -1,
+	}
+	// The proxy closes the response body, reporting its tokens, before it
+	// returns.
+	proxy.ServeHTTP(w, r)
+	return generated
+}
+
+// statusWriter records the status of the response written through it.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets responses be streamed through it.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// writeError answers with an OpenAI-style error.
+func writeError(w http.ResponseWriter, status int, message string) {
+	kind := "invalid_request_error"
+	if status >= 500 {
+		kind = "server_error"
+	}
+	writeJSON(w, status, map[string]any{"error": map[string]any{"message": message, "type": kind, "code": nil}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
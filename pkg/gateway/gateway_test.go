@@ -0,0 +1,331 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// stubVLLM answers OpenAI-compatible completion requests, streaming three
+// tokens when asked to and otherwise reporting usage.
+func stubVLLM(t *testing.T, seen *map[string]any) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen = nil
+		require.NoError(t, json.NewDecoder(r.Body).Decode(seen))
+		assert.Equal(t, "/v1/completions", r.URL.Path)
+		if (*seen)["stream"] != true {
+			fmt.Fprint(w, `{"object":"text_completion","choices":[{"text":"Paris"}],"usage":{"completion_tokens":1}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, tok := range []string{"The", " capital", " is"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"text\":%q}]}\n\n", tok)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+}
+
+// stubOllama answers Ollama's native /api/chat and /api/generate.
+func stubOllama(t *testing.T, seen *ollamaRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*seen = ollamaRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(seen))
+		field := `"response":%q`
+		if r.URL.Path == "/api/chat" {
+			field = `"message":{"role":"assistant","content":%q}`
+		}
+		if !seen.Stream {
+			fmt.Fprintf(w, `{`+field+`,"done":true,"done_reason":"stop","prompt_eval_count":5,"eval_count":2}`, "Hi there")
+			return
+		}
+		for _, tok := range []string{"Hi", " there"} {
+			fmt.Fprintf(w, `{`+field+`,"done":false}`+"\n", tok)
+		}
+		fmt.Fprintf(w, `{`+field+`,"done":true,"done_reason":"length","eval_count":2}`+"\n", "")
+	}))
+}
+
+// endpoints returns the Endpoints of the ModelDeployment name, with one ready
+// pod per server, named <name>-<i> and running on node-<i>.
+func endpoints(t *testing.T, name string, servers ...*httptest.Server) *corev1.Endpoints {
+	ep := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	for i, srv := range servers {
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		port, err := strconv.Atoi(u.Port())
+		require.NoError(t, err)
+		ep.Subsets = append(ep.Subsets, corev1.EndpointSubset{
+			Addresses: []corev1.EndpointAddress{{
+				IP:        u.Hostname(),
+				NodeName:  pointer.String(fmt.Sprintf("node-%d", i)),
+				TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: fmt.Sprintf("%s-%d", name, i)},
+			}},
+			Ports: []corev1.EndpointPort{{Name: "http", Port: int32(port)}},
+		})
+	}
+	return ep
+}
+
+// newGateway returns a Gateway routing to the ModelDeployments default/chat,
+// an Ollama model, and default/big, a vLLM model, served by the stubs.
+func newGateway(t *testing.T, ollama, vllm *httptest.Server) *Gateway {
+	objs := []client.Object{
+		&aiv1alpha1.ModelDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+			Spec:       aiv1alpha1.ModelDeploymentSpec{Backend: "ollama", Model: "llama3:8b"},
+		},
+		&aiv1alpha1.ModelDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "big", Namespace: "default"},
+			Spec:       aiv1alpha1.ModelDeploymentSpec{Backend: "vllm", Model: "meta-llama/Llama-3.1-70B-Instruct"},
+		},
+	}
+	if ollama != nil {
+		objs = append(objs, endpoints(t, "chat", ollama))
+	}
+	if vllm != nil {
+		objs = append(objs, endpoints(t, "big", vllm))
+	}
+	return &Gateway{Routes: newTable(t, objs...)}
+}
+
+func newTable(t *testing.T, objs ...client.Object) *Table {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, aiv1alpha1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	table := &Table{Client: c}
+	require.NoError(t, table.Refresh(context.Background()))
+	return table
+}
+
+func post(t *testing.T, g *Gateway, path, body string) *http.Response {
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// events returns the data of the server-sent events in body.
+func events(t *testing.T, body io.Reader) []string {
+	var data []string
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		if d, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, d)
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return data
+}
+
+func TestModels(t *testing.T) {
+	g := newGateway(t, nil, nil)
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/v1/models")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&list))
+	require.Len(t, list.Data, 2)
+	assert.Equal(t, "llama3:8b", list.Data[0].ID)
+	assert.Equal(t, "meta-llama/Llama-3.1-70B-Instruct", list.Data[1].ID)
+}
+
+func TestForwardOpenAI(t *testing.T) {
+	var seen map[string]any
+	vllm := stubVLLM(t, &seen)
+	defer vllm.Close()
+	g := newGateway(t, nil, vllm)
+
+	// Requests may name the ModelDeployment; the backend is sent its model.
+	resp := post(t, g, "/v1/completions", `{"model":"default/big","prompt":"The capital of France","max_tokens":3,"stream":true}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	data := events(t, resp.Body)
+	assert.Len(t, data, 4)
+	assert.Equal(t, "[DONE]", data[3])
+	assert.Equal(t, "meta-llama/Llama-3.1-70B-Instruct", seen["model"])
+	assert.Equal(t, float64(3), seen["max_tokens"], "other fields pass through")
+
+	resp = post(t, g, "/v1/completions", `{"model":"meta-llama/Llama-3.1-70B-Instruct","prompt":"The capital of France"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), "Paris")
+
+	// Three streamed chunks and one reported token over the window.
+	g.tokens.record(time.Now())
+	assert.InDelta(t, 4.0/60, testutil.ToFloat64(metrics.TokensPerSecond.WithLabelValues("meta-llama/Llama-3.1-70B-Instruct", "vllm", "node-0")), 1e-9)
+	assert.InDelta(t, 4.0/60, testutil.ToFloat64(metrics.ReplicaTokensPerSecond.WithLabelValues("default", "big", "big-0")), 1e-9)
+}
+
+func TestTranslateOllamaChat(t *testing.T) {
+	var seen ollamaRequest
+	ollama := stubOllama(t, &seen)
+	defer ollama.Close()
+	g := newGateway(t, ollama, nil)
+
+	resp := post(t, g, "/v1/chat/completions", `{"model":"llama3:8b","max_tokens":16,"temperature":0.2,"stop":"\n",
+		"messages":[{"role":"system","content":"Be brief."},{"role":"user","content":[{"type":"text","text":"Hello"}]}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var out struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Message      ollamaMessage `json:"message"`
+			FinishReason string        `json:"finish_reason"`
+		} `json:"choices"`
+		Usage map[string]int `json:"usage"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	assert.Equal(t, "chat.completion", out.Object)
+	require.Len(t, out.Choices, 1)
+	assert.Equal(t, ollamaMessage{Role: "assistant", Content: "Hi there"}, out.Choices[0].Message)
+	assert.Equal(t, "stop", out.Choices[0].FinishReason)
+	assert.Equal(t, map[string]int{"prompt_tokens": 5, "completion_tokens": 2, "total_tokens": 7}, out.Usage)
+
+	assert.Equal(t, []ollamaMessage{{Role: "system", Content: "Be brief."}, {Role: "user", Content: "Hello"}}, seen.Messages)
+	assert.Equal(t, map[string]any{"num_predict": float64(16), "temperature": 0.2, "stop": []any{"\n"}}, seen.Options)
+}
+
+func TestTranslateOllamaStream(t *testing.T) {
+	var seen ollamaRequest
+	ollama := stubOllama(t, &seen)
+	defer ollama.Close()
+	g := newGateway(t, ollama, nil)
+
+	resp := post(t, g, "/v1/chat/completions", `{"model":"chat","stream":true,"messages":[{"role":"user","content":"Hello"}]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	data := events(t, resp.Body)
+	require.Len(t, data, 4)
+	assert.Equal(t, "[DONE]", data[3])
+
+	var text strings.Builder
+	for i, d := range data[:3] {
+		var chunk struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Delta        map[string]string `json:"delta"`
+				FinishReason *string           `json:"finish_reason"`
+			} `json:"choices"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(d), &chunk))
+		assert.Equal(t, "chat.completion.chunk", chunk.Object)
+		text.WriteString(chunk.Choices[0].Delta["content"])
+		if i == 0 {
+			assert.Equal(t, "assistant", chunk.Choices[0].Delta["role"])
+		}
+		if i == 2 {
+			require.NotNil(t, chunk.Choices[0].FinishReason)
+			assert.Equal(t, "length", *chunk.Choices[0].FinishReason)
+		}
+	}
+	assert.Equal(t, "Hi there", text.String())
+	assert.True(t, seen.Stream)
+	assert.Equal(t, "llama3:8b", seen.Model)
+
+	// Completions go to /api/generate.
+	resp = post(t, g, "/v1/completions", `{"model":"llama3:8b","prompt":["Say hi"],"stream":true}`)
+	data = events(t, resp.Body)
+	assert.Equal(t, "Say hi", seen.Prompt)
+	assert.Contains(t, data[0], `"text":"Hi"`)
+}
+
+func TestErrors(t *testing.T) {
+	g := newGateway(t, nil, nil)
+	for _, tc := range []struct {
+		path, body string
+		want       int
+	}{
+		{"/v1/chat/completions", `{"model":"gpt-4","messages":[]}`, http.StatusNotFound},
+		{"/v1/chat/completions", `{"messages":[]}`, http.StatusBadRequest},
+		{"/v1/chat/completions", `{"model":"chat","messages":[]}`, http.StatusServiceUnavailable},
+		{"/v1/chat/completions", `not json`, http.StatusBadRequest},
+		{"/v1/embeddings", `{}`, http.StatusNotFound},
+	} {
+		resp := post(t, g, tc.path, tc.body)
+		assert.Equal(t, tc.want, resp.StatusCode, "%s %s", tc.path, tc.body)
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.NotEmpty(t, body.Error.Message)
+	}
+}
+
+func TestCanary(t *testing.T) {
+	var stableSeen, canarySeen map[string]any
+	stable, canary := stubVLLM(t, &stableSeen), stubVLLM(t, &canarySeen)
+	defer stable.Close()
+	defer canary.Close()
+	weight := int32(100)
+	g := &Gateway{Routes: newTable(t,
+		&aiv1alpha1.ModelDeployment{
+			ObjectMeta: metav1.ObjectMeta{Name: "ab", Namespace: "default"},
+			Spec: aiv1alpha1.ModelDeploymentSpec{
+				Backend: "vllm",
+				Model:   "meta-llama/Llama-3.1-8B-Instruct",
+				Rollout: &aiv1alpha1.RolloutSpec{CanaryWeight: &weight},
+			},
+			Status: aiv1alpha1.ModelDeploymentStatus{Rollout: &aiv1alpha1.RolloutStatus{
+				StableModel: "meta-llama/Llama-3-8B-Instruct",
+				CanaryModel: "meta-llama/Llama-3.1-8B-Instruct",
+				Phase:       aiv1alpha1.RolloutProgressing,
+			}},
+		},
+		endpoints(t, "ab", stable),
+		endpoints(t, "ab-canary", canary),
+	)}
+
+	// Requests for the stable model are served by the canary's share of pods
+	// with the canary's model.
+	resp := post(t, g, "/v1/completions", `{"model":"meta-llama/Llama-3-8B-Instruct","prompt":"The capital of France"}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, _ = io.ReadAll(resp.Body)
+	assert.Nil(t, stableSeen)
+	assert.Equal(t, "meta-llama/Llama-3.1-8B-Instruct", canarySeen["model"])
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.GeneratedTokens.WithLabelValues("default", "ab", metrics.TrackCanary)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.RequestErrors.WithLabelValues("default", "ab", metrics.TrackCanary)))
+
+	// The canary's model names the ModelDeployment while it runs.
+	routes := g.Routes.Lookup("meta-llama/Llama-3.1-8B-Instruct")
+	require.Len(t, routes, 1)
+	route := routes[0]
+	assert.Equal(t, "meta-llama/Llama-3-8B-Instruct", route.Model)
+	require.NotNil(t, route.Canary)
+	assert.Equal(t, int32(100), route.Canary.Weight)
+	assert.Equal(t, "ab-canary-0", route.Canary.Replicas[0].Pod)
+}
@@ -0,0 +1,319 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// openAIRequest is the part of an OpenAI chat completion or completion request
+// that is translated for Ollama.
+type openAIRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	// Prompt is a string or a list of strings.
+	Prompt any  `json:"prompt"`
+	Stream bool `json:"stream"`
+
+	MaxTokens           *int     `json:"max_tokens"`
+	MaxCompletionTokens *int     `json:"max_completion_tokens"`
+	Temperature         *float64 `json:"temperature"`
+	TopP                *float64 `json:"top_p"`
+	Seed                *int     `json:"seed"`
+	// Stop is a string or a list of strings.
+	Stop any `json:"stop"`
+}
+
+// openAIMessage is a chat message. Content is a string or a list of parts.
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// ollamaMessage is a message of an Ollama /api/chat request or response.
+type ollamaMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ollamaRequest is the body of an Ollama /api/chat or /api/generate request.
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages,omitempty"`
+	Prompt   string          `json:"prompt,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  map[string]any  `json:"options,omitempty"`
+}
+
+// ollamaResponse is an Ollama /api/chat or /api/generate response, or one
+// line of a streamed one.
+type ollamaResponse struct {
+	Message         *ollamaMessage `json:"message"`
+	Response        string         `json:"response"`
+	Done            bool           `json:"done"`
+	DoneReason      string         `json:"done_reason"`
+	PromptEvalCount int            `json:"prompt_eval_count"`
+	EvalCount       int            `json:"eval_count"`
+	Error           string         `json:"error"`
+}
+
+// content returns the text of a chat or generate response.
+func (r *ollamaResponse) content() string {
+	if r.Message != nil {
+		return r.Message.Content
+	}
+	return r.Response
+}
+
+// finishReason maps Ollama's done_reason onto OpenAI's finish_reason.
+func (r *ollamaResponse) finishReason() string {
+	if r.DoneReason == "length" {
+		return "length"
+	}
+	return "stop"
+}
+
+// toOllama translates req for Ollama's /api/chat if chat is set, and
+// /api/generate otherwise.
+func toOllama(req *openAIRequest, model string, chat bool) (ollamaRequest, error) {
+	out := ollamaRequest{Model: model, Stream: req.Stream, Options: map[string]any{}}
+	if chat {
+		for _, m := range req.Messages {
+			text, err := messageText(m.Content)
+			if err != nil {
+				return out, err
+			}
+			out.Messages = append(out.Messages, ollamaMessage{Role: m.Role, Content: text})
+		}
+	} else {
+		switch p := req.Prompt.(type) {
+		case string:
+			out.Prompt = p
+		case []any:
+			if len(p) != 1 {
+				return out, fmt.Errorf("prompt must be a single string, not %d", len(p))
+			}
+			s, ok := p[0].(string)
+			if !ok {
+				return out, fmt.Errorf("prompt must be a string")
+			}
+			out.Prompt = s
+		default:
+			return out, fmt.Errorf("prompt must be a string")
+		}
+	}
+
+	if req.MaxCompletionTokens != nil {
+		out.Options["num_predict"] = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		out.Options["num_predict"] = *req.MaxTokens
+	}
+	if req.Temperature != nil {
+		out.Options["temperature"] = *req.Temperature
+	}
+	if req.TopP != nil {
+		out.Options["top_p"] = *req.TopP
+	}
+	if req.Seed != nil {
+		out.Options["seed"] = *req.Seed
+	}
+	switch s := req.Stop.(type) {
+	case string:
+		out.Options["stop"] = []string{s}
+	case []any:
+		out.Options["stop"] = s
+	}
+	if len(out.Options) == 0 {
+		out.Options = nil
+	}
+	return out, nil
+}
+
+// messageText flattens a chat message's content, a string or a list of
+// parts, to text. Only text parts are supported.
+func messageText(content any) (string, error) {
+	switch c := content.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return c, nil
+	case []any:
+		var b strings.Builder
+		for _, part := range c {
+			p, _ := part.(map[string]any)
+			if p["type"] != "text" {
+				return "", fmt.Errorf("content part of type %v is not supported by this backend", p["type"])
+			}
+			text, _ := p["text"].(string)
+			b.WriteString(text)
+		}
+		return b.String(), nil
+	}
+	return "", fmt.Errorf("message content must be a string or a list of parts")
+}
+
+// serveOllama answers an OpenAI chat completion, if chat is set, or
+// completion request from route's Ollama server, translating the request to
+// its native API and the response back. Streamed responses are re-encoded as
+// server-sent events. It returns the number of tokens generated.
+func (g *Gateway) serveOllama(w http.ResponseWriter, r *http.Request, route Route, req *openAIRequest, chat bool) int {
+	native, err := toOllama(req, route.Model, chat)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return 0
+	}
+	body, err := json.Marshal(native)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return 0
+	}
+	path := "/api/generate"
+	if chat {
+		path = "/api/chat"
+	}
+	resp, err := g.post(r.Context(), route.URL.JoinPath(path).String(), body)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var failed ollamaResponse
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(msg, &failed) != nil || failed.Error == "" {
+			failed.Error = strings.TrimSpace(string(msg))
+		}
+		writeError(w, resp.StatusCode, failed.Error)
+		return 0
+	}
+
+	id, created := completionID(chat), time.Now().Unix()
+	if !native.Stream {
+		var out ollamaResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			writeError(w, http.StatusBadGateway, fmt.Sprintf("failed to decode backend response: %v", err))
+			return 0
+		}
+		choice := map[string]any{"index": 0, "finish_reason": out.finishReason()}
+		object := "text_completion"
+		if chat {
+			object = "chat.completion"
+			choice["message"] = ollamaMessage{Role: "assistant", Content: out.content()}
+		} else {
+			choice["text"] = out.content()
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"id": id, "object": object, "created": created, "model": req.Model,
+			"choices": []any{choice},
+			"usage": map[string]int{
+				"prompt_tokens":     out.PromptEvalCount,
+				"completion_tokens": out.EvalCount,
+				"total_tokens":      out.PromptEvalCount + out.EvalCount,
+			},
+		})
+		return out.EvalCount
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	object := "text_completion"
+	if chat {
+		object = "chat.completion.chunk"
+	}
+	send := func(choice map[string]any) {
+		event, _ := json.Marshal(map[string]any{
+			"id": id, "object": object, "created": created, "model": req.Model,
+			"choices": []any{choice},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", event)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	tokens, chunks := 0, 0
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ollamaResponse
+		if err := json.Unmarshal(line, &chunk); err != nil || chunk.Error != "" {
+			// Headers are out; all that is left is to say so in the stream.
+			msg := chunk.Error
+			if err != nil {
+				msg = fmt.Sprintf("failed to decode backend response: %v", err)
+			}
+			event, _ := json.Marshal(map[string]any{"error": map[string]string{"message": msg, "type": "server_error"}})
+			fmt.Fprintf(w, "data: %s\n\n", event)
+			break
+		}
+		if text := chunk.content(); text != "" || chunks == 0 {
+			choice := map[string]any{"index": 0, "finish_reason": nil}
+			if chat {
+				delta := map[string]string{"content": text}
+				if chunks == 0 {
+					delta["role"] = "assistant"
+				}
+				choice["delta"] = delta
+			} else {
+				choice["text"] = text
+			}
+			send(choice)
+			chunks++
+		}
+		if chunk.Done {
+			tokens = chunk.EvalCount
+			choice := map[string]any{"index": 0, "finish_reason": chunk.finishReason()}
+			if chat {
+				choice["delta"] = map[string]string{}
+			} else {
+				choice["text"] = ""
+			}
+			send(choice)
+			break
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return tokens
+}
+
+// post sends a JSON request to url.
+func (g *Gateway) post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// completionID returns a fresh id for a chat completion, if chat is set, or
+// completion.
+func completionID(chat bool) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	if chat {
+		return "chatcmpl-" + hex.EncodeToString(b)
+	}
+	return "cmpl-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,228 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/backend"
+)
+
+// Route is where requests for a model are sent: one ModelDeployment serving it.
+type Route struct {
+	// Key is the ModelDeployment.
+	Key types.NamespacedName
+	// Model is the model as its backend knows it: the ModelDeployment's
+	// Spec.Model, or while a canary of a new one is rolled out, the stable
+	// model.
+	Model string
+	// Backend is the canonical name of the backend serving it.
+	Backend string
+	// API is the protocol the backend speaks.
+	API backend.API
+	// URL is the ModelDeployment's Service.
+	URL *url.URL
+	// Replicas are the Service's ready pods, which the gateway balances
+	// requests across. Empty while the model is scaled to zero.
+	Replicas []Replica
+	// Canary is the model being rolled out alongside Model, if any.
+	Canary *Canary
+}
+
+// Canary is a new model serving a share of a route's requests while it is
+// rolled out.
+type Canary struct {
+	// Model is the model as its backend knows it.
+	Model string
+	// Weight is the percentage of requests it serves.
+	Weight int32
+	// Replicas are its ready pods.
+	Replicas []Replica
+}
+
+// Replica is one ready pod serving a route.
+type Replica struct {
+	Pod  string
+	Node string
+	URL  *url.URL
+}
+
+// Table maps model names to the ModelDeployments serving them. It is filled
+// by Refresh.
+type Table struct {
+	Client client.Client
+	// Namespace limits the table to one namespace; empty means all.
+	Namespace string
+	// Target returns the URL of key's Service, which serves on port. Defaults
+	// to the Service's cluster DNS name.
+	Target func(key types.NamespacedName, port int32) *url.URL
+
+	mu     sync.RWMutex
+	routes []Route
+}
+
+// Refresh rebuilds the table from the ModelDeployments in the cluster.
+// ModelDeployments whose backend is unknown are skipped.
+func (t *Table) Refresh(ctx context.Context) error {
+	list := &aiv1alpha1.ModelDeploymentList{}
+	if err := t.Client.List(ctx, list, client.InNamespace(t.Namespace)); err != nil {
+		return fmt.Errorf("failed to list ModelDeployments: %w", err)
+	}
+	routes := make([]Route, 0, len(list.Items))
+	for _, md := range list.Items {
+		driver, ok := backend.Lookup(md.Spec.Backend)
+		if !ok {
+			continue
+		}
+		key := types.NamespacedName{Namespace: md.Namespace, Name: md.Name}
+		replicas, err := t.replicas(ctx, key)
+		if err != nil {
+			return err
+		}
+		route := Route{
+			Key:      key,
+			Model:    md.Spec.Model,
+			Backend:  driver.Name(),
+			API:      driver.API(),
+			URL:      t.target(key, driver.Port()),
+			Replicas: replicas,
+		}
+		if rollout := md.Status.Rollout; rollout != nil && rollout.StableModel != "" {
+			route.Model = rollout.StableModel
+			if md.Spec.Rollout != nil && rollout.Phase == aiv1alpha1.RolloutProgressing {
+				canary := types.NamespacedName{Namespace: md.Namespace, Name: md.Name + aiv1alpha1.CanarySuffix}
+				route.Canary = &Canary{Model: rollout.CanaryModel, Weight: aiv1alpha1.DefaultCanaryWeight}
+				if w := md.Spec.Rollout.CanaryWeight; w != nil {
+					route.Canary.Weight = *w
+				}
+				if route.Canary.Replicas, err = t.replicas(ctx, canary); err != nil {
+					return err
+				}
+			}
+		}
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Key.String() < routes[j].Key.String() })
+
+	t.mu.Lock()
+	t.routes = routes
+	t.mu.Unlock()
+	return nil
+}
+
+// replicas returns the ready pods behind key's Service, sorted by name.
+func (t *Table) replicas(ctx context.Context, key types.NamespacedName) ([]Replica, error) {
+	ep := &corev1.Endpoints{}
+	if err := t.Client.Get(ctx, key, ep); apierrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get Endpoints %s: %w", key, err)
+	}
+	var replicas []Replica
+	for _, subset := range ep.Subsets {
+		if len(subset.Ports) == 0 {
+			continue
+		}
+		port := subset.Ports[0].Port
+		for _, p := range subset.Ports {
+			if p.Name == "http" {
+				port = p.Port
+			}
+		}
+		for _, addr := range subset.Addresses {
+			r := Replica{
+				Pod: addr.IP,
+				URL: &url.URL{Scheme: "http", Host: net.JoinHostPort(addr.IP, strconv.Itoa(int(port)))},
+			}
+			if addr.TargetRef != nil {
+				r.Pod = addr.TargetRef.Name
+			}
+			if addr.NodeName != nil {
+				r.Node = *addr.NodeName
+			}
+			replicas = append(replicas, r)
+		}
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].Pod < replicas[j].Pod })
+	return replicas, nil
+}
+
+// Run refreshes the table every interval until ctx is done.
+func (t *Table) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := t.Refresh(ctx); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to refresh routes")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lookup returns the routes for model, which names either the model a
+// ModelDeployment serves, e.g. llama3:8b, or the ModelDeployment itself, as
+// <name> or <namespace>/<name>. A model being rolled out names its
+// ModelDeployment too; requests for it are split like any other.
+func (t *Table) Lookup(model string) []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var routes []Route
+	for _, r := range t.routes {
+		if r.Model == model || r.Key.Name == model || r.Key.String() == model || (r.Canary != nil && r.Canary.Model == model) {
+			routes = append(routes, r)
+		}
+	}
+	return routes
+}
+
+// Routes returns every route.
+func (t *Table) Routes() []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return append([]Route(nil), t.routes...)
+}
+
+// Models returns the distinct models served, sorted.
+func (t *Table) Models() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	seen := map[string]bool{}
+	var models []string
+	for _, r := range t.routes {
+		names := []string{r.Model}
+		if r.Canary != nil {
+			names = append(names, r.Canary.Model)
+		}
+		for _, m := range names {
+			if !seen[m] {
+				seen[m] = true
+				models = append(models, m)
+			}
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+func (t *Table) target(key types.NamespacedName, port int32) *url.URL {
+	if t.Target != nil {
+		return t.Target(key, port)
+	}
+	return &url.URL{Scheme: "http", Host: fmt.Sprintf("%s.%s.svc:%d", key.Name, key.Namespace, port)}
+}
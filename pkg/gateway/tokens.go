@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/flexinfer/flexinfer/pkg/metrics"
+)
+
+// tokenWindow is the window flexinfer_tokens_per_second averages over.
+const tokenWindow = time.Minute
+
+// meterKey identifies where tokens were generated: a model on a backend and,
+// when the request was balanced to one, the replica that served it.
+type meterKey struct {
+	model, backend string
+	route          types.NamespacedName
+	pod, node      string
+}
+
+// seriesKey identifies a flexinfer_tokens_per_second series.
+type seriesKey struct {
+	model, backend, node string
+}
+
+// tokenEvent is a number of tokens generated at a time.
+type tokenEvent struct {
+	at     time.Time
+	tokens int
+}
+
+// tokenMeter keeps the tokens generated for each model and replica over the
+// last tokenWindow.
+type tokenMeter struct {
+	mu     sync.Mutex
+	events map[meterKey][]tokenEvent
+	// rates is each replica's tokens per second as last recorded, keyed by
+	// namespace and pod.
+	rates map[types.NamespacedName]float64
+}
+
+// add records tokens generated for route on replica, which is empty if the
+// request went through the Service, at now.
+func (m *tokenMeter) add(route Route, replica Replica, tokens int, now time.Time) {
+	if tokens <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.events == nil {
+		m.events = map[meterKey][]tokenEvent{}
+	}
+	key := meterKey{model: route.Model, backend: route.Backend, route: route.Key, pod: replica.Pod, node: replica.Node}
+	m.events[key] = append(m.events[key], tokenEvent{at: now, tokens: tokens})
+}
+
+// record sets flexinfer_tokens_per_second for every model and node seen, and
+// flexinfer_replica_tokens_per_second for every replica, from the tokens
+// generated in the window up to now. Models that have gone quiet report 0
+// rather than disappearing, so the autoscaler can tell idle from unknown.
+func (m *tokenMeter) record(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	series := map[seriesKey]int{}
+	rates := map[types.NamespacedName]float64{}
+	for key, events := range m.events {
+		kept := events[:0]
+		total := 0
+		for _, e := range events {
+			if now.Sub(e.at) < tokenWindow {
+				kept = append(kept, e)
+				total += e.tokens
+			}
+		}
+		m.events[key] = kept
+		series[seriesKey{key.model, key.backend, key.node}] += total
+		if key.pod != "" {
+			rate := float64(total) / tokenWindow.Seconds()
+			rates[types.NamespacedName{Namespace: key.route.Namespace, Name: key.pod}] += rate
+			metrics.ReplicaTokensPerSecond.WithLabelValues(key.route.Namespace, key.route.Name, key.pod).Set(rate)
+		}
+	}
+	for key, total := range series {
+		metrics.TokensPerSecond.WithLabelValues(key.model, key.backend, key.node).Set(float64(total) / tokenWindow.Seconds())
+	}
+	m.rates = rates
+}
+
+// rate returns pod's tokens per second as last recorded.
+func (m *tokenMeter) rate(pod types.NamespacedName) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rates[pod]
+}
+
+// forget drops the replicas for which keep returns false, and their series,
+// once their tokens have left the window.
+func (m *tokenMeter) forget(keep func(pod types.NamespacedName) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, events := range m.events {
+		pod := types.NamespacedName{Namespace: key.route.Namespace, Name: key.pod}
+		if key.pod != "" && len(events) == 0 && !keep(pod) {
+			delete(m.events, key)
+			delete(m.rates, pod)
+			metrics.ReplicaTokensPerSecond.DeleteLabelValues(key.route.Namespace, key.route.Name, key.pod)
+		}
+	}
+}
+
+// run records the meter every interval until ctx is done.
+func (m *tokenMeter) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			m.record(now)
+		}
+	}
+}
+
+// openAIChunk is the part of an OpenAI-compatible response, or one event of a
+// streamed one, that tokens are counted from.
+type openAIChunk struct {
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// maxCountedBody is the most of an unstreamed response buffered to count its
+// tokens.
+const maxCountedBody = 1 << 20
+
+// countingBody passes an OpenAI-compatible response through while counting
+// the tokens generated, and reports them on Close. Streamed responses are
+// counted by content chunk unless the backend reports usage.
+type countingBody struct {
+	io.ReadCloser
+	stream bool
+	done   func(tokens int)
+
+	buf      []byte
+	chunks   int
+	reported int
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.buf = append(b.buf, p[:n]...)
+		if b.stream {
+			b.scanEvents()
+		} else if len(b.buf) > maxCountedBody {
+			b.buf = b.buf[:0]
+		}
+	}
+	return n, err
+}
+
+// scanEvents counts the complete server-sent events in the buffer.
+func (b *countingBody) scanEvents() {
+	for {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			return
+		}
+		line := string(b.buf[:i])
+		b.buf = b.buf[i+1:]
+		data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+		if !ok {
+			continue
+		}
+		var chunk openAIChunk
+		if json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk) != nil {
+			continue
+		}
+		b.count(chunk)
+	}
+}
+
+func (b *countingBody) count(chunk openAIChunk) {
+	if chunk.Usage != nil && chunk.Usage.CompletionTokens > 0 {
+		b.reported = chunk.Usage.CompletionTokens
+	}
+	for _, c := range chunk.Choices {
+		if c.Text != "" || c.Delta.Content != "" {
+			b.chunks++
+		}
+	}
+}
+
+func (b *countingBody) Close() error {
+	if !b.stream {
+		var chunk openAIChunk
+		if json.Unmarshal(b.buf, &chunk) == nil {
+			b.count(chunk)
+		}
+	}
+	tokens := b.chunks
+	if b.reported > 0 {
+		tokens = b.reported
+	}
+	b.done(tokens)
+	return b.ReadCloser.Close()
+}
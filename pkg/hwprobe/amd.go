@@ -0,0 +1,105 @@
+package hwprobe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// amdSysfs reads the memory size the amdgpu driver exposes for each device
+// and the architecture from the KFD (ROCm kernel driver) topology.
+func (p *Prober) amdSysfs(gpus []GPU) {
+	if !hasVendor(gpus, VendorAMD) {
+		return
+	}
+	for i := range gpus {
+		g := &gpus[i]
+		if g.Vendor != VendorAMD {
+			continue
+		}
+		if b, err := p.readFile("sys/bus/pci/devices", g.PCIAddress, "mem_info_vram_total"); err == nil {
+			if n, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64); err == nil {
+				g.VRAMBytes = n
+			}
+		}
+	}
+
+	nodes, err := os.ReadDir(p.path("sys/class/kfd/kfd/topology/nodes"))
+	if err != nil {
+		return
+	}
+	for _, n := range nodes {
+		b, err := p.readFile("sys/class/kfd/kfd/topology/nodes", n.Name(), "properties")
+		if err != nil {
+			continue
+		}
+		props := parseProperties(b)
+		// CPU nodes report a zero target version.
+		if props["gfx_target_version"] == 0 {
+			continue
+		}
+		loc := props["location_id"]
+		addr := fmt.Sprintf("%04x:%02x:%02x.%x", props["domain"], loc>>8, (loc>>3)&0x1f, loc&0x7)
+		if g := byAddress(gpus, addr); g != nil {
+			g.Arch = gfxArch(props["gfx_target_version"])
+		}
+	}
+}
+
+// rocmSMI fills in whatever sysfs left unknown about AMD GPUs.
+func (p *Prober) rocmSMI(ctx context.Context, gpus []GPU) {
+	if !hasVendor(gpus, VendorAMD) {
+		return
+	}
+	out, err := p.Run(ctx, "rocm-smi", "--showbus", "--showproductname", "--showmeminfo", "vram", "--json")
+	if err != nil {
+		return
+	}
+	var cards map[string]map[string]string
+	if err := json.Unmarshal(out, &cards); err != nil {
+		return
+	}
+	for _, card := range cards {
+		g := byAddress(gpus, normalizePCIAddress(card["PCI Bus"]))
+		if g == nil {
+			continue
+		}
+		if g.Name == "" {
+			g.Name = card["Card series"]
+		}
+		if g.Arch == "" {
+			g.Arch = card["GFX Version"]
+		}
+		if g.VRAMBytes == 0 {
+			if n, err := strconv.ParseInt(card["VRAM Total Memory (B)"], 10, 64); err == nil {
+				g.VRAMBytes = n
+			}
+		}
+	}
+}
+
+// gfxArch turns a KFD gfx_target_version such as 90010 into "gfx90a".
+func gfxArch(version uint64) string {
+	return fmt.Sprintf("gfx%d%d%x", version/10000, version/100%100, version%100)
+}
+
+// parseProperties parses the "key value" lines of a KFD properties file.
+func parseProperties(b []byte) map[string]uint64 {
+	props := map[string]uint64{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(s.Text()), " ")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64); err == nil {
+			props[key] = n
+		}
+	}
+	return props
+}
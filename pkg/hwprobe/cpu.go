@@ -0,0 +1,152 @@
+package hwprobe
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strings"
+)
+
+// CPU vendors, as reported in CPU.Vendor.
+const (
+	CPUVendorIntel = "intel"
+	CPUVendorAMD   = "amd"
+	CPUVendorARM   = "arm"
+)
+
+// Memory bandwidth classes, as reported in CPU.MemoryBandwidth.
+const (
+	BandwidthLow    = "low"
+	BandwidthMedium = "medium"
+	BandwidthHigh   = "high"
+)
+
+// CPU describes the node's processors.
+type CPU struct {
+	// Vendor is one of CPUVendorIntel, CPUVendorAMD or CPUVendorARM, or
+	// empty if unrecognised.
+	Vendor string
+	// Model is the model name, where the kernel reports one.
+	Model string
+	// Flags holds the instruction set extensions: the x86 "flags" or ARM
+	// "Features" of /proc/cpuinfo.
+	Flags map[string]bool
+	// Cores is the number of physical cores across all sockets.
+	Cores int
+	// Threads is the number of logical CPUs.
+	Threads int
+	// NUMANodes is the number of NUMA nodes, at least 1.
+	NUMANodes int
+}
+
+// AVX2 reports support for 256-bit integer SIMD.
+func (c CPU) AVX2() bool { return c.Flags["avx2"] }
+
+// AVX512 reports support for the AVX-512 foundation instructions.
+func (c CPU) AVX512() bool { return c.Flags["avx512f"] }
+
+// AVX512VNNI reports support for AVX-512 int8 dot products.
+func (c CPU) AVX512VNNI() bool { return c.Flags["avx512_vnni"] }
+
+// AVX512BF16 reports support for AVX-512 bfloat16 arithmetic.
+func (c CPU) AVX512BF16() bool { return c.Flags["avx512_bf16"] }
+
+// AMX reports support for Intel Advanced Matrix Extensions.
+func (c CPU) AMX() bool { return c.Flags["amx_tile"] }
+
+// NEON reports support for ARM Advanced SIMD.
+func (c CPU) NEON() bool { return c.Flags["asimd"] }
+
+// SVE reports support for the ARM Scalable Vector Extension.
+func (c CPU) SVE() bool { return c.Flags["sve"] }
+
+// MemoryBandwidth estimates the node's memory bandwidth class. The kernel
+// does not report channel counts or speeds, so this goes by the kind of
+// machine: multi-socket or many-core servers and AMX parts have many memory
+// channels, mid-size and AVX-512 parts a few, and the rest are desktops or
+// small boards.
+func (c CPU) MemoryBandwidth() string {
+	switch {
+	case c.NUMANodes > 1 || c.Cores >= 32 || c.AMX():
+		return BandwidthHigh
+	case c.Cores >= 8 || c.AVX512():
+		return BandwidthMedium
+	default:
+		return BandwidthLow
+	}
+}
+
+// CPU parses /proc/cpuinfo and /sys/devices/system/node.
+func (p *Prober) CPU() (CPU, error) {
+	b, err := p.readFile("proc/cpuinfo")
+	if err != nil {
+		return CPU{}, err
+	}
+	cpu := parseCPUInfo(b)
+	cpu.NUMANodes = p.numaNodes()
+	return cpu, nil
+}
+
+// parseCPUInfo parses the "key : value" blocks of /proc/cpuinfo, one per
+// logical CPU.
+func parseCPUInfo(b []byte) CPU {
+	cpu := CPU{Flags: map[string]bool{}}
+	cores := map[[2]string]bool{}
+	var physicalID string
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "processor":
+			cpu.Threads++
+		case "vendor_id":
+			switch value {
+			case "GenuineIntel":
+				cpu.Vendor = CPUVendorIntel
+			case "AuthenticAMD":
+				cpu.Vendor = CPUVendorAMD
+			}
+		case "CPU implementer":
+			cpu.Vendor = CPUVendorARM
+		case "model name":
+			cpu.Model = value
+		case "flags", "Features":
+			for _, f := range strings.Fields(value) {
+				cpu.Flags[f] = true
+			}
+		case "physical id":
+			physicalID = value
+		case "core id":
+			cores[[2]string{physicalID, value}] = true
+		}
+	}
+	// ARM kernels do not report core ids; every logical CPU is a core.
+	cpu.Cores = len(cores)
+	if cpu.Cores == 0 {
+		cpu.Cores = cpu.Threads
+	}
+	return cpu
+}
+
+// numaNodes counts the node directories under /sys/devices/system/node.
+func (p *Prober) numaNodes() int {
+	entries, err := os.ReadDir(p.path("sys/devices/system/node"))
+	if err != nil {
+		return 1
+	}
+	n := 0
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, "node") && len(name) > 4 && strings.Trim(name[4:], "0123456789") == "" {
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return n
+}
@@ -0,0 +1,117 @@
+// Package hwprobe discovers the accelerators and CPU features of the node it
+// runs on from sysfs, procfs and, when installed, vendor tooling.
+package hwprobe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// GPU vendors, as reported in GPU.Vendor.
+const (
+	VendorNVIDIA = "NVIDIA"
+	VendorAMD    = "AMD"
+	VendorIntel  = "Intel"
+)
+
+// GPU describes one accelerator.
+type GPU struct {
+	// Vendor is one of VendorNVIDIA, VendorAMD or VendorIntel.
+	Vendor string
+	// PCIAddress is the device's PCI address, e.g. 0000:01:00.0.
+	PCIAddress string
+	// DeviceID is the PCI device ID, e.g. 0x2684.
+	DeviceID string
+	// Name is the marketing name reported by vendor tooling, if any.
+	Name string
+	// Arch is the compute architecture, e.g. sm_89 or gfx90a. Empty if unknown.
+	Arch string
+	// VRAMBytes is the device memory size. Zero if unknown.
+	VRAMBytes int64
+}
+
+// Runner runs a command and returns its standard output.
+type Runner func(ctx context.Context, name string, args ...string) ([]byte, error)
+
+// Prober reads hardware information relative to a filesystem root, so tests
+// can point it at fixture trees.
+type Prober struct {
+	// Root is prepended to every path read, "/" on a real node.
+	Root string
+	// Run executes vendor tooling such as nvidia-smi. A nil Run skips it.
+	Run Runner
+}
+
+// New returns a Prober reading from root and running vendor tooling found on
+// the PATH.
+func New(root string) *Prober {
+	return &Prober{Root: root, Run: Exec}
+}
+
+// Exec is the Runner for installed commands. It fails fast for missing ones.
+func Exec(ctx context.Context, name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return exec.CommandContext(ctx, path, args...).Output()
+}
+
+// GPUs returns the accelerators on the node, ordered by PCI address. Vendor
+// tooling, when present, fills in what sysfs does not expose.
+func (p *Prober) GPUs(ctx context.Context) ([]GPU, error) {
+	gpus, err := p.pciGPUs()
+	if err != nil {
+		return nil, err
+	}
+	p.amdSysfs(gpus)
+	if p.Run != nil {
+		p.nvidiaSMI(ctx, gpus)
+		p.rocmSMI(ctx, gpus)
+	}
+	sort.Slice(gpus, func(i, j int) bool { return gpus[i].PCIAddress < gpus[j].PCIAddress })
+	return gpus, nil
+}
+
+// Primary returns the most common kind of GPU among gpus and how many of it
+// there are. Nodes are labelled with a single kind; a stray display adapter
+// next to a set of accelerators should not win.
+func Primary(gpus []GPU) (GPU, int) {
+	type kind struct {
+		vendor, arch string
+		vram         int64
+	}
+	counts := map[kind]int{}
+	var best GPU
+	bestCount := 0
+	for _, g := range gpus {
+		k := kind{g.Vendor, g.Arch, g.VRAMBytes}
+		counts[k]++
+		// Ties go to the larger device.
+		if c := counts[k]; c > bestCount || (c == bestCount && g.VRAMBytes > best.VRAMBytes) {
+			best, bestCount = g, c
+		}
+	}
+	return best, bestCount
+}
+
+// FormatVRAM renders a memory size as a Kubernetes-style quantity rounded to
+// the nearest GiB, e.g. "24Gi".
+func FormatVRAM(bytes int64) string {
+	const gi = 1 << 30
+	return fmt.Sprintf("%dGi", (bytes+gi/2)/gi)
+}
+
+// path returns name relative to the probe's root.
+func (p *Prober) path(name ...string) string {
+	return filepath.Join(append([]string{p.Root}, name...)...)
+}
+
+// readFile reads a file relative to the probe's root.
+func (p *Prober) readFile(name ...string) ([]byte, error) {
+	return os.ReadFile(p.path(name...))
+}
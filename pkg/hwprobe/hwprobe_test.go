@@ -0,0 +1,196 @@
+package hwprobe
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixture builds a filesystem tree from path -> contents under a temporary
+// root. PCI addresses contain colons, which module zips do not allow, so the
+// trees live in the tests rather than in testdata.
+func fixture(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents+"\n"), 0o644))
+	}
+	return root
+}
+
+// pciDevice returns the sysfs files of one PCI device.
+func pciDevice(files map[string]string, addr, vendor, device, class string) {
+	dir := "sys/bus/pci/devices/" + addr + "/"
+	files[dir+"vendor"] = vendor
+	files[dir+"device"] = device
+	files[dir+"class"] = class
+}
+
+// runner serves canned vendor tool output from testdata.
+func runner(t *testing.T, outputs map[string]string) Runner {
+	return func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		file, ok := outputs[name]
+		if !ok {
+			return nil, errors.New("executable file not found in $PATH")
+		}
+		b, err := os.ReadFile(filepath.Join("testdata", file))
+		require.NoError(t, err)
+		return b, nil
+	}
+}
+
+func nvidiaNode() map[string]string {
+	files := map[string]string{}
+	pciDevice(files, "0000:00:02.0", "0x8086", "0xa780", "0x030000") // integrated graphics
+	pciDevice(files, "0000:00:1f.3", "0x8086", "0x7a50", "0x040380") // audio
+	pciDevice(files, "0000:01:00.0", "0x10de", "0x2684", "0x030000")
+	pciDevice(files, "0000:02:00.0", "0x10de", "0x2684", "0x030000")
+	return files
+}
+
+func TestGPUsNVIDIA(t *testing.T) {
+	p := &Prober{Root: fixture(t, nvidiaNode()), Run: runner(t, map[string]string{"nvidia-smi": "nvidia-smi.csv"})}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, gpus, 2)
+	assert.Equal(t, GPU{
+		Vendor:     VendorNVIDIA,
+		PCIAddress: "0000:01:00.0",
+		DeviceID:   "0x2684",
+		Name:       "NVIDIA GeForce RTX 4090",
+		Arch:       "sm_89",
+		VRAMBytes:  24564 << 20,
+	}, gpus[0])
+
+	primary, count := Primary(gpus)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "24Gi", FormatVRAM(primary.VRAMBytes))
+}
+
+func TestGPUsNVIDIAWithoutTooling(t *testing.T) {
+	p := &Prober{Root: fixture(t, nvidiaNode()), Run: runner(t, nil)}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, gpus, 2)
+	assert.Equal(t, VendorNVIDIA, gpus[1].Vendor)
+	assert.Empty(t, gpus[1].Arch)
+	assert.Zero(t, gpus[1].VRAMBytes)
+}
+
+func TestGPUsAMDFromSysfs(t *testing.T) {
+	files := map[string]string{
+		"sys/bus/pci/devices/0000:03:00.0/mem_info_vram_total": "68702699520",
+		"sys/class/kfd/kfd/topology/nodes/0/properties":        "cpu_cores_count 64\ngfx_target_version 0\nlocation_id 0\ndomain 0",
+		"sys/class/kfd/kfd/topology/nodes/1/properties":        "simd_count 416\ngfx_target_version 90010\nlocation_id 768\ndomain 0",
+	}
+	pciDevice(files, "0000:00:14.0", "0x1022", "0x790b", "0x0c0500")
+	pciDevice(files, "0000:03:00.0", "0x1002", "0x740f", "0x038000")
+
+	p := &Prober{Root: fixture(t, files)}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, gpus, 1)
+	assert.Equal(t, VendorAMD, gpus[0].Vendor)
+	assert.Equal(t, "gfx90a", gpus[0].Arch)
+	assert.Equal(t, "64Gi", FormatVRAM(gpus[0].VRAMBytes))
+}
+
+func TestGPUsAMDFromROCmSMI(t *testing.T) {
+	files := map[string]string{}
+	pciDevice(files, "0000:03:00.0", "0x1002", "0x744c", "0x030000")
+
+	p := &Prober{Root: fixture(t, files), Run: runner(t, map[string]string{"rocm-smi": "rocm-smi.json"})}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+
+	require.Len(t, gpus, 1)
+	assert.Equal(t, "Radeon RX 7900 XTX", gpus[0].Name)
+	assert.Equal(t, "gfx1100", gpus[0].Arch)
+	assert.Equal(t, "24Gi", FormatVRAM(gpus[0].VRAMBytes))
+}
+
+func TestGPUsNoSysfs(t *testing.T) {
+	p := &Prober{Root: t.TempDir()}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, gpus)
+}
+
+func TestPrimary(t *testing.T) {
+	big := GPU{Vendor: VendorNVIDIA, Arch: "sm_90", VRAMBytes: 80 << 30}
+	small := GPU{Vendor: VendorNVIDIA, Arch: "sm_75", VRAMBytes: 16 << 30}
+
+	g, n := Primary([]GPU{small, big, big})
+	assert.Equal(t, big, g)
+	assert.Equal(t, 2, n)
+
+	g, n = Primary([]GPU{small, big})
+	assert.Equal(t, big, g)
+	assert.Equal(t, 1, n)
+
+	_, n = Primary(nil)
+	assert.Zero(t, n)
+}
+
+func TestGfxArch(t *testing.T) {
+	assert.Equal(t, "gfx90a", gfxArch(90010))
+	assert.Equal(t, "gfx942", gfxArch(90402))
+	assert.Equal(t, "gfx1100", gfxArch(110000))
+}
+
+func TestCPUx86(t *testing.T) {
+	files := map[string]string{
+		"sys/devices/system/node/node0/cpulist": "0-3",
+		"sys/devices/system/node/node1/cpulist": "4-7",
+		"sys/devices/system/node/possible":      "0-1",
+	}
+	root := fixture(t, files)
+	cpuinfo, err := os.ReadFile("testdata/cpuinfo-x86")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "proc/cpuinfo"), cpuinfo, 0o644))
+
+	cpu, err := (&Prober{Root: root}).CPU()
+	require.NoError(t, err)
+
+	assert.Equal(t, CPUVendorIntel, cpu.Vendor)
+	assert.Equal(t, "Intel(R) Xeon(R) Platinum 8480+", cpu.Model)
+	assert.Equal(t, 4, cpu.Threads)
+	assert.Equal(t, 2, cpu.Cores)
+	assert.Equal(t, 2, cpu.NUMANodes)
+	assert.True(t, cpu.AVX2())
+	assert.True(t, cpu.AVX512())
+	assert.True(t, cpu.AVX512VNNI())
+	assert.True(t, cpu.AVX512BF16())
+	assert.True(t, cpu.AMX())
+	assert.False(t, cpu.NEON())
+	assert.Equal(t, BandwidthHigh, cpu.MemoryBandwidth())
+}
+
+func TestCPUARM(t *testing.T) {
+	root := t.TempDir()
+	cpuinfo, err := os.ReadFile("testdata/cpuinfo-arm64")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "proc"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "proc/cpuinfo"), cpuinfo, 0o644))
+
+	cpu, err := (&Prober{Root: root}).CPU()
+	require.NoError(t, err)
+
+	assert.Equal(t, CPUVendorARM, cpu.Vendor)
+	assert.Equal(t, 4, cpu.Cores)
+	assert.Equal(t, 1, cpu.NUMANodes)
+	assert.True(t, cpu.NEON())
+	assert.False(t, cpu.SVE())
+	assert.False(t, cpu.AVX2())
+	assert.Equal(t, BandwidthLow, cpu.MemoryBandwidth())
+}
@@ -0,0 +1,102 @@
+package hwprobe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// nvidiaSMI fills in the name, architecture and memory of NVIDIA GPUs, none
+// of which the NVIDIA driver exposes in sysfs.
+func (p *Prober) nvidiaSMI(ctx context.Context, gpus []GPU) {
+	if !hasVendor(gpus, VendorNVIDIA) {
+		return
+	}
+	out, err := p.Run(ctx, "nvidia-smi",
+		"--query-gpu=pci.bus_id,name,memory.total,compute_cap", "--format=csv,noheader,nounits")
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 4 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		g := byAddress(gpus, normalizePCIAddress(fields[0]))
+		if g == nil {
+			continue
+		}
+		g.Name = fields[1]
+		if mib, err := strconv.ParseInt(fields[2], 10, 64); err == nil {
+			g.VRAMBytes = mib << 20
+		}
+		if arch, ok := smArch(fields[3]); ok {
+			g.Arch = arch
+		}
+	}
+}
+
+// smArch turns a compute capability such as "8.9" into "sm_89".
+func smArch(computeCap string) (string, bool) {
+	major, minor, ok := strings.Cut(computeCap, ".")
+	if !ok {
+		return "", false
+	}
+	if _, err := strconv.Atoi(major); err != nil {
+		return "", false
+	}
+	if _, err := strconv.Atoi(minor); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("sm_%s%s", major, minor), true
+}
+
+// normalizePCIAddress converts vendor tool spellings such as
+// "00000000:01:00.0" to the sysfs form "0000:01:00.0".
+func normalizePCIAddress(addr string) string {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+	domain, rest, ok := strings.Cut(addr, ":")
+	if !ok {
+		return addr
+	}
+	if len(domain) > 4 {
+		domain = domain[len(domain)-4:]
+	}
+	return domain + ":" + rest
+}
+
+// byAddress returns the GPU at addr, or nil.
+func byAddress(gpus []GPU, addr string) *GPU {
+	for i := range gpus {
+		if gpus[i].PCIAddress == addr {
+			return &gpus[i]
+		}
+	}
+	return nil
+}
+
+// IndexOf returns the index in gpus of the GPU at pciAddress, in any of the
+// spellings vendor tools use, or -1 if none is there.
+func IndexOf(gpus []GPU, pciAddress string) int {
+	addr := normalizePCIAddress(pciAddress)
+	for i := range gpus {
+		if gpus[i].PCIAddress == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// hasVendor reports whether any of gpus is from vendor.
+func hasVendor(gpus []GPU, vendor string) bool {
+	for _, g := range gpus {
+		if g.Vendor == vendor {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package hwprobe
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// PCI vendor IDs of the accelerators we recognise.
+var pciVendors = map[string]string{
+	"0x10de": VendorNVIDIA,
+	"0x1002": VendorAMD,
+	"0x8086": VendorIntel,
+}
+
+// pciClassDisplay is the PCI base class of display controllers: VGA (0x0300),
+// 3D (0x0302) and other (0x0380) controllers all carry compute devices.
+const pciClassDisplay = "0x03"
+
+// intelIntegratedGPU is the fixed PCI address of Intel integrated graphics,
+// which share system memory and are not worth scheduling models on.
+const intelIntegratedGPU = "0000:00:02.0"
+
+// pciGPUs enumerates display-class PCI devices from known GPU vendors.
+func (p *Prober) pciGPUs() ([]GPU, error) {
+	entries, err := os.ReadDir(p.path("sys/bus/pci/devices"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var gpus []GPU
+	for _, e := range entries {
+		addr := e.Name()
+		class, err := p.readFile("sys/bus/pci/devices", addr, "class")
+		if err != nil || !strings.HasPrefix(strings.TrimSpace(string(class)), pciClassDisplay) {
+			continue
+		}
+		vendorID, err := p.readFile("sys/bus/pci/devices", addr, "vendor")
+		if err != nil {
+			continue
+		}
+		vendor, ok := pciVendors[strings.TrimSpace(string(vendorID))]
+		if !ok || (vendor == VendorIntel && addr == intelIntegratedGPU) {
+			continue
+		}
+		deviceID, _ := p.readFile("sys/bus/pci/devices", addr, "device")
+		gpus = append(gpus, GPU{
+			Vendor:     vendor,
+			PCIAddress: addr,
+			DeviceID:   strings.TrimSpace(string(deviceID)),
+		})
+	}
+	return gpus, nil
+}
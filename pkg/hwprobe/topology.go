@@ -0,0 +1,314 @@
+package hwprobe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Link is how directly two GPUs are connected. Links order from slowest to
+// fastest, so the larger of two links is the better connection.
+type Link int
+
+// Links, named as in `nvidia-smi topo -m`.
+const (
+	LinkUnknown Link = iota
+	// LinkSYS crosses the interconnect between NUMA nodes, e.g. UPI.
+	LinkSYS
+	// LinkNODE crosses PCIe host bridges within a NUMA node.
+	LinkNODE
+	// LinkPHB crosses a PCIe host bridge, typically the CPU.
+	LinkPHB
+	// LinkPXB crosses several PCIe bridges, but no host bridge.
+	LinkPXB
+	// LinkPIX crosses at most one PCIe bridge, e.g. GPUs on one switch.
+	LinkPIX
+	// LinkNVLink is a single NVLink; NVLinks(n) is a bond of n.
+	LinkNVLink
+)
+
+// NVLinks returns the link of a bond of n NVLinks.
+func NVLinks(n int) Link {
+	return LinkNVLink + Link(n-1)
+}
+
+// String returns the link as nvidia-smi abbreviates it, e.g. PIX or NV12.
+func (l Link) String() string {
+	switch {
+	case l >= LinkNVLink:
+		return fmt.Sprintf("NV%d", int(l-LinkNVLink)+1)
+	case l == LinkPIX:
+		return "PIX"
+	case l == LinkPXB:
+		return "PXB"
+	case l == LinkPHB:
+		return "PHB"
+	case l == LinkNODE:
+		return "NODE"
+	case l == LinkSYS:
+		return "SYS"
+	}
+	return "?"
+}
+
+// parseLink parses a link as nvidia-smi abbreviates it. SOC is what older
+// drivers call SYS.
+func parseLink(s string) Link {
+	switch s {
+	case "SYS", "SOC":
+		return LinkSYS
+	case "NODE":
+		return LinkNODE
+	case "PHB":
+		return LinkPHB
+	case "PXB":
+		return LinkPXB
+	case "PIX":
+		return LinkPIX
+	}
+	if n, err := strconv.Atoi(strings.TrimPrefix(s, "NV")); err == nil && strings.HasPrefix(s, "NV") && n > 0 {
+		return NVLinks(n)
+	}
+	return LinkUnknown
+}
+
+// Topology is how each pair of a node's GPUs is connected: Topology[i][j] is
+// the link between the GPUs with index i and j, in the order GPUs returns
+// them.
+type Topology [][]Link
+
+// String encodes t for the gpu.topology annotation: one row per GPU,
+// separated by semicolons, of comma-separated links, with X for the GPU
+// itself, e.g. "X,NV4;NV4,X".
+func (t Topology) String() string {
+	rows := make([]string, len(t))
+	for i, row := range t {
+		links := make([]string, len(row))
+		for j, l := range row {
+			if i == j {
+				links[j] = "X"
+			} else {
+				links[j] = l.String()
+			}
+		}
+		rows[i] = strings.Join(links, ",")
+	}
+	return strings.Join(rows, ";")
+}
+
+// ParseTopology decodes a topology encoded by Topology.String.
+func ParseTopology(s string) (Topology, error) {
+	rows := strings.Split(s, ";")
+	t := make(Topology, len(rows))
+	for i, row := range rows {
+		links := strings.Split(row, ",")
+		if len(links) != len(rows) {
+			return nil, fmt.Errorf("row %d has %d links, want %d", i, len(links), len(rows))
+		}
+		t[i] = make([]Link, len(links))
+		for j, l := range links {
+			if i != j {
+				t[i][j] = parseLink(strings.TrimSpace(l))
+			}
+		}
+	}
+	return t, nil
+}
+
+// BestGroup returns the size GPUs, other than those in busy, that are best
+// connected to each other, and the slowest link between them. Groups are
+// ranked by their slowest link, then by the sum of their links. It returns
+// nil if fewer than size GPUs are free.
+func (t Topology) BestGroup(size int, busy map[int]bool) ([]int, Link) {
+	var free []int
+	for i := range t {
+		if !busy[i] {
+			free = append(free, i)
+		}
+	}
+	if size < 1 || len(free) < size {
+		return nil, LinkUnknown
+	}
+
+	var best []int
+	bestWeakest, bestSum := LinkUnknown, -1
+	group := make([]int, 0, size)
+	var search func(from int)
+	search = func(from int) {
+		if len(group) == size {
+			weakest, sum := t.weakest(group)
+			if best == nil || weakest > bestWeakest || (weakest == bestWeakest && sum > bestSum) {
+				best = append([]int(nil), group...)
+				bestWeakest, bestSum = weakest, sum
+			}
+			return
+		}
+		// Stop once too few GPUs are left to complete the group.
+		for i := from; i <= len(free)-(size-len(group)); i++ {
+			group = append(group, free[i])
+			search(i + 1)
+			group = group[:len(group)-1]
+		}
+	}
+	search(0)
+	return best, bestWeakest
+}
+
+// weakest returns the slowest link between the GPUs in group and the sum of
+// all their links. A single GPU has no links.
+func (t Topology) weakest(group []int) (Link, int) {
+	weakest, sum := LinkUnknown, 0
+	for a := 0; a < len(group); a++ {
+		for b := a + 1; b < len(group); b++ {
+			l := t[group[a]][group[b]]
+			if weakest == LinkUnknown || l < weakest {
+				weakest = l
+			}
+			sum += int(l)
+		}
+	}
+	return weakest, sum
+}
+
+// Topology returns how gpus, as returned by GPUs, are connected, or nil if
+// there are fewer than two or how they are connected is unknown. The matrix
+// from `nvidia-smi topo -m`, which also knows about NVLink, is used when it
+// covers every GPU; otherwise links are worked out from where the GPUs sit
+// in the PCI hierarchy.
+func (p *Prober) Topology(ctx context.Context, gpus []GPU) Topology {
+	if len(gpus) < 2 {
+		return nil
+	}
+	if p.Run != nil && onlyVendor(gpus, VendorNVIDIA) {
+		if out, err := p.Run(ctx, "nvidia-smi", "topo", "-m"); err == nil {
+			if t := parseNvidiaTopo(out); len(t) == len(gpus) {
+				return t
+			}
+		}
+	}
+	return p.pciTopology(gpus)
+}
+
+var (
+	// ansiEscape matches the escape sequences nvidia-smi underlines the
+	// matrix header with.
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	gpuColumn  = regexp.MustCompile(`^GPU\d+$`)
+)
+
+// parseNvidiaTopo parses the GPU-to-GPU links of `nvidia-smi topo -m`,
+// ignoring NIC columns and CPU and NUMA affinity. GPUs are numbered in PCI
+// bus order, as GPUs returns them.
+func parseNvidiaTopo(out []byte) Topology {
+	var t Topology
+	n := 0
+	for _, line := range strings.Split(ansiEscape.ReplaceAllString(string(out), ""), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !gpuColumn.MatchString(fields[0]) {
+			continue
+		}
+		// The first such line is the header naming the columns, the rest
+		// are rows.
+		if n == 0 {
+			for _, f := range fields {
+				if !gpuColumn.MatchString(f) {
+					break
+				}
+				n++
+			}
+			continue
+		}
+		if len(fields) < n+1 {
+			return nil
+		}
+		row := make([]Link, n)
+		for j, f := range fields[1 : n+1] {
+			if f != "X" {
+				row[j] = parseLink(f)
+			}
+		}
+		t = append(t, row)
+	}
+	if len(t) != n {
+		return nil
+	}
+	return t
+}
+
+// pciTopology works out the links between gpus from their places in the PCI
+// hierarchy under sysfs: the bridges above each device, the root complex
+// (host bridge) it hangs off and its NUMA node.
+func (p *Prober) pciTopology(gpus []GPU) Topology {
+	type place struct {
+		root    string
+		bridges []string
+		numa    string
+	}
+	places := make([]*place, len(gpus))
+	for i, g := range gpus {
+		// e.g. ../../../devices/pci0000:00/0000:00:01.0/0000:01:00.0
+		target, err := os.Readlink(p.path("sys/bus/pci/devices", g.PCIAddress))
+		if err != nil {
+			continue
+		}
+		_, path, ok := strings.Cut(target, "devices/")
+		if !ok || !strings.HasPrefix(path, "pci") {
+			continue
+		}
+		segments := strings.Split(path, "/")
+		numa, _ := p.readFile("sys/bus/pci/devices", g.PCIAddress, "numa_node")
+		places[i] = &place{root: segments[0], bridges: segments[1 : len(segments)-1], numa: strings.TrimSpace(string(numa))}
+	}
+
+	t := make(Topology, len(gpus))
+	known := false
+	for i := range t {
+		t[i] = make([]Link, len(gpus))
+		for j := range t[i] {
+			a, b := places[i], places[j]
+			if i == j || a == nil || b == nil {
+				continue
+			}
+			known = true
+			switch common := commonPrefix(a.bridges, b.bridges); {
+			case a.root != b.root && a.numa == b.numa:
+				t[i][j] = LinkNODE
+			case a.root != b.root:
+				t[i][j] = LinkSYS
+			case common == 0:
+				t[i][j] = LinkPHB
+			case len(a.bridges) == common+1 && len(b.bridges) == common+1:
+				// Neighbouring ports of one switch.
+				t[i][j] = LinkPIX
+			default:
+				t[i][j] = LinkPXB
+			}
+		}
+	}
+	if !known {
+		return nil
+	}
+	return t
+}
+
+// commonPrefix returns how many leading elements a and b share.
+func commonPrefix(a, b []string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// onlyVendor reports whether every one of gpus is from vendor.
+func onlyVendor(gpus []GPU, vendor string) bool {
+	for _, g := range gpus {
+		if g.Vendor != vendor {
+			return false
+		}
+	}
+	return true
+}
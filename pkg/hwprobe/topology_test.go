@@ -0,0 +1,128 @@
+package hwprobe
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func nvidiaGPUs(n int) []GPU {
+	gpus := make([]GPU, n)
+	for i := range gpus {
+		gpus[i] = GPU{Vendor: VendorNVIDIA}
+	}
+	return gpus
+}
+
+func TestTopologyNvidiaSMI(t *testing.T) {
+	p := &Prober{Root: t.TempDir(), Run: runner(t, map[string]string{"nvidia-smi": "nvidia-smi-topo-dgx.txt"})}
+	topo := p.Topology(context.Background(), nvidiaGPUs(8))
+	require.Len(t, topo, 8)
+	for i := range topo {
+		for j := range topo[i] {
+			if i != j {
+				assert.Equal(t, NVLinks(12), topo[i][j], "GPU%d-GPU%d", i, j)
+			}
+		}
+	}
+
+	// A matrix that does not cover every GPU is not used.
+	assert.Nil(t, p.Topology(context.Background(), nvidiaGPUs(4)))
+}
+
+func TestTopologyString(t *testing.T) {
+	out, err := os.ReadFile("testdata/nvidia-smi-topo-pairs.txt")
+	require.NoError(t, err)
+	topo := parseNvidiaTopo(out)
+	require.Len(t, topo, 4)
+
+	s := topo.String()
+	assert.Equal(t, "X,NV4,SYS,SYS;NV4,X,SYS,SYS;SYS,SYS,X,NV4;SYS,SYS,NV4,X", s)
+	parsed, err := ParseTopology(s)
+	require.NoError(t, err)
+	assert.Equal(t, topo, parsed)
+
+	_, err = ParseTopology("X,NV4;NV4")
+	assert.Error(t, err)
+}
+
+func TestBestGroup(t *testing.T) {
+	out, err := os.ReadFile("testdata/nvidia-smi-topo-pairs.txt")
+	require.NoError(t, err)
+	topo := parseNvidiaTopo(out)
+
+	for _, tc := range []struct {
+		size      int
+		busy      map[int]bool
+		wantGroup []int
+		wantLink  Link
+	}{
+		{size: 2, wantGroup: []int{0, 1}, wantLink: NVLinks(4)},
+		{size: 2, busy: map[int]bool{0: true}, wantGroup: []int{2, 3}, wantLink: NVLinks(4)},
+		{size: 2, busy: map[int]bool{0: true, 2: true}, wantGroup: []int{1, 3}, wantLink: LinkSYS},
+		{size: 4, wantGroup: []int{0, 1, 2, 3}, wantLink: LinkSYS},
+		{size: 4, busy: map[int]bool{3: true}},
+	} {
+		group, link := topo.BestGroup(tc.size, tc.busy)
+		assert.Equal(t, tc.wantGroup, group, "size %d, busy %v", tc.size, tc.busy)
+		assert.Equal(t, tc.wantLink, link, "size %d, busy %v", tc.size, tc.busy)
+	}
+}
+
+// pciTree lays out AMD GPUs as the kernel does: the device directories under
+// sys/devices/<root complex>/<bridges>/..., each with its NUMA node, and
+// symlinks to them from sys/bus/pci/devices.
+func pciTree(t *testing.T, devices map[string]string) string {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "sys/bus/pci/devices"), 0o755))
+	for path, numa := range devices {
+		dir := filepath.Join(root, "sys/devices", path)
+		require.NoError(t, os.MkdirAll(dir, 0o755))
+		for name, contents := range map[string]string{"vendor": "0x1002", "device": "0x740f", "class": "0x038000", "numa_node": numa} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(contents+"\n"), 0o644))
+		}
+		link := filepath.Join(root, "sys/bus/pci/devices", filepath.Base(path))
+		require.NoError(t, os.Symlink("../../../devices/"+path, link))
+	}
+	return root
+}
+
+func TestTopologyFromPCI(t *testing.T) {
+	// GPUs 0 and 1 share a switch, 2 hangs off another port of the same
+	// root complex, 3 off another root complex on the same NUMA node and 4
+	// off the other socket.
+	p := &Prober{Root: pciTree(t, map[string]string{
+		"pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:00.0/0000:03:00.0": "0",
+		"pci0000:00/0000:00:01.0/0000:01:00.0/0000:02:01.0/0000:04:00.0": "0",
+		"pci0000:00/0000:00:03.0/0000:05:00.0":                           "0",
+		"pci0000:40/0000:40:01.0/0000:41:00.0":                           "0",
+		"pci0000:80/0000:80:01.0/0000:81:00.0":                           "1",
+	})}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+	require.Len(t, gpus, 5)
+
+	topo := p.Topology(context.Background(), gpus)
+	want := []string{
+		"X,PIX,PHB,NODE,SYS",
+		"PIX,X,PHB,NODE,SYS",
+		"PHB,PHB,X,NODE,SYS",
+		"NODE,NODE,NODE,X,SYS",
+		"SYS,SYS,SYS,SYS,X",
+	}
+	assert.Equal(t, strings.Join(want, ";"), topo.String())
+}
+
+func TestTopologyUnknown(t *testing.T) {
+	// The fixture's PCI devices are plain directories, not symlinks into
+	// the hierarchy, and there is no nvidia-smi.
+	p := &Prober{Root: fixture(t, nvidiaNode()), Run: runner(t, nil)}
+	gpus, err := p.GPUs(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, p.Topology(context.Background(), gpus))
+}
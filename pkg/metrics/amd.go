@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+)
+
+// ROCmSMICollector parses rocm-smi's JSON output.
+type ROCmSMICollector struct {
+	Run hwprobe.Runner
+}
+
+// Name implements Collector.
+func (c *ROCmSMICollector) Name() string { return "rocm-smi" }
+
+// clockMHz matches rocm-smi clock readings such as "(1700Mhz)".
+var clockMHz = regexp.MustCompile(`(\d+)\s*Mhz`)
+
+// Collect implements Collector.
+func (c *ROCmSMICollector) Collect(ctx context.Context) ([]GPUStats, error) {
+	out, err := c.Run(ctx, "rocm-smi", "--showbus", "--showtemp", "--showuse", "--showmeminfo", "vram",
+		"--showpower", "--showclocks", "--json")
+	if err != nil {
+		return nil, err
+	}
+	var cards map[string]map[string]string
+	if err := json.Unmarshal(out, &cards); err != nil {
+		return nil, fmt.Errorf("failed to parse rocm-smi output: %w", err)
+	}
+
+	var stats []GPUStats
+	for card, values := range cards {
+		index, ok := strings.CutPrefix(card, "card")
+		if !ok {
+			continue // e.g. the "system" section
+		}
+		s := NewGPUStats(index)
+		for key, value := range values {
+			switch {
+			case key == "PCI Bus":
+				s.PCIAddress = value
+			case strings.HasPrefix(key, "Temperature (Sensor edge)"):
+				s.TemperatureCelsius = parseFloat(value)
+			case key == "GPU use (%)":
+				s.UtilizationPercent = parseFloat(value)
+			case key == "VRAM Total Used Memory (B)":
+				s.MemoryUsedBytes = parseFloat(value)
+			case key == "VRAM Total Memory (B)":
+				s.MemoryTotalBytes = parseFloat(value)
+			// The key depends on the rocm-smi version and the GPU.
+			case strings.Contains(key, "Graphics Package Power (W)"):
+				s.PowerWatts = parseFloat(value)
+			case strings.HasPrefix(key, "sclk clock speed"):
+				if m := clockMHz.FindStringSubmatch(value); m != nil {
+					s.ClockMHz = parseFloat(m[1])
+				}
+			}
+		}
+		stats = append(stats, s)
+	}
+	sortStats(stats)
+	return stats, nil
+}
+
+// HwmonCollector reads the DRM and hwmon sysfs interfaces that amdgpu and
+// Intel's drivers expose, needing no vendor tooling at all.
+type HwmonCollector struct {
+	// Root is prepended to every path read, "/" on a real node.
+	Root string
+}
+
+// Name implements Collector.
+func (c *HwmonCollector) Name() string { return "hwmon" }
+
+// Collect implements Collector.
+func (c *HwmonCollector) Collect(ctx context.Context) ([]GPUStats, error) {
+	cards, err := filepath.Glob(filepath.Join(c.Root, "sys/class/drm/card[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+	var stats []GPUStats
+	for _, card := range cards {
+		index, ok := strings.CutPrefix(filepath.Base(card), "card")
+		// Skip connectors such as card0-DP-1.
+		if !ok || strings.Contains(index, "-") {
+			continue
+		}
+		device := filepath.Join(card, "device")
+		hwmons, _ := filepath.Glob(filepath.Join(device, "hwmon", "hwmon*"))
+		if len(hwmons) == 0 {
+			continue
+		}
+		hwmon := hwmons[0]
+
+		s := NewGPUStats(index)
+		// The card number counts every display adapter, not just GPUs; the
+		// device link names the PCI device behind it.
+		if link, err := os.Readlink(device); err == nil {
+			s.PCIAddress = filepath.Base(link)
+		}
+		s.TemperatureCelsius = readSysfs(filepath.Join(hwmon, "temp1_input")) / 1000
+		s.PowerWatts = readSysfs(filepath.Join(hwmon, "power1_average")) / 1e6
+		if math.IsNaN(s.PowerWatts) {
+			s.PowerWatts = readSysfs(filepath.Join(hwmon, "power1_input")) / 1e6
+		}
+		s.ClockMHz = readSysfs(filepath.Join(hwmon, "freq1_input")) / 1e6
+		s.UtilizationPercent = readSysfs(filepath.Join(device, "gpu_busy_percent"))
+		s.MemoryUsedBytes = readSysfs(filepath.Join(device, "mem_info_vram_used"))
+		s.MemoryTotalBytes = readSysfs(filepath.Join(device, "mem_info_vram_total"))
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+// readSysfs reads a numeric sysfs attribute, or NaN if it is missing.
+func readSysfs(path string) float64 {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return math.NaN()
+	}
+	return parseFloat(string(b))
+}
+
+// sortStats orders stats by numeric GPU index.
+func sortStats(stats []GPUStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		a, errA := strconv.Atoi(stats[i].GPU)
+		b, errB := strconv.Atoi(stats[j].GPU)
+		if errA != nil || errB != nil {
+			return stats[i].GPU < stats[j].GPU
+		}
+		return a < b
+	})
+}
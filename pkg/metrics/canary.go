@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CanaryStats is how a canary has served the gateway's requests.
+type CanaryStats struct {
+	// Requests is the number of requests it served, successful or not.
+	Requests float64
+	// Errors is the number of those that failed with a server error.
+	Errors float64
+	// TokensPerSecond is the tokens generated per second of request time,
+	// which is how the benchmark measures it. NaN when unknown.
+	TokensPerSecond float64
+}
+
+// CanaryQuery identifies a ModelDeployment's canary and the window to report on.
+type CanaryQuery struct {
+	Namespace       string
+	ModelDeployment string
+	// Since is how far back to look.
+	Since time.Duration
+}
+
+// CanarySource reports how a ModelDeployment's canary has served.
+type CanarySource interface {
+	Canary(ctx context.Context, q CanaryQuery) (CanaryStats, error)
+}
+
+// Canary implements CanarySource from the gateway's request metrics.
+func (p *PrometheusLoadSource) Canary(ctx context.Context, q CanaryQuery) (CanaryStats, error) {
+	stats := CanaryStats{TokensPerSecond: math.NaN()}
+	window := max(int64(q.Since.Seconds()), 1)
+	increase := func(metric string) (float64, error) {
+		v, err := p.query(ctx, fmt.Sprintf(`sum(increase(%s{namespace=%q,modeldeployment=%q,track=%q}[%ds]))`,
+			metric, q.Namespace, q.ModelDeployment, TrackCanary, window))
+		if math.IsNaN(v) {
+			// No series: the canary has not been sent a request.
+			v = 0
+		}
+		return v, err
+	}
+
+	succeeded, err := increase("flexinfer_request_duration_seconds_count")
+	if err != nil {
+		return stats, err
+	}
+	if stats.Errors, err = increase("flexinfer_request_errors_total"); err != nil {
+		return stats, err
+	}
+	stats.Requests = succeeded + stats.Errors
+	tokens, err := increase("flexinfer_generated_tokens_total")
+	if err != nil {
+		return stats, err
+	}
+	seconds, err := increase("flexinfer_request_duration_seconds_sum")
+	if err != nil {
+		return stats, err
+	}
+	if seconds > 0 {
+		stats.TokensPerSecond = tokens / seconds
+	}
+	return stats, nil
+}
+
+// FakeCanarySource returns canned canary stats, for tests.
+type FakeCanarySource struct {
+	Value CanaryStats
+	Err   error
+	// Queries records the queries made.
+	Queries []CanaryQuery
+}
+
+// Canary implements CanarySource.
+func (f *FakeCanarySource) Canary(ctx context.Context, q CanaryQuery) (CanaryStats, error) {
+	f.Queries = append(f.Queries, q)
+	return f.Value, f.Err
+}
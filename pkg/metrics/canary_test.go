@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusCanary(t *testing.T) {
+	values := map[string]string{
+		"flexinfer_request_duration_seconds_count": "95",
+		"flexinfer_request_errors_total":           "5",
+		"flexinfer_generated_tokens_total":         "12000",
+		"flexinfer_request_duration_seconds_sum":   "300",
+	}
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("query")
+		queries = append(queries, q)
+		for metric, v := range values {
+			if strings.Contains(q, metric+"{") {
+				fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,%q]}]}}`, v)
+				return
+			}
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+	}))
+	defer srv.Close()
+
+	p := &PrometheusLoadSource{URL: srv.URL}
+	q := CanaryQuery{Namespace: "default", ModelDeployment: "llama", Since: 10 * time.Minute}
+	stats, err := p.Canary(context.Background(), q)
+	require.NoError(t, err)
+	assert.Equal(t, CanaryStats{Requests: 100, Errors: 5, TokensPerSecond: 40}, stats)
+	assert.Equal(t, `sum(increase(flexinfer_request_errors_total{namespace="default",modeldeployment="llama",track="canary"}[600s]))`, queries[1])
+
+	// A canary that has not been sent a request has served none.
+	values = nil
+	stats, err = p.Canary(context.Background(), q)
+	require.NoError(t, err)
+	assert.Zero(t, stats.Requests)
+	assert.True(t, math.IsNaN(stats.TokensPerSecond))
+}
@@ -35,6 +35,128 @@ var (
 		},
 		[]string{"gpu", "node"},
 	)
+
+	// GPUUtilization is a gauge for the GPU busy percentage.
+	GPUUtilization = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_gpu_utilization_percent",
+			Help: "Percentage of time the GPU was busy.",
+		},
+		[]string{"gpu", "node"},
+	)
+
+	// GPUMemoryUsedBytes is a gauge for the GPU memory in use.
+	GPUMemoryUsedBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_gpu_memory_used_bytes",
+			Help: "GPU memory in use.",
+		},
+		[]string{"gpu", "node"},
+	)
+
+	// GPUMemoryTotalBytes is a gauge for the GPU memory size.
+	GPUMemoryTotalBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_gpu_memory_total_bytes",
+			Help: "Total GPU memory.",
+		},
+		[]string{"gpu", "node"},
+	)
+
+	// GPUPowerWatts is a gauge for the GPU power draw.
+	GPUPowerWatts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_gpu_power_watts",
+			Help: "GPU power draw in watts.",
+		},
+		[]string{"gpu", "node"},
+	)
+
+	// GPUClockMHz is a gauge for the GPU core clock.
+	GPUClockMHz = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_gpu_clock_mhz",
+			Help: "GPU core (SM/graphics) clock in MHz.",
+		},
+		[]string{"gpu", "node"},
+	)
+
+	// ColdStartSeconds is a histogram of how long the activator held requests
+	// for a model scaled to zero.
+	ColdStartSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "flexinfer_cold_start_seconds",
+			Help:    "Time from the first request for a model scaled to zero until it was ready.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+		},
+		[]string{"namespace", "modeldeployment"},
+	)
+
+	// ReplicaInFlightRequests is a gauge for the requests the gateway has in
+	// flight to each backend pod.
+	ReplicaInFlightRequests = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_replica_in_flight_requests",
+			Help: "Requests the gateway has in flight to a backend pod.",
+		},
+		[]string{"namespace", "modeldeployment", "pod"},
+	)
+
+	// ReplicaKVCacheUsage is a gauge for how full each backend pod's KV cache is.
+	ReplicaKVCacheUsage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_replica_kv_cache_usage_ratio",
+			Help: "Fraction of a backend pod's KV cache in use, as last scraped from the pod.",
+		},
+		[]string{"namespace", "modeldeployment", "pod"},
+	)
+
+	// ReplicaTokensPerSecond is a gauge for the tokens each backend pod
+	// generated through the gateway.
+	ReplicaTokensPerSecond = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "flexinfer_replica_tokens_per_second",
+			Help: "Rolling 1-minute average tokens per second generated by a backend pod through the gateway.",
+		},
+		[]string{"namespace", "modeldeployment", "pod"},
+	)
+
+	// RequestDurationSeconds is a histogram for how long the gateway's
+	// successful requests took, by whether the stable or canary pods served them.
+	RequestDurationSeconds = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "flexinfer_request_duration_seconds",
+			Help:    "Time from forwarding a request to a model until its response was complete, for requests that succeeded.",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 12),
+		},
+		[]string{"namespace", "modeldeployment", "track"},
+	)
+
+	// RequestErrors is a counter for the gateway's requests that failed with a
+	// server error.
+	RequestErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flexinfer_request_errors_total",
+			Help: "Requests to a model that failed with a server error.",
+		},
+		[]string{"namespace", "modeldeployment", "track"},
+	)
+
+	// GeneratedTokens is a counter for the tokens generated for the gateway's
+	// successful requests.
+	GeneratedTokens = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "flexinfer_generated_tokens_total",
+			Help: "Tokens generated for requests to a model that succeeded.",
+		},
+		[]string{"namespace", "modeldeployment", "track"},
+	)
+)
+
+// Tracks label the pods requests went to in the request metrics.
+const (
+	TrackStable = "stable"
+	TrackCanary = "canary"
 )
 
 func init() {
@@ -42,6 +164,18 @@ func init() {
 	prometheus.MustRegister(TokensPerSecond)
 	prometheus.MustRegister(ModelLoadSeconds)
 	prometheus.MustRegister(GPUTemperature)
+	prometheus.MustRegister(GPUUtilization)
+	prometheus.MustRegister(GPUMemoryUsedBytes)
+	prometheus.MustRegister(GPUMemoryTotalBytes)
+	prometheus.MustRegister(GPUPowerWatts)
+	prometheus.MustRegister(GPUClockMHz)
+	prometheus.MustRegister(ColdStartSeconds)
+	prometheus.MustRegister(ReplicaInFlightRequests)
+	prometheus.MustRegister(ReplicaKVCacheUsage)
+	prometheus.MustRegister(ReplicaTokensPerSecond)
+	prometheus.MustRegister(RequestDurationSeconds)
+	prometheus.MustRegister(RequestErrors)
+	prometheus.MustRegister(GeneratedTokens)
 }
 
 // Exporter handles serving the Prometheus metrics.
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Load is the traffic on a model's replicas. Values are NaN when unknown.
+type Load struct {
+	// TokensPerSecond is the generation throughput summed over the replicas,
+	// from flexinfer_replica_tokens_per_second.
+	TokensPerSecond float64
+	// InFlightRequests is the number of requests the replicas are serving or
+	// have queued, from the backend's own gauges.
+	InFlightRequests float64
+}
+
+// LoadQuery identifies the replicas of one model.
+type LoadQuery struct {
+	// Namespace and Deployment select the replicas' pods. The Deployment is
+	// named after its ModelDeployment.
+	Namespace  string
+	Deployment string
+	// QueueMetrics are the backend gauges that add up to the requests in
+	// flight, e.g. vllm:num_requests_running and vllm:num_requests_waiting.
+	// Empty if the backend exports none.
+	QueueMetrics []string
+}
+
+// LoadSource reports the load on a model's replicas.
+type LoadSource interface {
+	Load(ctx context.Context, q LoadQuery) (Load, error)
+}
+
+// PrometheusLoadSource queries a Prometheus server that scrapes the gateway's
+// flexinfer_* metrics and the backend pods. Pods are matched on the
+// namespace and pod labels Prometheus' Kubernetes service discovery adds,
+// which the gateway's series carry themselves; scrape it with honor_labels.
+type PrometheusLoadSource struct {
+	// URL is the server's base URL, e.g. http://prometheus.monitoring:9090.
+	URL    string
+	Client *http.Client
+}
+
+// Load implements LoadSource.
+func (p *PrometheusLoadSource) Load(ctx context.Context, q LoadQuery) (Load, error) {
+	load := Load{TokensPerSecond: math.NaN(), InFlightRequests: math.NaN()}
+	var err error
+	// Deployment pods are named <deployment>-<replicaset hash>-<suffix>,
+	// which leaves out those of a <deployment>-canary.
+	pods := regexp.QuoteMeta(q.Deployment) + "-[a-z0-9]+-[a-z0-9]+"
+	load.TokensPerSecond, err = p.query(ctx, fmt.Sprintf(`sum(flexinfer_replica_tokens_per_second{namespace=%q,modeldeployment=%q,pod=~%q})`,
+		q.Namespace, q.Deployment, pods))
+	if err != nil {
+		return load, err
+	}
+	if len(q.QueueMetrics) > 0 {
+		names := make([]string, len(q.QueueMetrics))
+		for i, name := range q.QueueMetrics {
+			names[i] = regexp.QuoteMeta(name)
+		}
+		load.InFlightRequests, err = p.query(ctx, fmt.Sprintf(`sum({__name__=~%q,namespace=%q,pod=~%q})`,
+			strings.Join(names, "|"), q.Namespace, pods))
+		if err != nil {
+			return load, err
+		}
+	}
+	return load, nil
+}
+
+// query runs an instant query whose result is a single number, returning NaN
+// if there are no series to aggregate.
+func (p *PrometheusLoadSource) query(ctx context.Context, promql string) (float64, error) {
+	u := strings.TrimSuffix(p.URL, "/") + "/api/v1/query?" + url.Values{"query": {promql}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return math.NaN(), err
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return math.NaN(), err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			Result []struct {
+				// Value is a [timestamp, "value"] pair.
+				Value [2]any `json:"value"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return math.NaN(), fmt.Errorf("failed to decode response to %s: %w", promql, err)
+	}
+	if body.Status != "success" {
+		return math.NaN(), fmt.Errorf("query %s failed: %s", promql, body.Error)
+	}
+	if len(body.Data.Result) == 0 {
+		return math.NaN(), nil
+	}
+	s, _ := body.Data.Result[0].Value[1].(string)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN(), fmt.Errorf("query %s returned %q: %w", promql, s, err)
+	}
+	return v, nil
+}
+
+// FakeLoadSource returns a canned load, for tests.
+type FakeLoadSource struct {
+	Value Load
+	Err   error
+	// Queries records the queries made.
+	Queries []LoadQuery
+}
+
+// Load implements LoadSource.
+func (f *FakeLoadSource) Load(ctx context.Context, q LoadQuery) (Load, error) {
+	f.Queries = append(f.Queries, q)
+	return f.Value, f.Err
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheusLoadSource(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/query", r.URL.Path)
+		q := r.URL.Query().Get("query")
+		queries = append(queries, q)
+		switch {
+		case strings.Contains(q, "flexinfer_replica_tokens_per_second"):
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1700000000,"412.5"]}]}}`)
+		case strings.Contains(q, "vllm:"):
+			// No pod has been scraped yet.
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status":"error","errorType":"bad_data","error":"parse error"}`)
+		}
+	}))
+	defer srv.Close()
+
+	p := &PrometheusLoadSource{URL: srv.URL + "/"}
+	load, err := p.Load(context.Background(), LoadQuery{
+		Namespace: "default", Deployment: "llama",
+		QueueMetrics: []string{"vllm:num_requests_running", "vllm:num_requests_waiting"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 412.5, load.TokensPerSecond)
+	assert.True(t, math.IsNaN(load.InFlightRequests), "no series is unknown, not zero")
+	assert.Equal(t, []string{
+		`sum(flexinfer_replica_tokens_per_second{namespace="default",modeldeployment="llama",pod=~"llama-[a-z0-9]+-[a-z0-9]+"})`,
+		`sum({__name__=~"vllm:num_requests_running|vllm:num_requests_waiting",namespace="default",pod=~"llama-[a-z0-9]+-[a-z0-9]+"})`,
+	}, queries)
+
+	// Backends without queue gauges are not asked about.
+	queries = nil
+	load, err = p.Load(context.Background(), LoadQuery{Namespace: "default", Deployment: "chat"})
+	require.NoError(t, err)
+	assert.Len(t, queries, 1)
+	assert.True(t, math.IsNaN(load.InFlightRequests))
+
+	_, err = p.Load(context.Background(), LoadQuery{Namespace: "default", Deployment: "x", QueueMetrics: []string{"tgi_queue_size"}})
+	assert.ErrorContains(t, err, "parse error")
+}
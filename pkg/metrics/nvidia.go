@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+)
+
+// DCGMCollector scrapes an NVIDIA DCGM exporter, which reads NVML on our
+// behalf.
+type DCGMCollector struct {
+	// URL is the exporter's metrics endpoint, e.g. http://localhost:9400/metrics.
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Collector.
+func (c *DCGMCollector) Name() string { return "dcgm" }
+
+// Collect implements Collector.
+func (c *DCGMCollector) Collect(ctx context.Context) ([]GPUStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", c.URL, resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", c.URL, err)
+	}
+
+	const mib = 1 << 20
+	byGPU := map[string]*GPUStats{}
+	var order []string
+	set := func(family string, apply func(s *GPUStats, v float64)) {
+		f, ok := families[family]
+		if !ok {
+			return
+		}
+		for _, m := range f.GetMetric() {
+			gpu := labelValue(m, "gpu")
+			s, ok := byGPU[gpu]
+			if !ok {
+				stats := NewGPUStats(gpu)
+				s = &stats
+				byGPU[gpu] = s
+				order = append(order, gpu)
+			}
+			apply(s, m.GetGauge().GetValue())
+		}
+	}
+	set("DCGM_FI_DEV_GPU_TEMP", func(s *GPUStats, v float64) { s.TemperatureCelsius = v })
+	set("DCGM_FI_DEV_GPU_UTIL", func(s *GPUStats, v float64) { s.UtilizationPercent = v })
+	set("DCGM_FI_DEV_FB_USED", func(s *GPUStats, v float64) { s.MemoryUsedBytes = v * mib })
+	// Framebuffer memory is reported as used, free and reserved by the driver.
+	set("DCGM_FI_DEV_FB_FREE", func(s *GPUStats, v float64) { s.MemoryTotalBytes = v * mib })
+	set("DCGM_FI_DEV_FB_RESERVED", func(s *GPUStats, v float64) { s.MemoryTotalBytes += v * mib })
+	set("DCGM_FI_DEV_POWER_USAGE", func(s *GPUStats, v float64) { s.PowerWatts = v })
+	set("DCGM_FI_DEV_SM_CLOCK", func(s *GPUStats, v float64) { s.ClockMHz = v })
+
+	stats := make([]GPUStats, 0, len(order))
+	for _, gpu := range order {
+		s := byGPU[gpu]
+		s.MemoryTotalBytes += s.MemoryUsedBytes
+		stats = append(stats, *s)
+	}
+	return stats, nil
+}
+
+// labelValue returns the value of a metric's label, or "".
+func labelValue(m *dto.Metric, name string) string {
+	for _, l := range m.GetLabel() {
+		if l.GetName() == name {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// NvidiaSMICollector queries nvidia-smi, for nodes without a DCGM exporter.
+type NvidiaSMICollector struct {
+	Run hwprobe.Runner
+}
+
+// Name implements Collector.
+func (c *NvidiaSMICollector) Name() string { return "nvidia-smi" }
+
+// Collect implements Collector.
+func (c *NvidiaSMICollector) Collect(ctx context.Context) ([]GPUStats, error) {
+	out, err := c.Run(ctx, "nvidia-smi",
+		"--query-gpu=index,temperature.gpu,utilization.gpu,memory.used,memory.total,power.draw,clocks.sm",
+		"--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	const mib = 1 << 20
+	var stats []GPUStats
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 7 {
+			continue
+		}
+		s := NewGPUStats(strings.TrimSpace(fields[0]))
+		s.TemperatureCelsius = parseFloat(fields[1])
+		s.UtilizationPercent = parseFloat(fields[2])
+		s.MemoryUsedBytes = parseFloat(fields[3]) * mib
+		s.MemoryTotalBytes = parseFloat(fields[4]) * mib
+		s.PowerWatts = parseFloat(fields[5])
+		s.ClockMHz = parseFloat(fields[6])
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// ScrapeGauges reads the named gauges from a Prometheus metrics endpoint,
+// such as a backend pod's /metrics, summing each over its series. Gauges the
+// endpoint does not export are left out of the result.
+func ScrapeGauges(ctx context.Context, client *http.Client, url string, names ...string) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", url, err)
+	}
+	values := map[string]float64{}
+	for _, name := range names {
+		f, ok := families[name]
+		if !ok {
+			continue
+		}
+		var sum float64
+		for _, m := range f.GetMetric() {
+			// Exporters that leave out # TYPE lines produce untyped series.
+			sum += m.GetGauge().GetValue() + m.GetUntyped().GetValue()
+		}
+		values[name] = sum
+	}
+	return values, nil
+}
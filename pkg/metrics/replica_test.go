@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeGauges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `# HELP vllm:num_requests_running Number of requests currently running on GPU.
+# TYPE vllm:num_requests_running gauge
+vllm:num_requests_running{model_name="meta-llama/Llama-3-8B"} 3.0
+# HELP vllm:num_requests_waiting Number of requests waiting to be processed.
+# TYPE vllm:num_requests_waiting gauge
+vllm:num_requests_waiting{model_name="meta-llama/Llama-3-8B"} 1.0
+vllm:num_requests_waiting{model_name="lora-adapter"} 2.0
+# HELP vllm:gpu_cache_usage_perc GPU KV-cache usage. 1 means 100 percent usage.
+# TYPE vllm:gpu_cache_usage_perc gauge
+vllm:gpu_cache_usage_perc{model_name="meta-llama/Llama-3-8B"} 0.42
+`)
+	}))
+	defer srv.Close()
+
+	values, err := ScrapeGauges(context.Background(), nil, srv.URL,
+		"vllm:num_requests_running", "vllm:num_requests_waiting", "vllm:gpu_cache_usage_perc", "tgi_queue_size")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{
+		"vllm:num_requests_running": 3,
+		"vllm:num_requests_waiting": 3,
+		"vllm:gpu_cache_usage_perc": 0.42,
+	}, values)
+
+	_, err = ScrapeGauges(context.Background(), nil, srv.URL+"/missing\x7f")
+	assert.Error(t, err)
+}
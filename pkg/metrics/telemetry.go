@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GPUStats is one sample of a GPU's telemetry. Fields a collector cannot
+// read are NaN and are not exported.
+type GPUStats struct {
+	// GPU identifies the device on the node, e.g. its index "0".
+	GPU string
+	// PCIAddress is the device's PCI address, e.g. 0000:03:00.0, if the
+	// collector knows it.
+	PCIAddress string
+
+	TemperatureCelsius float64
+	UtilizationPercent float64
+	MemoryUsedBytes    float64
+	MemoryTotalBytes   float64
+	PowerWatts         float64
+	ClockMHz           float64
+}
+
+// NewGPUStats returns stats for gpu with every value unknown.
+func NewGPUStats(gpu string) GPUStats {
+	nan := math.NaN()
+	return GPUStats{
+		GPU:                gpu,
+		TemperatureCelsius: nan,
+		UtilizationPercent: nan,
+		MemoryUsedBytes:    nan,
+		MemoryTotalBytes:   nan,
+		PowerWatts:         nan,
+		ClockMHz:           nan,
+	}
+}
+
+// Collector reads the current telemetry of the node's GPUs.
+type Collector interface {
+	// Name identifies the collector in logs.
+	Name() string
+	// Collect returns one sample per GPU.
+	Collect(ctx context.Context) ([]GPUStats, error)
+}
+
+// gpuGauges are the per-GPU gauges filled from GPUStats.
+var gpuGauges = []struct {
+	vec   *prometheus.GaugeVec
+	value func(GPUStats) float64
+}{
+	{GPUTemperature, func(s GPUStats) float64 { return s.TemperatureCelsius }},
+	{GPUUtilization, func(s GPUStats) float64 { return s.UtilizationPercent }},
+	{GPUMemoryUsedBytes, func(s GPUStats) float64 { return s.MemoryUsedBytes }},
+	{GPUMemoryTotalBytes, func(s GPUStats) float64 { return s.MemoryTotalBytes }},
+	{GPUPowerWatts, func(s GPUStats) float64 { return s.PowerWatts }},
+	{GPUClockMHz, func(s GPUStats) float64 { return s.ClockMHz }},
+}
+
+// RecordGPUStats sets the per-GPU gauges for node from stats. Series for GPUs
+// or values no longer reported are removed.
+func RecordGPUStats(node string, stats []GPUStats) {
+	for _, g := range gpuGauges {
+		g.vec.DeletePartialMatch(prometheus.Labels{"node": node})
+		for _, s := range stats {
+			if v := g.value(s); !math.IsNaN(v) {
+				g.vec.WithLabelValues(s.GPU, node).Set(v)
+			}
+		}
+	}
+}
+
+// Chain returns a Collector that uses the first of collectors to report any
+// GPUs, so one agent configuration fits nodes with different tooling.
+func Chain(collectors ...Collector) Collector {
+	return chain(collectors)
+}
+
+type chain []Collector
+
+func (c chain) Name() string {
+	names := make([]string, len(c))
+	for i, col := range c {
+		names[i] = col.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c chain) Collect(ctx context.Context) ([]GPUStats, error) {
+	var errs []error
+	for _, col := range c {
+		stats, err := col.Collect(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", col.Name(), err))
+			continue
+		}
+		if len(stats) > 0 {
+			return stats, nil
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// FakeCollector returns canned stats, for tests.
+type FakeCollector struct {
+	Stats []GPUStats
+	Err   error
+}
+
+// Name implements Collector.
+func (f *FakeCollector) Name() string { return "fake" }
+
+// Collect implements Collector.
+func (f *FakeCollector) Collect(ctx context.Context) ([]GPUStats, error) {
+	return f.Stats, f.Err
+}
+
+// parseFloat parses a tool-reported number, returning NaN for values such as
+// "[N/A]" that are not numbers.
+func parseFloat(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return v
+}
@@ -0,0 +1,163 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+)
+
+const mib = 1 << 20
+
+// testdataRunner serves file from testdata as the output of any command.
+func testdataRunner(t *testing.T, file string) hwprobe.Runner {
+	return func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		b, err := os.ReadFile(filepath.Join("testdata", file))
+		require.NoError(t, err)
+		return b, nil
+	}
+}
+
+func TestDCGMCollector(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", "dcgm.txt"))
+	}))
+	defer srv.Close()
+
+	stats, err := (&DCGMCollector{URL: srv.URL}).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+
+	assert.Equal(t, "0", stats[0].GPU)
+	assert.Equal(t, 61.0, stats[0].TemperatureCelsius)
+	assert.Equal(t, 87.0, stats[0].UtilizationPercent)
+	assert.Equal(t, 16384.0*mib, stats[0].MemoryUsedBytes)
+	assert.Equal(t, 22528.0*mib, stats[0].MemoryTotalBytes)
+	assert.Equal(t, 68.5, stats[0].PowerWatts)
+	assert.Equal(t, 2040.0, stats[0].ClockMHz)
+	assert.Equal(t, "1", stats[1].GPU)
+	assert.Equal(t, 22528.0*mib, stats[1].MemoryTotalBytes)
+}
+
+func TestDCGMCollectorError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := (&DCGMCollector{URL: srv.URL}).Collect(context.Background())
+	assert.Error(t, err)
+}
+
+func TestNvidiaSMICollector(t *testing.T) {
+	stats, err := (&NvidiaSMICollector{Run: testdataRunner(t, "nvidia-smi.csv")}).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+
+	assert.Equal(t, "0", stats[0].GPU)
+	assert.Equal(t, 61.0, stats[0].TemperatureCelsius)
+	assert.Equal(t, 87.0, stats[0].UtilizationPercent)
+	assert.Equal(t, 16384.0*mib, stats[0].MemoryUsedBytes)
+	assert.Equal(t, 23034.0*mib, stats[0].MemoryTotalBytes)
+	assert.Equal(t, 68.51, stats[0].PowerWatts)
+	assert.Equal(t, 2040.0, stats[0].ClockMHz)
+	assert.True(t, math.IsNaN(stats[1].PowerWatts), "[N/A] is unknown")
+}
+
+func TestROCmSMICollector(t *testing.T) {
+	stats, err := (&ROCmSMICollector{Run: testdataRunner(t, "rocm-smi.json")}).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 2)
+
+	assert.Equal(t, "0", stats[0].GPU)
+	assert.Equal(t, "0000:C1:00.0", stats[0].PCIAddress)
+	assert.Equal(t, 45.0, stats[0].TemperatureCelsius)
+	assert.Equal(t, 93.0, stats[0].UtilizationPercent)
+	assert.Equal(t, 34351349760.0, stats[0].MemoryUsedBytes)
+	assert.Equal(t, 68702699520.0, stats[0].MemoryTotalBytes)
+	assert.Equal(t, 287.0, stats[0].PowerWatts)
+	assert.Equal(t, 1700.0, stats[0].ClockMHz)
+	assert.Equal(t, "1", stats[1].GPU)
+	assert.Equal(t, 95.0, stats[1].PowerWatts)
+}
+
+func TestHwmonCollector(t *testing.T) {
+	root := t.TempDir()
+	// As in sysfs, each card's device links to its PCI device. card0 is the
+	// BMC's display adapter.
+	for card, addr := range map[string]string{"card0": "0000:02:00.0", "card1": "0000:03:00.0"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "sys/devices/pci0000:00", addr), 0o755))
+		require.NoError(t, os.MkdirAll(filepath.Join(root, "sys/class/drm", card), 0o755))
+		require.NoError(t, os.Symlink(filepath.Join("../../../devices/pci0000:00", addr), filepath.Join(root, "sys/class/drm", card, "device")))
+	}
+	files := map[string]string{
+		"sys/class/drm/card1/device/gpu_busy_percent":            "42",
+		"sys/class/drm/card1/device/mem_info_vram_used":          "1073741824",
+		"sys/class/drm/card1/device/mem_info_vram_total":         "17163091968",
+		"sys/class/drm/card1/device/hwmon/hwmon3/temp1_input":    "51000",
+		"sys/class/drm/card1/device/hwmon/hwmon3/power1_average": "120000000",
+		"sys/class/drm/card1/device/hwmon/hwmon3/freq1_input":    "2100000000",
+		"sys/class/drm/card1-DP-1/status":                        "connected",
+		// A display adapter without hwmon is skipped.
+		"sys/class/drm/card0/device/vendor": "0x1a03",
+	}
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents+"\n"), 0o644))
+	}
+
+	stats, err := (&HwmonCollector{Root: root}).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+
+	assert.Equal(t, "1", stats[0].GPU)
+	assert.Equal(t, "0000:03:00.0", stats[0].PCIAddress)
+	assert.Equal(t, 51.0, stats[0].TemperatureCelsius)
+	assert.Equal(t, 42.0, stats[0].UtilizationPercent)
+	assert.Equal(t, 1073741824.0, stats[0].MemoryUsedBytes)
+	assert.Equal(t, 17163091968.0, stats[0].MemoryTotalBytes)
+	assert.Equal(t, 120.0, stats[0].PowerWatts)
+	assert.Equal(t, 2100.0, stats[0].ClockMHz)
+}
+
+func TestChain(t *testing.T) {
+	gpu := NewGPUStats("0")
+	gpu.TemperatureCelsius = 60
+
+	stats, err := Chain(
+		&FakeCollector{Err: errors.New("connection refused")},
+		&FakeCollector{},
+		&FakeCollector{Stats: []GPUStats{gpu}},
+	).Collect(context.Background())
+	require.NoError(t, err)
+	require.Len(t, stats, 1)
+	assert.Equal(t, 60.0, stats[0].TemperatureCelsius)
+
+	_, err = Chain(&FakeCollector{Err: errors.New("connection refused")}).Collect(context.Background())
+	assert.ErrorContains(t, err, "fake: connection refused")
+}
+
+func TestRecordGPUStats(t *testing.T) {
+	a, b := NewGPUStats("0"), NewGPUStats("1")
+	a.TemperatureCelsius, a.UtilizationPercent = 61, 87
+	b.TemperatureCelsius = 55
+
+	RecordGPUStats("node-a", []GPUStats{a, b})
+	assert.Equal(t, 61.0, testutil.ToFloat64(GPUTemperature.WithLabelValues("0", "node-a")))
+	assert.Equal(t, 87.0, testutil.ToFloat64(GPUUtilization.WithLabelValues("0", "node-a")))
+	assert.Equal(t, 2, testutil.CollectAndCount(GPUTemperature))
+	assert.Equal(t, 1, testutil.CollectAndCount(GPUUtilization), "unknown values are not exported")
+
+	// GPU 1 disappears.
+	RecordGPUStats("node-a", []GPUStats{a})
+	assert.Equal(t, 1, testutil.CollectAndCount(GPUTemperature))
+}
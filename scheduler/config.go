@@ -0,0 +1,106 @@
+package scheduler
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	configv1 "k8s.io/kube-scheduler/config/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// SchedulerName is the scheduler profile that consults flexinfer-sched.
+// Model pods opt in with spec.schedulerName.
+const SchedulerName = "flexinfer-scheduler"
+
+// PluginName is the name flexinfer registers its scheduling-framework plugin
+// under.
+const PluginName = "FlexInfer"
+
+// Extender verbs, the paths flexinfer-sched serves them on.
+const (
+	FilterVerb     = "filter"
+	PrioritizeVerb = "prioritize"
+	PreemptVerb    = "preempt"
+)
+
+// KubeSchedulerConfiguration returns a kube-scheduler configuration with a
+// SchedulerName profile that calls the extender at urlPrefix. With
+// nodeCacheCapable the scheduler sends node names and the extender reads the
+// nodes from its own cache; without it every request carries the full Node
+// objects.
+func KubeSchedulerConfiguration(urlPrefix string, nodeCacheCapable bool) *configv1.KubeSchedulerConfiguration {
+	name := SchedulerName
+	return &configv1.KubeSchedulerConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: configv1.SchemeGroupVersion.String(),
+			Kind:       "KubeSchedulerConfiguration",
+		},
+		Profiles: []configv1.KubeSchedulerProfile{{SchedulerName: &name}},
+		Extenders: []configv1.Extender{{
+			URLPrefix:        urlPrefix,
+			FilterVerb:       FilterVerb,
+			PrioritizeVerb:   PrioritizeVerb,
+			PreemptVerb:      PreemptVerb,
+			Weight:           1,
+			HTTPTimeout:      metav1.Duration{Duration: 5 * time.Second},
+			NodeCacheCapable: nodeCacheCapable,
+		}},
+	}
+}
+
+// KubeSchedulerPluginConfiguration returns a kube-scheduler configuration
+// with a SchedulerName profile that runs the in-process PluginName plugin at
+// each extension point it implements, for flexinfer-sched --mode=plugin.
+func KubeSchedulerPluginConfiguration() *configv1.KubeSchedulerConfiguration {
+	name := SchedulerName
+	enabled := configv1.PluginSet{Enabled: []configv1.Plugin{{Name: PluginName}}}
+	return &configv1.KubeSchedulerConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: configv1.SchemeGroupVersion.String(),
+			Kind:       "KubeSchedulerConfiguration",
+		},
+		Profiles: []configv1.KubeSchedulerProfile{{
+			SchedulerName: &name,
+			Plugins: &configv1.Plugins{
+				Filter:   enabled,
+				PreScore: enabled,
+				Score:    enabled,
+				Reserve:  enabled,
+			},
+		}},
+	}
+}
+
+// KubeSchedulerConfigurationYAML renders KubeSchedulerConfiguration as YAML.
+func KubeSchedulerConfigurationYAML(urlPrefix string, nodeCacheCapable bool) ([]byte, error) {
+	return configurationYAML(KubeSchedulerConfiguration(urlPrefix, nodeCacheCapable))
+}
+
+// KubeSchedulerPluginConfigurationYAML renders
+// KubeSchedulerPluginConfiguration as YAML.
+func KubeSchedulerPluginConfigurationYAML() ([]byte, error) {
+	return configurationYAML(KubeSchedulerPluginConfiguration())
+}
+
+func configurationYAML(kubeSchedulerConfig *configv1.KubeSchedulerConfiguration) ([]byte, error) {
+	config, err := runtime.DefaultUnstructuredConverter.ToUnstructured(kubeSchedulerConfig)
+	if err != nil {
+		return nil, err
+	}
+	// These structs are not pointers, so they would be written out zeroed;
+	// leave them to kube-scheduler's defaults instead.
+	delete(config, "clientConnection")
+	delete(config, "leaderElection")
+	// So would the extension points a profile leaves alone.
+	profiles, _ := config["profiles"].([]interface{})
+	for _, profile := range profiles {
+		plugins, _ := profile.(map[string]interface{})["plugins"].(map[string]interface{})
+		for point, set := range plugins {
+			if set, ok := set.(map[string]interface{}); ok && len(set) == 0 {
+				delete(plugins, point)
+			}
+		}
+	}
+	return yaml.Marshal(config)
+}
@@ -0,0 +1,52 @@
+package scheduler
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	configv1 "k8s.io/kube-scheduler/config/v1"
+)
+
+func TestSampleKubeSchedulerConfigurationUpToDate(t *testing.T) {
+	want, err := KubeSchedulerConfigurationYAML("http://flexinfer-sched.flexinfer-system.svc:8888", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../config/scheduler/kube-scheduler-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("config/scheduler/kube-scheduler-config.yaml is stale; run make scheduler-config\n%s", want)
+	}
+}
+
+func TestSamplePluginConfigurationUpToDate(t *testing.T) {
+	want, err := KubeSchedulerPluginConfigurationYAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("../config/scheduler/kube-scheduler-plugin-config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("config/scheduler/kube-scheduler-plugin-config.yaml is stale; run make scheduler-config\n%s", want)
+	}
+}
+
+func TestKubeSchedulerPluginConfiguration(t *testing.T) {
+	config := KubeSchedulerPluginConfiguration()
+	if len(config.Extenders) != 0 {
+		t.Errorf("expected no extenders, got %+v", config.Extenders)
+	}
+	plugins := config.Profiles[0].Plugins
+	for point, set := range map[string]configv1.PluginSet{
+		"filter": plugins.Filter, "preScore": plugins.PreScore, "score": plugins.Score, "reserve": plugins.Reserve,
+	} {
+		if len(set.Enabled) != 1 || set.Enabled[0].Name != PluginName {
+			t.Errorf("expected %s to be enabled at %s, got %+v", PluginName, point, set)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/internal/cache"
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+)
+
+// vramOverhead covers the KV cache, activations and runtime buffers on top
+// of the weights themselves.
+const vramOverhead = 1.2
+
+// gpuResources are the extended resources device plugins advertise GPUs as.
+var gpuResources = []corev1.ResourceName{"nvidia.com/gpu", "amd.com/gpu", "gpu.intel.com/i915"}
+
+// migSlicesPerGPU is the number of compute slices MIG divides a GPU into.
+const migSlicesPerGPU = 7
+
+// Labels NVIDIA's GPU feature discovery puts on nodes.
+const (
+	labelMIGCapable = "nvidia.com/mig.capable"
+	// labelGPUReplicas is the number of time-slicing replicas each GPU is
+	// advertised as.
+	labelGPUReplicas = "nvidia.com/gpu.replicas"
+)
+
+// requirements is what a model needs from a node.
+type requirements struct {
+	// vramBytes is the memory needed across all GPUs. Zero if unknown.
+	vramBytes int64
+	// int4 is set for models that run INT4 tensor kernels.
+	int4   bool
+	vendor string
+	archs  []string
+	gpus   int
+	// maxCost is the highest hourly node cost the model allows. Zero if
+	// unlimited.
+	maxCost float64
+	// migSlices is the number of MIG compute slices, in sevenths of a GPU,
+	// the model asks for in its resource limits, and migVRAMBytes their
+	// memory. Zero for models on whole or time-sliced GPUs.
+	migSlices    int
+	migVRAMBytes int64
+	// tensorParallel is the number of GPUs the model is sharded across, or
+	// zero if it is not.
+	tensorParallel int
+}
+
+var (
+	// moeParams matches mixture-of-experts sizes such as 8x7b.
+	moeParams = regexp.MustCompile(`(?i)(?:^|[^a-z0-9.])(\d+)x(\d+(?:\.\d+)?)b(?:$|[^a-z0-9])`)
+	// params matches parameter counts such as 8b or 0.5B.
+	params = regexp.MustCompile(`(?i)(?:^|[^a-z0-9.])(\d+(?:\.\d+)?)b(?:$|[^a-z0-9])`)
+	// ggufQuant matches llama.cpp quantization types such as q4_K_M or q8_0.
+	ggufQuant = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])i?q(\d)(?:_|$|[^a-z0-9])`)
+	// int4Quant matches GPU INT4 weight formats.
+	int4Quant = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(awq|gptq|int4|w4a16)(?:$|[^a-z0-9])`)
+	// int8Quant matches 8-bit weight formats.
+	int8Quant = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(int8|fp8|w8a8)(?:$|[^a-z0-9])`)
+	// migResource matches the resources the device plugin's mixed MIG
+	// strategy advertises slices as, such as nvidia.com/mig-1g.10gb.
+	migResource = regexp.MustCompile(`^nvidia\.com/mig-(\d+)g\.(\d+)gb$`)
+)
+
+// requirementsFor returns what md needs, estimating whatever its
+// Spec.Requirements leave out from the model name and backend.
+func requirementsFor(md *aiv1alpha1.ModelDeployment) requirements {
+	spec := md.Spec.Requirements
+	if spec == nil {
+		spec = &aiv1alpha1.ModelRequirements{}
+	}
+	req := requirements{vendor: spec.GPUVendor, archs: spec.GPUArchitectures, gpus: 1}
+
+	if tp := md.Spec.TensorParallelSize; tp != nil && *tp > 1 {
+		req.tensorParallel = int(*tp)
+	}
+	if spec.GPUCount != nil {
+		req.gpus = int(*spec.GPUCount)
+	} else if req.tensorParallel > 0 {
+		req.gpus = req.tensorParallel
+	} else {
+		for _, name := range gpuResources {
+			if q, ok := md.Spec.Resources.Limits[name]; ok && q.Value() > 0 {
+				req.gpus = int(q.Value())
+			}
+		}
+	}
+	for name, q := range md.Spec.Resources.Limits {
+		if m := migResource.FindStringSubmatch(string(name)); m != nil {
+			slices, _ := strconv.Atoi(m[1])
+			gb, _ := strconv.ParseInt(m[2], 10, 64)
+			req.migSlices += slices * int(q.Value())
+			req.migVRAMBytes += gb << 30 * q.Value()
+		}
+	}
+
+	quantization, bits := spec.Quantization, 0
+	switch quantization {
+	case aiv1alpha1.QuantizationINT4:
+		bits = 4
+	case aiv1alpha1.QuantizationINT8:
+		bits = 8
+	case aiv1alpha1.QuantizationFP16:
+		bits = 16
+	default:
+		quantization, bits = inferQuantization(md.Spec.Model, md.Spec.Backend)
+	}
+	req.int4 = quantization == aiv1alpha1.QuantizationINT4
+
+	if spec.VRAM != nil {
+		req.vramBytes = spec.VRAM.Value()
+	} else if n := parameterCount(md.Spec.Model); n > 0 {
+		req.vramBytes = int64(n * float64(bits) / 8 * vramOverhead)
+	}
+	return req
+}
+
+// inferQuantization guesses the weight precision of model from its name. It
+// returns QuantizationINT4 only for formats that need INT4 tensor support;
+// llama.cpp's 4-bit types run anywhere. Unmarked models are assumed to be the
+// backend's usual format: 4-bit GGUF for ollama and llama.cpp, fp16 otherwise.
+func inferQuantization(model, backend string) (string, int) {
+	if int4Quant.MatchString(model) {
+		return aiv1alpha1.QuantizationINT4, 4
+	}
+	if int8Quant.MatchString(model) {
+		return aiv1alpha1.QuantizationINT8, 8
+	}
+	if m := ggufQuant.FindStringSubmatch(model); m != nil {
+		bits, _ := strconv.Atoi(m[1])
+		return "", bits
+	}
+	switch backend {
+	case "ollama", "llamacpp":
+		return "", 4
+	}
+	return aiv1alpha1.QuantizationFP16, 16
+}
+
+// parameterCount returns the number of parameters in the model named model,
+// e.g. 8e9 for llama3:8b, or 0 if the name does not say.
+func parameterCount(model string) float64 {
+	if m := moeParams.FindStringSubmatch(model); m != nil {
+		experts, _ := strconv.ParseFloat(m[1], 64)
+		size, _ := strconv.ParseFloat(m[2], 64)
+		return experts * size * 1e9
+	}
+	if m := params.FindStringSubmatch(model); m != nil {
+		size, _ := strconv.ParseFloat(m[1], 64)
+		return size * 1e9
+	}
+	return 0
+}
+
+// unfitReason returns why node cannot run a model with req, or "" if it can.
+// Requirements the node's labels cannot confirm fail, except for estimated
+// VRAM, which is only checked against nodes that report theirs.
+func unfitReason(node *corev1.Node, req requirements) string {
+	labels := node.Labels
+	vendor := labels["flexinfer.ai/gpu.vendor"]
+	if vendor == "" {
+		return "node has no GPU"
+	}
+	if req.vendor != "" && !strings.EqualFold(req.vendor, vendor) {
+		return fmt.Sprintf("model needs an %s GPU, node has %s", req.vendor, vendor)
+	}
+	if len(req.archs) > 0 {
+		arch := labels["flexinfer.ai/gpu.arch"]
+		if arch == "" {
+			return fmt.Sprintf("model needs GPU architecture %s, node's is unknown", strings.Join(req.archs, " or "))
+		}
+		if !contains(req.archs, arch) {
+			return fmt.Sprintf("model needs GPU architecture %s, node has %s", strings.Join(req.archs, " or "), arch)
+		}
+	}
+
+	count := gpuCount(node)
+	if count < req.gpus {
+		return fmt.Sprintf("model needs %d GPUs, node has %d", req.gpus, count)
+	}
+
+	if req.int4 && labels["flexinfer.ai/gpu.int4"] != "true" {
+		return "model is int4-quantized, node's GPU lacks INT4 support"
+	}
+
+	if req.migSlices > 0 && labels[labelMIGCapable] != "true" {
+		return "model needs MIG slices, node's GPUs are not MIG-capable"
+	}
+
+	if cost, err := strconv.ParseFloat(node.Annotations["flexinfer.ai/cost"], 64); err == nil && req.maxCost > 0 && cost > req.maxCost {
+		return fmt.Sprintf("node costs %g per hour, model allows at most %g", cost, req.maxCost)
+	}
+
+	if vram := gpuVRAM(node); vram > 0 && req.vramBytes > 0 {
+		if available := vram * int64(req.gpus); available < req.vramBytes {
+			return fmt.Sprintf("model needs %s of VRAM on %d GPU(s), node has %s",
+				hwprobe.FormatVRAM(req.vramBytes), req.gpus, hwprobe.FormatVRAM(available))
+		}
+	}
+	return ""
+}
+
+// overcommitReason returns why node has no room left for a model with req
+// next to the model pods already claiming usage of its GPUs, or "" if it
+// has.
+func overcommitReason(node *corev1.Node, req requirements, usage cache.Usage) string {
+	claim := claimFor(node, req)
+	count := gpuCount(node)
+	// Allow for rounding in the sum of fractional claims.
+	if free := float64(count) - usage.GPUs; claim.GPUs > free+1e-6 {
+		return fmt.Sprintf("model needs %s GPU(s), %s of node's %d are free",
+			formatGPUs(claim.GPUs), formatGPUs(math.Max(free, 0)), count)
+	}
+	if vram := gpuVRAM(node); vram > 0 && claim.VRAMBytes > 0 {
+		total := vram * int64(count)
+		if free := total - usage.VRAMBytes; claim.VRAMBytes > free {
+			return fmt.Sprintf("model needs %s of VRAM, %s of node's %s is free",
+				hwprobe.FormatVRAM(claim.VRAMBytes), hwprobe.FormatVRAM(max(free, 0)), hwprobe.FormatVRAM(total))
+		}
+	}
+	return ""
+}
+
+// claimFor returns what a model with req takes of node's GPUs. Models that
+// fit in one GPU's memory share it with others: on MIG they take their
+// slices, on time-sliced GPUs enough replicas to cover their VRAM, and
+// otherwise the share of the GPU's VRAM they use. Larger models, and models
+// of unknown size, take whole GPUs.
+func claimFor(node *corev1.Node, req requirements) cache.Claim {
+	if req.migSlices > 0 {
+		return cache.Claim{VRAMBytes: req.migVRAMBytes, GPUs: float64(req.migSlices) / migSlicesPerGPU}
+	}
+	vram := gpuVRAM(node)
+	if vram == 0 || req.vramBytes == 0 || req.gpus > 1 || req.vramBytes > vram {
+		claim := cache.Claim{VRAMBytes: req.vramBytes, GPUs: float64(req.gpus)}
+		if claim.VRAMBytes == 0 {
+			claim.VRAMBytes = vram * int64(req.gpus)
+		}
+		return claim
+	}
+	share := float64(req.vramBytes) / float64(vram)
+	if replicas, err := strconv.Atoi(node.Labels[labelGPUReplicas]); err == nil && replicas > 1 {
+		share = math.Ceil(share*float64(replicas)) / float64(replicas)
+	}
+	return cache.Claim{VRAMBytes: req.vramBytes, GPUs: share}
+}
+
+// gpuCount returns the number of GPUs the agent found on node, assuming one
+// if it does not say.
+func gpuCount(node *corev1.Node) int {
+	if c, err := strconv.Atoi(node.Labels["flexinfer.ai/gpu.count"]); err == nil {
+		return c
+	}
+	return 1
+}
+
+// gpuVRAM returns the memory of each of node's GPUs, or 0 if unknown.
+func gpuVRAM(node *corev1.Node) int64 {
+	vram, err := resource.ParseQuantity(node.Labels["flexinfer.ai/gpu.vram"])
+	if err != nil {
+		return 0
+	}
+	return vram.Value()
+}
+
+// formatGPUs formats a possibly fractional number of GPUs, e.g. 2 or 0.25.
+func formatGPUs(gpus float64) string {
+	return strconv.FormatFloat(math.Round(gpus*100)/100, 'f', -1, 64)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package scheduler
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/internal/cache"
+)
+
+func TestParameterCount(t *testing.T) {
+	for model, want := range map[string]float64{
+		"llama3:8b":                            8e9,
+		"qwen2.5:0.5b":                         0.5e9,
+		"meta-llama/Meta-Llama-3-70B-Instruct": 70e9,
+		"mixtral:8x7b":                         56e9,
+		"llama3.1:70b-instruct-q4_K_M":         70e9,
+		"phi3":                                 0,
+	} {
+		if got := parameterCount(model); got != want {
+			t.Errorf("parameterCount(%q) = %g, want %g", model, got, want)
+		}
+	}
+}
+
+func TestInferQuantization(t *testing.T) {
+	for _, tc := range []struct {
+		model, backend string
+		quantization   string
+		bits           int
+	}{
+		{"llama3:8b", "ollama", "", 4},
+		{"llama3:8b-instruct-q8_0", "ollama", "", 8},
+		{"TheBloke/Llama-2-13B-GPTQ", "vllm", aiv1alpha1.QuantizationINT4, 4},
+		{"neuralmagic/Meta-Llama-3-8B-Instruct-FP8", "vllm", aiv1alpha1.QuantizationINT8, 8},
+		{"meta-llama/Meta-Llama-3-8B-Instruct", "vllm", aiv1alpha1.QuantizationFP16, 16},
+	} {
+		quantization, bits := inferQuantization(tc.model, tc.backend)
+		if quantization != tc.quantization || bits != tc.bits {
+			t.Errorf("inferQuantization(%q, %q) = %q, %d, want %q, %d",
+				tc.model, tc.backend, quantization, bits, tc.quantization, tc.bits)
+		}
+	}
+}
+
+func TestRequirementsFor(t *testing.T) {
+	vram := resource.MustParse("20Gi")
+	count := int32(2)
+	md := &aiv1alpha1.ModelDeployment{Spec: aiv1alpha1.ModelDeploymentSpec{
+		Backend: "vllm",
+		Model:   "meta-llama/Meta-Llama-3-70B-Instruct",
+		Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+			"nvidia.com/gpu": resource.MustParse("4"),
+		}},
+	}}
+	req := requirementsFor(md)
+	if req.gpus != 4 {
+		t.Errorf("gpus = %d, want the 4 GPUs the resource limits ask for", req.gpus)
+	}
+	if want := int64(70e9 * 2 * vramOverhead); req.vramBytes != want {
+		t.Errorf("vramBytes = %d, want %d", req.vramBytes, want)
+	}
+
+	md.Spec.Requirements = &aiv1alpha1.ModelRequirements{
+		VRAM: &vram, Quantization: aiv1alpha1.QuantizationINT4, GPUCount: &count,
+	}
+	req = requirementsFor(md)
+	if req.gpus != 2 || req.vramBytes != vram.Value() || !req.int4 {
+		t.Errorf("explicit requirements not honored: %+v", req)
+	}
+}
+
+func TestUnfitReason(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"flexinfer.ai/gpu.vendor": "NVIDIA",
+		"flexinfer.ai/gpu.arch":   "sm_90",
+		"flexinfer.ai/gpu.vram":   "80Gi",
+		"flexinfer.ai/gpu.int4":   "false",
+	}, Annotations: map[string]string{"flexinfer.ai/cost": "4.5"}}}
+	for _, tc := range []struct {
+		req  requirements
+		want string
+	}{
+		{requirements{gpus: 1}, ""},
+		{requirements{gpus: 1, archs: []string{"sm_80", "sm_89"}}, "model needs GPU architecture sm_80 or sm_89, node has sm_90"},
+		{requirements{gpus: 2}, "model needs 2 GPUs, node has 1"},
+		{requirements{gpus: 1, int4: true}, "model is int4-quantized, node's GPU lacks INT4 support"},
+		{requirements{gpus: 1, vramBytes: 100 << 30}, "model needs 100Gi of VRAM on 1 GPU(s), node has 80Gi"},
+		{requirements{gpus: 1, maxCost: 5}, ""},
+		{requirements{gpus: 1, maxCost: 2}, "node costs 4.5 per hour, model allows at most 2"},
+	} {
+		if got := unfitReason(node, tc.req); got != tc.want {
+			t.Errorf("unfitReason(%+v) = %q, want %q", tc.req, got, tc.want)
+		}
+	}
+}
+
+func TestClaimFor(t *testing.T) {
+	node := func(labels map[string]string) *corev1.Node {
+		l := map[string]string{"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.vram": "80Gi", "flexinfer.ai/gpu.count": "2"}
+		for k, v := range labels {
+			l[k] = v
+		}
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: l}}
+	}
+	mig := requirementsFor(&aiv1alpha1.ModelDeployment{Spec: aiv1alpha1.ModelDeploymentSpec{
+		Backend: "vllm", Model: "Qwen/Qwen2-1.5B",
+		Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{"nvidia.com/mig-2g.20gb": resource.MustParse("1")}},
+	}})
+	for _, tc := range []struct {
+		name string
+		node *corev1.Node
+		req  requirements
+		want cache.Claim
+	}{
+		{"shares a GPU by VRAM", node(nil), requirements{gpus: 1, vramBytes: 20 << 30}, cache.Claim{VRAMBytes: 20 << 30, GPUs: 0.25}},
+		{"rounds up to time-slicing replicas", node(map[string]string{"nvidia.com/gpu.replicas": "3"}),
+			requirements{gpus: 1, vramBytes: 20 << 30}, cache.Claim{VRAMBytes: 20 << 30, GPUs: 1.0 / 3}},
+		{"takes its MIG slices", node(map[string]string{"nvidia.com/mig.capable": "true"}), mig, cache.Claim{VRAMBytes: 20 << 30, GPUs: 2.0 / 7}},
+		{"takes whole GPUs when split", node(nil), requirements{gpus: 2, vramBytes: 100 << 30}, cache.Claim{VRAMBytes: 100 << 30, GPUs: 2}},
+		{"takes whole GPUs when size is unknown", node(nil), requirements{gpus: 1}, cache.Claim{VRAMBytes: 80 << 30, GPUs: 1}},
+	} {
+		if got := claimFor(tc.node, tc.req); got != tc.want {
+			t.Errorf("%s: claimFor = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+
+	if got := unfitReason(node(nil), mig); got != "model needs MIG slices, node's GPUs are not MIG-capable" {
+		t.Errorf("unexpected reason for MIG on a non-MIG node: %q", got)
+	}
+}
+
+func TestOvercommitReason(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{
+		"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.vram": "24Gi", "flexinfer.ai/gpu.count": "2",
+	}}}
+	small := requirements{gpus: 1, vramBytes: 6 << 30}
+	for _, tc := range []struct {
+		req   requirements
+		usage cache.Usage
+		want  string
+	}{
+		{small, cache.Usage{}, ""},
+		// Three small models already share the GPUs; a fourth still fits.
+		{small, cache.Usage{VRAMBytes: 18 << 30, GPUs: 0.75, Pods: 3}, ""},
+		{small, cache.Usage{VRAMBytes: 44 << 30, GPUs: 1.5, Pods: 2}, "model needs 6Gi of VRAM, 4Gi of node's 48Gi is free"},
+		{requirements{gpus: 2, vramBytes: 30 << 30}, cache.Usage{VRAMBytes: 6 << 30, GPUs: 0.25, Pods: 1}, "model needs 2 GPU(s), 1.75 of node's 2 are free"},
+	} {
+		if got := overcommitReason(node, tc.req, tc.usage); got != tc.want {
+			t.Errorf("overcommitReason(%+v, %+v) = %q, want %q", tc.req, tc.usage, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Package plugin packages the flexinfer scheduler as a kube-scheduler
+// scheduling-framework plugin, an in-process alternative to the HTTP
+// extender.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/flexinfer/flexinfer/scheduler"
+)
+
+// Name is the name the plugin is registered and configured under.
+const Name = scheduler.PluginName
+
+// factorsKey is where PreScore leaves each node's factors for NormalizeScore.
+const factorsKey framework.StateKey = Name + "/factors"
+
+// factors holds the raw scoring factors of each candidate node, by name.
+// Score runs on many nodes in parallel, so it is written once in PreScore and
+// only read afterwards.
+type factors map[string]*scheduler.NodeScore
+
+// Clone implements framework.StateData. The factors are never modified after
+// PreScore, so the copy can share them.
+func (f factors) Clone() framework.StateData {
+	return f
+}
+
+// Plugin filters, scores and reserves nodes for model pods.
+type Plugin struct {
+	eval scheduler.Evaluator
+}
+
+var (
+	_ framework.FilterPlugin    = &Plugin{}
+	_ framework.PreScorePlugin  = &Plugin{}
+	_ framework.ScorePlugin     = &Plugin{}
+	_ framework.ScoreExtensions = &Plugin{}
+	_ framework.ReservePlugin   = &Plugin{}
+)
+
+// New creates the plugin. It takes no arguments; ModelDeployments and
+// benchmark results are watched through the scheduler's kubeconfig.
+func New(_ runtime.Object, h framework.Handle) (framework.Plugin, error) {
+	sched, err := scheduler.NewSchedulerForConfig(h.KubeConfig())
+	if err != nil {
+		return nil, err
+	}
+	return &Plugin{eval: sched}, nil
+}
+
+// Name implements framework.Plugin.
+func (p *Plugin) Name() string {
+	return Name
+}
+
+// Filter rejects nodes that cannot fit the pod's model. Evicting pods does
+// not change a node's hardware, so they are unresolvable by preemption.
+func (p *Plugin) Filter(ctx context.Context, _ *framework.CycleState, pod *corev1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+	if reason := p.eval.Fit(ctx, pod, node); reason != "" {
+		return framework.NewStatus(framework.UnschedulableAndUnresolvable, reason)
+	}
+	return nil
+}
+
+// PreScore reads the scoring factors of every node that passed filtering.
+func (p *Plugin) PreScore(_ context.Context, state *framework.CycleState, pod *corev1.Pod, nodes []*corev1.Node) *framework.Status {
+	f := make(factors, len(nodes))
+	for _, node := range nodes {
+		n := p.eval.Factors(pod, node)
+		f[node.Name] = &n
+	}
+	state.Write(factorsKey, f)
+	return nil
+}
+
+// Score only checks that PreScore saw the node. The factors are min/max-scaled
+// across all nodes, so the real scores are computed in NormalizeScore.
+func (p *Plugin) Score(_ context.Context, state *framework.CycleState, _ *corev1.Pod, nodeName string) (int64, *framework.Status) {
+	f, err := readFactors(state)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	if _, ok := f[nodeName]; !ok {
+		return 0, framework.NewStatus(framework.Error, fmt.Sprintf("node %s was not prescored", nodeName))
+	}
+	return 0, nil
+}
+
+// ScoreExtensions implements framework.ScorePlugin.
+func (p *Plugin) ScoreExtensions() framework.ScoreExtensions {
+	return p
+}
+
+// NormalizeScore weights the scaled factors into each node's score.
+// scheduler.MaxScore is the framework's MaxNodeScore, so they need no
+// further scaling.
+func (p *Plugin) NormalizeScore(_ context.Context, state *framework.CycleState, pod *corev1.Pod, scores framework.NodeScoreList) *framework.Status {
+	f, err := readFactors(state)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	nodes := make([]*scheduler.NodeScore, 0, len(scores))
+	for _, s := range scores {
+		if n, ok := f[s.Name]; ok {
+			nodes = append(nodes, n)
+		}
+	}
+	p.eval.Normalize(pod, nodes)
+	for i := range scores {
+		if n, ok := f[scores[i].Name]; ok {
+			scores[i].Score = int64(math.Round(n.Score))
+		}
+	}
+	return nil
+}
+
+// Reserve fails if the node no longer fits the pod, sending it back to the
+// queue to be scheduled again.
+func (p *Plugin) Reserve(ctx context.Context, _ *framework.CycleState, pod *corev1.Pod, nodeName string) *framework.Status {
+	if err := p.eval.Reserve(ctx, pod, nodeName); err != nil {
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+	return nil
+}
+
+// Unreserve releases the GPUs Reserve claimed when the pod could not be
+// bound.
+func (p *Plugin) Unreserve(_ context.Context, _ *framework.CycleState, pod *corev1.Pod, _ string) {
+	p.eval.Unreserve(pod)
+}
+
+func readFactors(state *framework.CycleState) (factors, error) {
+	data, err := state.Read(factorsKey)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q from cycle state: %w", factorsKey, err)
+	}
+	f, ok := data.(factors)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %T in cycle state %q", data, factorsKey)
+	}
+	return f, nil
+}
@@ -0,0 +1,132 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/flexinfer/flexinfer/scheduler"
+)
+
+// fakeEvaluator rejects nodes named in unfit, reads each node's tokens/s
+// from its "tps" label and scores a node by the share of the best one.
+type fakeEvaluator struct {
+	unfit      map[string]string
+	tps        map[string]float64
+	unreserved []string
+}
+
+func (f *fakeEvaluator) Fit(_ context.Context, _ *corev1.Pod, node *corev1.Node) string {
+	return f.unfit[node.Name]
+}
+
+func (f *fakeEvaluator) Factors(_ *corev1.Pod, node *corev1.Node) scheduler.NodeScore {
+	return scheduler.NodeScore{Node: node.Name, TokensPerSecond: f.tps[node.Name]}
+}
+
+func (f *fakeEvaluator) Normalize(_ *corev1.Pod, scores []*scheduler.NodeScore) {
+	best := 0.0
+	for _, n := range scores {
+		if n.TokensPerSecond > best {
+			best = n.TokensPerSecond
+		}
+	}
+	for _, n := range scores {
+		n.Score = n.TokensPerSecond / best * scheduler.MaxScore
+	}
+}
+
+func (f *fakeEvaluator) Reserve(_ context.Context, _ *corev1.Pod, nodeName string) error {
+	if reason := f.unfit[nodeName]; reason != "" {
+		return errors.New(reason)
+	}
+	return nil
+}
+
+func (f *fakeEvaluator) Unreserve(pod *corev1.Pod) {
+	f.unreserved = append(f.unreserved, pod.Name)
+}
+
+func node(name string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func TestFilter(t *testing.T) {
+	p := &Plugin{eval: &fakeEvaluator{unfit: map[string]string{"cpu": "node has no GPU"}}}
+	pod := &corev1.Pod{}
+
+	info := framework.NewNodeInfo()
+	info.SetNode(node("gpu"))
+	if status := p.Filter(context.Background(), framework.NewCycleState(), pod, info); !status.IsSuccess() {
+		t.Errorf("expected gpu to pass, got %v", status)
+	}
+
+	info = framework.NewNodeInfo()
+	info.SetNode(node("cpu"))
+	status := p.Filter(context.Background(), framework.NewCycleState(), pod, info)
+	if status.Code() != framework.UnschedulableAndUnresolvable || status.Message() != "node has no GPU" {
+		t.Errorf("expected cpu to be unresolvable, got %v", status)
+	}
+
+	if status := p.Filter(context.Background(), framework.NewCycleState(), pod, framework.NewNodeInfo()); status.Code() != framework.Error {
+		t.Errorf("expected an error without a node, got %v", status)
+	}
+}
+
+func TestScore(t *testing.T) {
+	p := &Plugin{eval: &fakeEvaluator{tps: map[string]float64{"fast": 200, "slow": 50}}}
+	ctx := context.Background()
+	state := framework.NewCycleState()
+	pod := &corev1.Pod{}
+
+	if _, status := p.Score(ctx, state, pod, "fast"); status.IsSuccess() {
+		t.Fatal("expected Score to fail before PreScore")
+	}
+
+	if status := p.PreScore(ctx, state, pod, []*corev1.Node{node("fast"), node("slow")}); !status.IsSuccess() {
+		t.Fatalf("PreScore: %v", status)
+	}
+	var scores framework.NodeScoreList
+	for _, name := range []string{"fast", "slow"} {
+		score, status := p.Score(ctx, state, pod, name)
+		if !status.IsSuccess() {
+			t.Fatalf("Score(%s): %v", name, status)
+		}
+		scores = append(scores, framework.NodeScore{Name: name, Score: score})
+	}
+	if _, status := p.Score(ctx, state, pod, "other"); status.IsSuccess() {
+		t.Error("expected Score to fail for a node PreScore did not see")
+	}
+
+	if status := p.ScoreExtensions().NormalizeScore(ctx, state, pod, scores); !status.IsSuccess() {
+		t.Fatalf("NormalizeScore: %v", status)
+	}
+	want := framework.NodeScoreList{{Name: "fast", Score: framework.MaxNodeScore}, {Name: "slow", Score: 25}}
+	for i := range want {
+		if scores[i] != want[i] {
+			t.Errorf("scores[%d] = %+v, want %+v", i, scores[i], want[i])
+		}
+	}
+}
+
+func TestReserve(t *testing.T) {
+	eval := &fakeEvaluator{unfit: map[string]string{"relabeled": "node has no GPU"}}
+	p := &Plugin{eval: eval}
+	ctx := context.Background()
+	if status := p.Reserve(ctx, framework.NewCycleState(), &corev1.Pod{}, "gpu"); !status.IsSuccess() {
+		t.Errorf("expected gpu to be reserved, got %v", status)
+	}
+	status := p.Reserve(ctx, framework.NewCycleState(), &corev1.Pod{}, "relabeled")
+	if status.Code() != framework.Unschedulable {
+		t.Errorf("expected a node that no longer fits to be unschedulable, got %v", status)
+	}
+
+	p.Unreserve(ctx, framework.NewCycleState(), &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p"}}, "gpu")
+	if len(eval.unreserved) != 1 || eval.unreserved[0] != "p" {
+		t.Errorf("expected Unreserve to release p, got %v", eval.unreserved)
+	}
+}
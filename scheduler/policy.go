@@ -0,0 +1,235 @@
+package scheduler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+)
+
+// PolicyKey is the ConfigMap key the scheduling policy is read from.
+const PolicyKey = "policy.yaml"
+
+// DefaultPolicyConfigMap is the namespace/name of the policy ConfigMap,
+// overridden with SCHED_POLICY_CONFIGMAP.
+const DefaultPolicyConfigMap = "flexinfer-system/flexinfer-sched-policy"
+
+// Event reasons recorded on the policy ConfigMap.
+const (
+	ReasonPolicyApplied = "PolicyApplied"
+	ReasonInvalidPolicy = "InvalidPolicy"
+)
+
+// Weights is how much each scoring factor counts. Only their ratios matter.
+type Weights struct {
+	TokensPerSecond float64 `yaml:"tokensPerSecond" json:"tokensPerSecond"`
+	Utilization     float64 `yaml:"utilization" json:"utilization"`
+	Cost            float64 `yaml:"cost" json:"cost"`
+	// DeviceClass only counts for models with preferred device classes.
+	DeviceClass float64 `yaml:"deviceClass" json:"deviceClass"`
+	// Topology only counts for tensor-parallel models.
+	Topology float64 `yaml:"topology" json:"topology"`
+}
+
+// validate rejects negative or non-finite weights, and weights that are all
+// zero and so would score every node the same.
+func (w Weights) validate() error {
+	for name, v := range map[string]float64{
+		"tokensPerSecond": w.TokensPerSecond, "utilization": w.Utilization, "cost": w.Cost,
+		"deviceClass": w.DeviceClass, "topology": w.Topology,
+	} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("%s weight %v must be a non-negative number", name, v)
+		}
+	}
+	if w.total() == 0 {
+		return errors.New("at least one weight must be positive")
+	}
+	return nil
+}
+
+func (w Weights) total() float64 {
+	return w.TokensPerSecond + w.Utilization + w.Cost + w.DeviceClass + w.Topology
+}
+
+// Policy is the scheduler's scoring configuration, read from the PolicyKey
+// of the policy ConfigMap, e.g.
+//
+//	weights:
+//	  tokensPerSecond: 0.7
+//	  utilization: 0.2
+//	  cost: 0.1
+//	namespaces:
+//	  batch:
+//	    tokensPerSecond: 0.2
+//	    cost: 0.8
+type Policy struct {
+	// Weights apply to pods in every namespace without an override. Weights
+	// the ConfigMap leaves out keep their defaults.
+	Weights Weights `yaml:"weights" json:"weights"`
+	// Namespaces overrides Weights for pods in the named namespaces. An
+	// override replaces Weights whole, so factors it leaves out are ignored.
+	Namespaces map[string]Weights `yaml:"namespaces,omitempty" json:"namespaces,omitempty"`
+}
+
+// weights returns the weights for pods in namespace.
+func (p *Policy) weights(namespace string) Weights {
+	if w, ok := p.Namespaces[namespace]; ok {
+		return w
+	}
+	return p.Weights
+}
+
+func (p *Policy) validate() error {
+	if err := p.Weights.validate(); err != nil {
+		return err
+	}
+	for ns, w := range p.Namespaces {
+		if err := w.validate(); err != nil {
+			return fmt.Errorf("namespace %s: %w", ns, err)
+		}
+	}
+	return nil
+}
+
+// ParsePolicy parses and validates a policy on top of defaults. Unknown
+// fields are rejected, so a misspelled weight is not silently ignored.
+func ParsePolicy(data []byte, defaults Weights) (*Policy, error) {
+	p := &Policy{Weights: defaults}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty document leaves the defaults in place.
+	if err := dec.Decode(p); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse policy: %w", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	return p, nil
+}
+
+// defaultWeights returns the weights used when there is no policy
+// ConfigMap, from SCHED_TPS_WEIGHT, SCHED_UTIL_WEIGHT and SCHED_COST_WEIGHT.
+// Weights that do not parse fall back to their default, loudly.
+func defaultWeights() Weights {
+	w := Weights{
+		TokensPerSecond: parseWeight("SCHED_TPS_WEIGHT", 0.7),
+		Utilization:     parseWeight("SCHED_UTIL_WEIGHT", 0.2),
+		Cost:            parseWeight("SCHED_COST_WEIGHT", 0.1),
+	}
+	if err := w.validate(); err != nil {
+		log.Log.Error(err, "Ignoring SCHED_*_WEIGHT environment variables")
+		return Weights{TokensPerSecond: 0.7, Utilization: 0.2, Cost: 0.1}
+	}
+	return w
+}
+
+func parseWeight(env string, def float64) float64 {
+	v := os.Getenv(env)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Log.Error(err, "Ignoring invalid weight", "env", env, "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+// policyConfigMap returns the namespace and name of the policy ConfigMap.
+func policyConfigMap() (string, string) {
+	if v := os.Getenv("SCHED_POLICY_CONFIGMAP"); v != "" {
+		if namespace, name, ok := strings.Cut(v, "/"); ok {
+			return namespace, name
+		}
+		log.Log.Error(nil, "Ignoring SCHED_POLICY_CONFIGMAP, which is not namespace/name", "value", v)
+	}
+	namespace, name, _ := strings.Cut(DefaultPolicyConfigMap, "/")
+	return namespace, name
+}
+
+// applyPolicy swaps in the policy from cm, or the defaults if cm was deleted.
+// An invalid policy is logged and recorded as an event on cm, and the
+// previous policy stays in effect.
+func (s *Scheduler) applyPolicy(cm *corev1.ConfigMap) {
+	log := log.Log.WithName("policy")
+	if cm == nil {
+		log.Info("Policy ConfigMap removed; using default weights", "weights", s.defaults)
+		s.policy.Store(&Policy{Weights: s.defaults})
+		return
+	}
+	p, err := ParsePolicy([]byte(cm.Data[PolicyKey]), s.defaults)
+	if err != nil {
+		log.Error(err, "Rejected scheduling policy; keeping the previous one", "configMap", cm.Namespace+"/"+cm.Name)
+		if s.recorder != nil {
+			s.recorder.Eventf(cm, corev1.EventTypeWarning, ReasonInvalidPolicy, "Keeping the previous policy: %v", err)
+		}
+		return
+	}
+	s.policy.Store(p)
+	log.Info("Applied scheduling policy", "configMap", cm.Namespace+"/"+cm.Name, "weights", p.Weights, "namespaces", len(p.Namespaces))
+	if s.recorder != nil {
+		s.recorder.Eventf(cm, corev1.EventTypeNormal, ReasonPolicyApplied, "Applied weights %+v with %d namespace override(s)", p.Weights, len(p.Namespaces))
+	}
+}
+
+// weights returns the weights for pods in namespace under the current
+// policy.
+func (s *Scheduler) weights(namespace string) Weights {
+	if p := s.policy.Load(); p != nil {
+		return p.weights(namespace)
+	}
+	return s.defaults
+}
+
+// weightsFor returns the weights for pod: its model's own, if it sets any,
+// or else those for its namespace. Unweighted device class preferences and,
+// for tensor-parallel models, interconnects count as much as throughput.
+func (s *Scheduler) weightsFor(pod *corev1.Pod, model *aiv1alpha1.SchedulingPolicy, tensorParallel bool) Weights {
+	w := s.weights(pod.Namespace)
+	if model != nil {
+		if mw := model.Weights; mw != nil && mw.TokensPerSecond+mw.Utilization+mw.Cost+mw.DeviceClass+mw.Topology > 0 {
+			w = Weights{
+				TokensPerSecond: float64(mw.TokensPerSecond),
+				Utilization:     float64(mw.Utilization),
+				Cost:            float64(mw.Cost),
+				DeviceClass:     float64(mw.DeviceClass),
+				Topology:        float64(mw.Topology),
+			}
+		}
+		if len(model.PreferredDeviceClasses) > 0 && w.DeviceClass == 0 {
+			w.DeviceClass = w.TokensPerSecond
+		}
+	}
+	if tensorParallel && w.Topology == 0 {
+		w.Topology = w.TokensPerSecond
+	}
+	return w
+}
+
+// modelPolicy returns the SchedulingPolicy the controller stamped on pod, or
+// nil if there is none.
+func modelPolicy(pod *corev1.Pod) *aiv1alpha1.SchedulingPolicy {
+	v, ok := pod.Annotations[aiv1alpha1.AnnotationSchedulingPolicy]
+	if !ok {
+		return nil
+	}
+	p := &aiv1alpha1.SchedulingPolicy{}
+	if err := json.Unmarshal([]byte(v), p); err != nil {
+		log.Log.Error(err, "Ignoring invalid scheduling policy annotation", "pod", pod.Namespace+"/"+pod.Name)
+		return nil
+	}
+	return p
+}
@@ -0,0 +1,154 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/internal/cache"
+)
+
+var testDefaults = Weights{TokensPerSecond: 0.7, Utilization: 0.2, Cost: 0.1}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy([]byte(`
+weights:
+  cost: 0.5
+namespaces:
+  batch:
+    cost: 1
+`), testDefaults)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Global weights the policy leaves out keep their defaults; a namespace
+	// override replaces them whole.
+	if want := (Weights{TokensPerSecond: 0.7, Utilization: 0.2, Cost: 0.5}); p.weights("default") != want {
+		t.Errorf("default weights = %+v, want %+v", p.weights("default"), want)
+	}
+	if want := (Weights{Cost: 1}); p.weights("batch") != want {
+		t.Errorf("batch weights = %+v, want %+v", p.weights("batch"), want)
+	}
+
+	p, err = ParsePolicy(nil, testDefaults)
+	if err != nil || p.Weights != testDefaults {
+		t.Errorf("expected an empty policy to use the defaults, got %+v, %v", p, err)
+	}
+
+	for policy, want := range map[string]string{
+		"weights:\n  tokensPerSecond: -1\n":             "tokensPerSecond weight -1 must be a non-negative number",
+		"weights:\n  tps: 1\n":                          "field tps not found",
+		"weights:\n  utilization: high\n":               "cannot unmarshal",
+		"namespaces:\n  batch:\n    tokensPerSecond: 0": "namespace batch: at least one weight must be positive",
+	} {
+		if _, err := ParsePolicy([]byte(policy), testDefaults); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParsePolicy(%q) = %v, want an error containing %q", policy, err, want)
+		}
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	s := &Scheduler{defaults: testDefaults, recorder: recorder}
+	cm := func(policy string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "flexinfer-system", Name: "flexinfer-sched-policy"},
+			Data:       map[string]string{PolicyKey: policy},
+		}
+	}
+
+	s.applyPolicy(cm("weights:\n  tokensPerSecond: 1\n  utilization: 0\n  cost: 0\n"))
+	if got := s.weights("default"); got != (Weights{TokensPerSecond: 1}) {
+		t.Fatalf("expected the policy to be applied, got %+v", got)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Normal "+ReasonPolicyApplied) {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	// A bad policy is rejected and the last good one stays in effect.
+	s.applyPolicy(cm("weights:\n  cost: -3\n"))
+	if got := s.weights("default"); got != (Weights{TokensPerSecond: 1}) {
+		t.Errorf("expected the previous policy to be kept, got %+v", got)
+	}
+	if event := <-recorder.Events; !strings.HasPrefix(event, "Warning "+ReasonInvalidPolicy) {
+		t.Errorf("unexpected event %q", event)
+	}
+
+	s.applyPolicy(nil)
+	if got := s.weights("default"); got != testDefaults {
+		t.Errorf("expected the defaults once the ConfigMap is deleted, got %+v", got)
+	}
+}
+
+func TestNormalizeUsesNamespaceWeights(t *testing.T) {
+	s := &Scheduler{defaults: testDefaults}
+	s.policy.Store(&Policy{Weights: testDefaults, Namespaces: map[string]Weights{"batch": {Cost: 1}}})
+	scores := func(namespace string) []*NodeScore {
+		fast := &NodeScore{Node: "fast", TokensPerSecond: 200, Cost: 10}
+		cheap := &NodeScore{Node: "cheap", TokensPerSecond: 50, Cost: 1}
+		s.Normalize(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace}}, []*NodeScore{fast, cheap})
+		return []*NodeScore{fast, cheap}
+	}
+
+	if n := scores("default"); n[0].Score <= n[1].Score {
+		t.Errorf("expected the fast node to win by default, got %v vs %v", n[0].Score, n[1].Score)
+	}
+	if n := scores("batch"); n[0].Score != 0 || n[1].Score != MaxScore {
+		t.Errorf("expected only cost to count in batch, got %v vs %v", n[0].Score, n[1].Score)
+	}
+}
+
+func TestNormalizeHonorsModelPolicy(t *testing.T) {
+	s := &Scheduler{cache: &fakeCache{}, defaults: testDefaults}
+	l4 := map[string]string{"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.arch": "sm_89", "flexinfer.ai/gpu.vram": "24Gi"}
+	a100 := map[string]string{"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.arch": "sm_80", "flexinfer.ai/gpu.vram": "80Gi"}
+	nodes := []*corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "busy-a100", Labels: a100, Annotations: map[string]string{"flexinfer.ai/gpu.util": "90"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "idle-l4", Labels: l4, Annotations: map[string]string{"flexinfer.ai/gpu.util": "10"}}},
+	}
+	winner := func(policy string) string {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Annotations: map[string]string{aiv1alpha1.AnnotationSchedulingPolicy: policy},
+		}}
+		var scores []*NodeScore
+		for _, node := range nodes {
+			n := s.Factors(pod, node)
+			scores = append(scores, &n)
+		}
+		s.Normalize(pod, scores)
+		if scores[0].Score > scores[1].Score {
+			return scores[0].Node
+		}
+		return scores[1].Node
+	}
+
+	// The global weights favor the idle node.
+	if got := winner(`{}`); got != "idle-l4" {
+		t.Errorf("global weights: got %s, want idle-l4", got)
+	}
+	if got := winner(`{"placement":"Pack"}`); got != "busy-a100" {
+		t.Errorf("Pack: got %s, want busy-a100", got)
+	}
+	if got := winner(`{"preferredDeviceClasses":["nvidia-sm-80-80gi"],"weights":{"utilization":1,"deviceClass":2}}`); got != "busy-a100" {
+		t.Errorf("preferred device class: got %s, want busy-a100", got)
+	}
+	// All-zero model weights fall back to the global ones.
+	if got := winner(`{"weights":{}}`); got != "idle-l4" {
+		t.Errorf("empty weights: got %s, want idle-l4", got)
+	}
+
+	// VRAM claimed by models placed since the last utilization sample counts
+	// as load: spreading now avoids the l4, packing fills it.
+	s.cache.(*fakeCache).usage = map[string]cache.Usage{"idle-l4": {VRAMBytes: 23 << 30, GPUs: 1, Pods: 3}}
+	if got := winner(`{}`); got != "busy-a100" {
+		t.Errorf("Spread with claimed VRAM: got %s, want busy-a100", got)
+	}
+	if got := winner(`{"placement":"Pack","weights":{"utilization":1}}`); got != "idle-l4" {
+		t.Errorf("Pack with claimed VRAM: got %s, want idle-l4", got)
+	}
+}
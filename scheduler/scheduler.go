@@ -2,33 +2,73 @@
 package scheduler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"strconv"
+	"sync/atomic"
 
+	"github.com/flexinfer/flexinfer/agents/benchmarker"
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
 	"github.com/flexinfer/flexinfer/internal/cache"
+	"github.com/flexinfer/flexinfer/pkg/deviceclass"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-// Scheduler implements the scheduler extender logic.
+// Evaluator is the placement logic shared by both ways of deploying
+// flexinfer-sched: the HTTP extender served by Scheduler and the
+// scheduling-framework plugin in package plugin.
+type Evaluator interface {
+	// Fit returns why pod cannot run on node, or "" if it can.
+	Fit(ctx context.Context, pod *corev1.Pod, node *corev1.Node) string
+	// Factors reads the raw scoring factors of pod on node.
+	Factors(pod *corev1.Pod, node *corev1.Node) NodeScore
+	// Normalize scales the factors of scores across the candidate nodes and
+	// weights them into each node's Score for pod.
+	Normalize(pod *corev1.Pod, scores []*NodeScore)
+	// Reserve confirms that pod still fits the named node and claims its
+	// share of the node's GPUs while it is bound there.
+	Reserve(ctx context.Context, pod *corev1.Pod, nodeName string) error
+	// Unreserve releases what Reserve claimed if pod could not be bound.
+	Unreserve(pod *corev1.Pod)
+}
+
+var _ Evaluator = &Scheduler{}
+
 type objectCache interface {
 	GetNode(name string) (*corev1.Node, error)
 	GetConfigMap(namespace, name string) (*corev1.ConfigMap, error)
+	GetModelDeployment(namespace, name string) (*aiv1alpha1.ModelDeployment, error)
+	Usage(node string) cache.Usage
+	Assume(pod *corev1.Pod, node string, claim cache.Claim)
+	Forget(pod *corev1.Pod)
 }
 
+// Scheduler implements the scheduler extender logic.
 type Scheduler struct {
-	cache      objectCache
-	tpsWeight  float64
-	utilWeight float64
-	costWeight float64
+	cache objectCache
+
+	// policy is swapped whole whenever the policy ConfigMap changes.
+	policy atomic.Pointer[Policy]
+	// defaults are the weights from the environment, used where the policy
+	// ConfigMap does not say.
+	defaults Weights
+	recorder record.EventRecorder
+
+	// history backs the /debug/scores endpoint.
+	history scoreHistory
 }
 
 // NewScheduler creates a new Scheduler.
@@ -41,128 +81,296 @@ func NewScheduler() (*Scheduler, error) {
 			return nil, fmt.Errorf("failed to get kubernetes config: %w", err)
 		}
 	}
+	return NewSchedulerForConfig(config)
+}
+
+// NewSchedulerForConfig creates a new Scheduler that watches the cluster
+// config points at.
+func NewSchedulerForConfig(config *rest.Config) (*Scheduler, error) {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create kubernetes clientset: %w", err)
 	}
-	s := &Scheduler{cache: cache.NewCache(clientset)}
-	s.tpsWeight = parseWeight("SCHED_TPS_WEIGHT", 0.7)
-	s.utilWeight = parseWeight("SCHED_UTIL_WEIGHT", 0.2)
-	s.costWeight = parseWeight("SCHED_COST_WEIGHT", 0.1)
-	return s, nil
-}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	c := cache.NewCache(clientset, dynamicClient)
 
-func parseWeight(env string, def float64) float64 {
-	if v := os.Getenv(env); v != "" {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			return f
-		}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	s := &Scheduler{
+		cache:    c,
+		defaults: defaultWeights(),
+		recorder: broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "flexinfer-sched"}),
+	}
+	s.policy.Store(&Policy{Weights: s.defaults})
+	if err := c.TrackClaims(s.podClaim); err != nil {
+		return nil, fmt.Errorf("failed to track GPU claims: %w", err)
 	}
-	return def
+	namespace, name := policyConfigMap()
+	if err := c.WatchConfigMap(namespace, name, s.applyPolicy); err != nil {
+		return nil, fmt.Errorf("failed to watch policy ConfigMap %s/%s: %w", namespace, name, err)
+	}
+	return s, nil
 }
 
-// Filter is the handler for the /filter endpoint.
+// Filter is the handler for the /filter endpoint. It answers in the form it
+// was asked: with node names when the extender is nodeCacheCapable, with Node
+// objects otherwise.
 func (s *Scheduler) Filter(w http.ResponseWriter, r *http.Request) {
 	log := log.FromContext(r.Context())
 	var args extenderv1.ExtenderArgs
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	if !readArgs(w, r, &args) {
 		return
 	}
-	if err := json.Unmarshal(body, &args); err != nil {
-		http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+	if args.Pod == nil {
+		http.Error(w, "Missing pod", http.StatusBadRequest)
 		return
 	}
 
 	log.Info("Filtering for Pod", "pod", args.Pod.Name)
 
-	filteredNodes := make([]string, 0)
-	for _, nodeName := range *args.NodeNames {
-		node, err := s.cache.GetNode(nodeName)
-		if err != nil {
-			log.Error(err, "Failed to get node from cache", "node", nodeName)
+	req := s.requirementsFor(r.Context(), args.Pod)
+	filteredNames := make([]string, 0)
+	var filteredNodes []corev1.Node
+	failedNodes := make(extenderv1.FailedNodesMap)
+	for _, c := range s.candidates(args) {
+		if c.err != nil {
+			log.Error(c.err, "Failed to get node from cache", "node", c.name)
+			failedNodes[c.name] = "node not found in scheduler cache"
 			continue
 		}
-		if _, ok := node.Labels["flexinfer.ai/gpu.vendor"]; ok {
-			filteredNodes = append(filteredNodes, nodeName)
+		if reason := s.fit(c.node, req); reason != "" {
+			failedNodes[c.name] = reason
+			continue
 		}
+		filteredNames = append(filteredNames, c.name)
+		filteredNodes = append(filteredNodes, *c.node)
 	}
 
-	result := extenderv1.ExtenderFilterResult{
-		NodeNames:   &filteredNodes,
-		FailedNodes: make(map[string]string),
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(result); err != nil {
-		log.Error(err, "Failed to encode response")
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	result := extenderv1.ExtenderFilterResult{FailedNodes: failedNodes}
+	if args.Nodes != nil {
+		result.Nodes = &corev1.NodeList{Items: filteredNodes}
+	} else {
+		result.NodeNames = &filteredNames
 	}
+	writeJSON(w, r, result)
 }
 
-// Score is the handler for the /score endpoint.
+// Score is the handler for the /score endpoint, Prioritize under the name
+// existing scheduler configurations use.
 func (s *Scheduler) Score(w http.ResponseWriter, r *http.Request) {
+	s.Prioritize(w, r)
+}
+
+// Prioritize is the handler for the /prioritize endpoint, the extender's
+// prioritizeVerb.
+func (s *Scheduler) Prioritize(w http.ResponseWriter, r *http.Request) {
 	log := log.FromContext(r.Context())
 	var args extenderv1.ExtenderArgs
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+	if !readArgs(w, r, &args) {
 		return
 	}
-	if err := json.Unmarshal(body, &args); err != nil {
-		http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+	if args.Pod == nil {
+		http.Error(w, "Missing pod", http.StatusBadRequest)
 		return
 	}
 
 	log.Info("Scoring for Pod", "pod", args.Pod.Name)
 
-	// Get the benchmark results from the cache
-	cmName := fmt.Sprintf("%s-benchmark-results", args.Pod.Labels["modeldeployment_cr"])
-	cm, err := s.cache.GetConfigMap(args.Pod.Namespace, cmName)
-	if err != nil {
-		log.Error(err, "Failed to get benchmark configmap from cache", "configmap", cmName)
-		// If we can't get the benchmark, score all nodes with 0
-		scores := make([]extenderv1.HostPriority, len(*args.NodeNames))
-		for i, nodeName := range *args.NodeNames {
-			scores[i] = extenderv1.HostPriority{
-				Host:  nodeName,
-				Score: 0,
-			}
-		}
-		if err := json.NewEncoder(w).Encode(scores); err != nil {
-			log.Error(err, "Failed to encode response")
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	breakdown := s.scoreNodes(args.Pod, s.candidates(args))
+	s.history.record(args.Pod.Namespace+"/"+args.Pod.Name, breakdown)
+	scores := make(extenderv1.HostPriorityList, len(breakdown))
+	for i, n := range breakdown {
+		if n.Error != "" {
+			log.Error(errors.New(n.Error), "failed to get node", "node", n.Node)
 		}
+		scores[i] = extenderv1.HostPriority{Host: n.Node, Score: n.ExtenderScore}
+	}
+	writeJSON(w, r, scores)
+}
+
+// Preempt is the handler for the /preempt endpoint, the extender's
+// preemptVerb. Evicting pods frees GPUs but does not add VRAM or change the
+// hardware, so nodes the pod cannot fit on even when empty are dropped from
+// the scheduler's preemption candidates; the rest keep their victims.
+func (s *Scheduler) Preempt(w http.ResponseWriter, r *http.Request) {
+	log := log.FromContext(r.Context())
+	var args extenderv1.ExtenderPreemptionArgs
+	if !readArgs(w, r, &args) {
 		return
 	}
+	if args.Pod == nil {
+		http.Error(w, "Missing pod", http.StatusBadRequest)
+		return
+	}
+
+	log.Info("Preempting for Pod", "pod", args.Pod.Name)
 
-	tps, _ := strconv.ParseFloat(cm.Data["tokensPerSecond"], 64)
+	// Victims arrive as Pods or, when nodeCacheCapable, as UIDs; the
+	// response always carries UIDs.
+	victims := args.NodeNameToMetaVictims
+	if victims == nil {
+		victims = make(map[string]*extenderv1.MetaVictims, len(args.NodeNameToVictims))
+		for nodeName, v := range args.NodeNameToVictims {
+			meta := &extenderv1.MetaVictims{NumPDBViolations: v.NumPDBViolations}
+			for _, pod := range v.Pods {
+				meta.Pods = append(meta.Pods, &extenderv1.MetaPod{UID: string(pod.UID)})
+			}
+			victims[nodeName] = meta
+		}
+	}
 
-	scores := make([]extenderv1.HostPriority, len(*args.NodeNames))
-	for i, nodeName := range *args.NodeNames {
+	req := s.requirementsFor(r.Context(), args.Pod)
+	result := extenderv1.ExtenderPreemptionResult{NodeNameToMetaVictims: map[string]*extenderv1.MetaVictims{}}
+	for nodeName, v := range victims {
 		node, err := s.cache.GetNode(nodeName)
 		if err != nil {
-			log.Error(err, "failed to get node", "node", nodeName)
+			log.Error(err, "Failed to get node from cache", "node", nodeName)
+			continue
+		}
+		if reason := unfitReason(node, req); reason != "" {
+			log.V(1).Info("Preempting on node would not help", "node", nodeName, "reason", reason)
 			continue
 		}
+		result.NodeNameToMetaVictims[nodeName] = v
+	}
+	writeJSON(w, r, result)
+}
 
-		utilStr := node.Annotations["flexinfer.ai/gpu.util"]
-		util, _ := strconv.ParseFloat(utilStr, 64)
-		costStr := node.Annotations["flexinfer.ai/cost"]
-		cost, _ := strconv.ParseFloat(costStr, 64)
+// Fit returns why pod cannot run on node, or "" if it can.
+func (s *Scheduler) Fit(ctx context.Context, pod *corev1.Pod, node *corev1.Node) string {
+	return s.fit(node, s.requirementsFor(ctx, pod))
+}
 
-		score := tps*s.tpsWeight - util*s.utilWeight - cost*s.costWeight
+// fit returns why a model with req cannot run on node, either at all or
+// next to the model pods already there, or "" if it can.
+func (s *Scheduler) fit(node *corev1.Node, req requirements) string {
+	if reason := unfitReason(node, req); reason != "" {
+		return reason
+	}
+	return overcommitReason(node, req, s.cache.Usage(node.Name))
+}
 
-		scores[i] = extenderv1.HostPriority{
-			Host:  nodeName,
-			Score: int64(score),
+// Reserve confirms that pod still fits the named node and claims its share
+// of the node's GPUs until the pod informer sees it bound. The framework
+// filters against a snapshot taken at the start of the cycle; if the agent
+// relabeled the node or another pod was reserved there since, the cache
+// knows. Nodes the cache does not know are left to the scheduler.
+func (s *Scheduler) Reserve(ctx context.Context, pod *corev1.Pod, nodeName string) error {
+	node, err := s.cache.GetNode(nodeName)
+	if err != nil {
+		return nil
+	}
+	req := s.requirementsFor(ctx, pod)
+	if reason := s.fit(node, req); reason != "" {
+		return errors.New(reason)
+	}
+	s.cache.Assume(pod, nodeName, claimFor(node, req))
+	return nil
+}
+
+// Unreserve releases what Reserve claimed for pod.
+func (s *Scheduler) Unreserve(pod *corev1.Pod) {
+	s.cache.Forget(pod)
+}
+
+// podClaim returns what pod claims of the node it is bound to.
+func (s *Scheduler) podClaim(pod *corev1.Pod) cache.Claim {
+	req := s.requirementsFor(context.Background(), pod)
+	node, err := s.cache.GetNode(pod.Spec.NodeName)
+	if err != nil {
+		return cache.Claim{VRAMBytes: req.vramBytes, GPUs: float64(req.gpus)}
+	}
+	return claimFor(node, req)
+}
+
+// candidate is a node the scheduler asks the extender about.
+type candidate struct {
+	name string
+	node *corev1.Node
+	// err is set when the node is not in the cache.
+	err error
+}
+
+// candidates returns the nodes in args: the Node objects sent by the
+// scheduler, or the named nodes from the cache when the extender is
+// nodeCacheCapable.
+func (s *Scheduler) candidates(args extenderv1.ExtenderArgs) []candidate {
+	if args.Nodes != nil {
+		candidates := make([]candidate, len(args.Nodes.Items))
+		for i := range args.Nodes.Items {
+			candidates[i] = candidate{name: args.Nodes.Items[i].Name, node: &args.Nodes.Items[i]}
 		}
+		return candidates
+	}
+	if args.NodeNames == nil {
+		return nil
+	}
+	candidates := make([]candidate, len(*args.NodeNames))
+	for i, name := range *args.NodeNames {
+		node, err := s.cache.GetNode(name)
+		candidates[i] = candidate{name: name, node: node, err: err}
+	}
+	return candidates
+}
+
+// readArgs decodes the request body into args, answering with an error and
+// returning false if it cannot.
+func readArgs(w http.ResponseWriter, r *http.Request, args any) bool {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return false
+	}
+	if err := json.Unmarshal(body, args); err != nil {
+		http.Error(w, "Failed to unmarshal request body", http.StatusBadRequest)
+		return false
 	}
+	return true
+}
 
+// writeJSON answers with v.
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(scores); err != nil {
-		log.Error(err, "Failed to encode response")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.FromContext(r.Context()).Error(err, "Failed to encode response")
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
+
+// requirementsFor returns what pod's model needs. Pods that do not belong to
+// a ModelDeployment, or whose ModelDeployment is not cached yet, only need a
+// GPU within the cost limit of their scheduling policy.
+func (s *Scheduler) requirementsFor(ctx context.Context, pod *corev1.Pod) requirements {
+	var req requirements
+	if name := pod.Labels[benchmarker.LabelModelDeployment]; name != "" {
+		if md, err := s.cache.GetModelDeployment(pod.Namespace, name); err != nil {
+			log.FromContext(ctx).Error(err, "Failed to get ModelDeployment from cache", "modelDeployment", name)
+		} else {
+			req = requirementsFor(md)
+		}
+	}
+	if model := modelPolicy(pod); model != nil && model.MaxCostPerHour != nil {
+		req.maxCost = model.MaxCostPerHour.AsApproximateFloat64()
+	}
+	return req
+}
+
+// tokensPerSecond returns the benchmarked throughput of modelDeployment on
+// node's device class. It falls back to the unpinned benchmark, which is all
+// there is on clusters without agent labels.
+func (s *Scheduler) tokensPerSecond(namespace, modelDeployment string, node *corev1.Node) float64 {
+	names := []string{benchmarker.ResultsConfigMapName(modelDeployment, "")}
+	if class, ok := deviceclass.FromLabels(node.Labels); ok {
+		names = append([]string{benchmarker.ResultsConfigMapName(modelDeployment, class.String())}, names...)
+	}
+	for _, name := range names {
+		if cm, err := s.cache.GetConfigMap(namespace, name); err == nil {
+			tps, _ := strconv.ParseFloat(cm.Data[benchmarker.KeyTokensPerSecond], 64)
+			return tps
+		}
+	}
+	return 0
+}
@@ -2,20 +2,30 @@ package scheduler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/internal/cache"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+	"k8s.io/utils/pointer"
 )
 
 type fakeCache struct {
-	nodes      map[string]*corev1.Node
-	configMaps map[string]*corev1.ConfigMap
+	nodes            map[string]*corev1.Node
+	configMaps       map[string]*corev1.ConfigMap
+	modelDeployments map[string]*aiv1alpha1.ModelDeployment
+	usage            map[string]cache.Usage
+	assumed          map[string]cache.Claim
 }
 
 func (f *fakeCache) GetNode(name string) (*corev1.Node, error) {
@@ -33,6 +43,199 @@ func (f *fakeCache) GetConfigMap(namespace, name string) (*corev1.ConfigMap, err
 	return nil, fmt.Errorf("not found")
 }
 
+func (f *fakeCache) GetModelDeployment(namespace, name string) (*aiv1alpha1.ModelDeployment, error) {
+	if md, ok := f.modelDeployments[namespace+"/"+name]; ok {
+		return md, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func (f *fakeCache) Usage(node string) cache.Usage {
+	return f.usage[node]
+}
+
+func (f *fakeCache) Assume(pod *corev1.Pod, node string, claim cache.Claim) {
+	if f.assumed == nil {
+		f.assumed = map[string]cache.Claim{}
+	}
+	f.assumed[pod.Name] = claim
+	u := f.Usage(node)
+	u.VRAMBytes += claim.VRAMBytes
+	u.GPUs += claim.GPUs
+	u.Pods++
+	if f.usage == nil {
+		f.usage = map[string]cache.Usage{}
+	}
+	f.usage[node] = u
+}
+
+func (f *fakeCache) Forget(pod *corev1.Pod) {
+	delete(f.assumed, pod.Name)
+}
+
+func TestFilter(t *testing.T) {
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	cache := &fakeCache{
+		nodes: map[string]*corev1.Node{
+			"a100": node("a100", map[string]string{
+				"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.arch": "sm_80",
+				"flexinfer.ai/gpu.vram": "80Gi", "flexinfer.ai/gpu.int4": "true", "flexinfer.ai/gpu.count": "2",
+			}),
+			"l4": node("l4", map[string]string{
+				"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.arch": "sm_89",
+				"flexinfer.ai/gpu.vram": "24Gi", "flexinfer.ai/gpu.int4": "true", "flexinfer.ai/gpu.count": "1",
+			}),
+			"mi250": node("mi250", map[string]string{
+				"flexinfer.ai/gpu.vendor": "AMD", "flexinfer.ai/gpu.arch": "gfx90a",
+				"flexinfer.ai/gpu.vram": "64Gi", "flexinfer.ai/gpu.int4": "true", "flexinfer.ai/gpu.count": "1",
+			}),
+			"cpu": node("cpu", map[string]string{"flexinfer.ai/cpu.avx512": "true"}),
+		},
+		modelDeployments: map[string]*aiv1alpha1.ModelDeployment{
+			// 70B parameters in 4-bit AWQ need about 39Gi.
+			"default/llama-awq": {Spec: aiv1alpha1.ModelDeploymentSpec{
+				Backend: "vllm", Model: "casperhansen/llama-3-70b-instruct-awq",
+				Requirements: &aiv1alpha1.ModelRequirements{GPUVendor: "NVIDIA"},
+			}},
+		},
+	}
+	sched := &Scheduler{cache: cache}
+
+	filter := func(md string) extenderv1.ExtenderFilterResult {
+		args := extenderv1.ExtenderArgs{
+			Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:      "p",
+				Namespace: "default",
+				Labels:    map[string]string{"modeldeployment_cr": md},
+			}},
+			NodeNames: &[]string{"a100", "l4", "mi250", "cpu", "gone"},
+		}
+		body, _ := json.Marshal(args)
+		rr := httptest.NewRecorder()
+		sched.Filter(rr, httptest.NewRequest("POST", "/filter", bytes.NewBuffer(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", rr.Code)
+		}
+		var result extenderv1.ExtenderFilterResult
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return result
+	}
+
+	result := filter("llama-awq")
+	if got := *result.NodeNames; len(got) != 1 || got[0] != "a100" {
+		t.Fatalf("expected only a100 to fit, got %v", got)
+	}
+	want := map[string]string{
+		"l4":    "model needs 39Gi of VRAM on 1 GPU(s), node has 24Gi",
+		"mi250": "model needs an NVIDIA GPU, node has AMD",
+		"cpu":   "node has no GPU",
+		"gone":  "node not found in scheduler cache",
+	}
+	for name, reason := range want {
+		if got := result.FailedNodes[name]; got != reason {
+			t.Errorf("FailedNodes[%s] = %q, want %q", name, got, reason)
+		}
+	}
+
+	// Without a ModelDeployment any GPU node passes.
+	result = filter("unknown")
+	if got := *result.NodeNames; len(got) != 3 {
+		t.Fatalf("expected every GPU node to pass, got %v", got)
+	}
+}
+
+func TestFilterWithNodeObjects(t *testing.T) {
+	// Without nodeCacheCapable the scheduler sends Node objects, which need
+	// not be in the extender's cache, and expects them back.
+	sched := &Scheduler{cache: &fakeCache{}}
+	args := extenderv1.ExtenderArgs{
+		Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p", Namespace: "default"}},
+		Nodes: &corev1.NodeList{Items: []corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "gpu", Labels: map[string]string{"flexinfer.ai/gpu.vendor": "AMD"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "cpu"}},
+		}},
+	}
+	body, _ := json.Marshal(args)
+	rr := httptest.NewRecorder()
+	sched.Filter(rr, httptest.NewRequest("POST", "/filter", bytes.NewBuffer(body)))
+
+	var result extenderv1.ExtenderFilterResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if result.NodeNames != nil {
+		t.Errorf("expected no node names, got %v", *result.NodeNames)
+	}
+	if result.Nodes == nil || len(result.Nodes.Items) != 1 || result.Nodes.Items[0].Name != "gpu" {
+		t.Fatalf("expected only the gpu node back, got %+v", result.Nodes)
+	}
+	if result.FailedNodes["cpu"] != "node has no GPU" {
+		t.Errorf("unexpected failed nodes: %v", result.FailedNodes)
+	}
+}
+
+func TestFilterWithoutNodes(t *testing.T) {
+	sched := &Scheduler{cache: &fakeCache{}}
+	body, _ := json.Marshal(extenderv1.ExtenderArgs{Pod: &corev1.Pod{}})
+	rr := httptest.NewRecorder()
+	sched.Filter(rr, httptest.NewRequest("POST", "/filter", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rr.Code)
+	}
+
+	body, _ = json.Marshal(extenderv1.ExtenderArgs{})
+	rr = httptest.NewRecorder()
+	sched.Filter(rr, httptest.NewRequest("POST", "/filter", bytes.NewBuffer(body)))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected a request without a pod to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestPreempt(t *testing.T) {
+	cache := &fakeCache{
+		nodes: map[string]*corev1.Node{
+			"big":   {ObjectMeta: metav1.ObjectMeta{Name: "big", Labels: map[string]string{"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.vram": "80Gi"}}},
+			"small": {ObjectMeta: metav1.ObjectMeta{Name: "small", Labels: map[string]string{"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.vram": "8Gi"}}},
+		},
+		modelDeployments: map[string]*aiv1alpha1.ModelDeployment{
+			"default/md": {Spec: aiv1alpha1.ModelDeploymentSpec{Backend: "vllm", Model: "meta-llama/Meta-Llama-3-8B-Instruct"}},
+		},
+	}
+	sched := &Scheduler{cache: cache}
+	victim := func(uid string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: types.UID(uid)}}
+	}
+	args := extenderv1.ExtenderPreemptionArgs{
+		Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: "p", Namespace: "default", Labels: map[string]string{"modeldeployment_cr": "md"},
+		}},
+		NodeNameToVictims: map[string]*extenderv1.Victims{
+			"big":   {Pods: []*corev1.Pod{victim("a")}, NumPDBViolations: 1},
+			"small": {Pods: []*corev1.Pod{victim("b")}},
+		},
+	}
+	body, _ := json.Marshal(args)
+	rr := httptest.NewRecorder()
+	sched.Preempt(rr, httptest.NewRequest("POST", "/preempt", bytes.NewBuffer(body)))
+
+	var result extenderv1.ExtenderPreemptionResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// An 8B fp16 model does not fit in 8Gi however many pods are evicted.
+	if len(result.NodeNameToMetaVictims) != 1 {
+		t.Fatalf("expected only big to remain, got %v", result.NodeNameToMetaVictims)
+	}
+	v := result.NodeNameToMetaVictims["big"]
+	if v == nil || len(v.Pods) != 1 || v.Pods[0].UID != "a" || v.NumPDBViolations != 1 {
+		t.Errorf("unexpected victims on big: %+v", v)
+	}
+}
+
 func TestScore(t *testing.T) {
 	cache := &fakeCache{
 		nodes: map[string]*corev1.Node{
@@ -66,7 +269,7 @@ func TestScore(t *testing.T) {
 		},
 	}
 
-	sched := &Scheduler{cache: cache, tpsWeight: 0.7, utilWeight: 0.2, costWeight: 0.1}
+	sched := &Scheduler{cache: cache, defaults: Weights{TokensPerSecond: 0.7, Utilization: 0.2, Cost: 0.1}}
 
 	args := extenderv1.ExtenderArgs{
 		Pod: &corev1.Pod{
@@ -101,4 +304,163 @@ func TestScore(t *testing.T) {
 	if result[0].Host == result[1].Host {
 		t.Fatalf("hosts should differ")
 	}
+
+	// Both nodes are equally fast, so node2's lower utilization and cost
+	// decide: it gets the maximum, node1 only the throughput share.
+	want := map[string]int64{"node1": 7, "node2": extenderv1.MaxExtenderPriority}
+	for _, r := range result {
+		if r.Score != want[r.Host] {
+			t.Errorf("%s: expected score %d got %d", r.Host, want[r.Host], r.Score)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	sched.DebugScores(rr, httptest.NewRequest("GET", "/debug/scores?pod=default/p", nil))
+	var breakdown map[string][]NodeScore
+	if err := json.Unmarshal(rr.Body.Bytes(), &breakdown); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	scores := breakdown["default/p"]
+	if len(scores) != 2 || scores[0].Node != "node1" {
+		t.Fatalf("unexpected breakdown: %+v", breakdown)
+	}
+	if n := scores[0]; n.Utilization != 50 || n.UtilizationScore != 0 || n.TokensPerSecondScore != MaxScore || math.Abs(n.Score-70) > 1e-9 {
+		t.Errorf("unexpected node1 breakdown: %+v", n)
+	}
+
+	rr = httptest.NewRecorder()
+	sched.DebugScores(rr, httptest.NewRequest("GET", "/debug/scores?pod=default/other", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unscored pod, got %d", rr.Code)
+	}
+}
+
+func TestScoreUsesDeviceClassResults(t *testing.T) {
+	gpu := map[string]string{
+		"flexinfer.ai/gpu.vendor": "NVIDIA",
+		"flexinfer.ai/gpu.arch":   "sm_89",
+		"flexinfer.ai/gpu.vram":   "24Gi",
+	}
+	cache := &fakeCache{
+		nodes: map[string]*corev1.Node{
+			"gpu": {ObjectMeta: metav1.ObjectMeta{Name: "gpu", Labels: gpu}},
+			"cpu": {ObjectMeta: metav1.ObjectMeta{Name: "cpu", Labels: map[string]string{"flexinfer.ai/cpu.avx512": "true"}}},
+			"new": {ObjectMeta: metav1.ObjectMeta{Name: "new", Labels: map[string]string{"flexinfer.ai/gpu.vendor": "AMD"}}},
+		},
+		configMaps: map[string]*corev1.ConfigMap{
+			"default/md-benchmark-results-nvidia-sm-89-24gi": {Data: map[string]string{"tokensPerSecond": "200"}},
+			"default/md-benchmark-results-cpu":               {Data: map[string]string{"tokensPerSecond": "20"}},
+		},
+	}
+	sched := &Scheduler{cache: cache, defaults: Weights{TokensPerSecond: 1}}
+
+	args := extenderv1.ExtenderArgs{
+		Pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:      "p",
+			Namespace: "default",
+			Labels:    map[string]string{"modeldeployment_cr": "md"},
+		}},
+		NodeNames: &[]string{"gpu", "cpu", "new"},
+	}
+	body, _ := json.Marshal(args)
+	rr := httptest.NewRecorder()
+	sched.Score(rr, httptest.NewRequest("POST", "/score", bytes.NewBuffer(body)))
+
+	var result []extenderv1.HostPriority
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	// Throughput is scaled between the slowest and fastest node.
+	want := map[string]int64{"gpu": 10, "cpu": 1, "new": 0}
+	for _, r := range result {
+		if r.Score != want[r.Host] {
+			t.Errorf("%s: expected score %d got %d", r.Host, want[r.Host], r.Score)
+		}
+	}
+}
+
+func TestReserveClaimsGPUs(t *testing.T) {
+	cache := &fakeCache{
+		nodes: map[string]*corev1.Node{
+			"l4": {ObjectMeta: metav1.ObjectMeta{Name: "l4", Labels: map[string]string{
+				"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.vram": "24Gi", "flexinfer.ai/gpu.count": "1",
+			}}},
+		},
+		modelDeployments: map[string]*aiv1alpha1.ModelDeployment{
+			// 8B parameters in 4-bit GGUF need about 4.5Gi.
+			"default/small": {Spec: aiv1alpha1.ModelDeploymentSpec{Backend: "ollama", Model: "llama3:8b"}},
+		},
+	}
+	sched := &Scheduler{cache: cache}
+	pod := func(name string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: "default", Labels: map[string]string{"modeldeployment_cr": "small"},
+		}}
+	}
+
+	// Five small models pack onto the one GPU; the sixth does not fit.
+	for i := 0; i < 5; i++ {
+		if err := sched.Reserve(context.Background(), pod(fmt.Sprint(i)), "l4"); err != nil {
+			t.Fatalf("Reserve %d: %v", i, err)
+		}
+	}
+	err := sched.Reserve(context.Background(), pod("5"), "l4")
+	if err == nil || !strings.Contains(err.Error(), "of node's 1 are free") {
+		t.Fatalf("expected the sixth model not to fit, got %v", err)
+	}
+	if reason := sched.Fit(context.Background(), pod("5"), cache.nodes["l4"]); reason != err.Error() {
+		t.Errorf("expected Filter to agree with Reserve, got %q", reason)
+	}
+
+	sched.Unreserve(pod("0"))
+	if _, ok := cache.assumed["0"]; ok {
+		t.Error("expected Unreserve to forget the claim")
+	}
+}
+
+func TestScoreTensorParallelTopology(t *testing.T) {
+	pairs := "X,NV4,SYS,SYS;NV4,X,SYS,SYS;SYS,SYS,X,NV4;SYS,SYS,NV4,X"
+	node := func(name string, annotations map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations, Labels: map[string]string{
+			"flexinfer.ai/gpu.vendor": "NVIDIA", "flexinfer.ai/gpu.vram": "48Gi", "flexinfer.ai/gpu.count": "4",
+		}}}
+	}
+	nodes := []*corev1.Node{
+		node("nvlink", map[string]string{"flexinfer.ai/gpu.topology": pairs, "flexinfer.ai/gpu.busy": "0"}),
+		node("split", map[string]string{"flexinfer.ai/gpu.topology": pairs, "flexinfer.ai/gpu.busy": "0,2"}),
+		node("unknown", nil),
+	}
+	cache := &fakeCache{modelDeployments: map[string]*aiv1alpha1.ModelDeployment{
+		"default/big": {Spec: aiv1alpha1.ModelDeploymentSpec{
+			Backend: "vllm", Model: "meta-llama/Llama-3.1-70B-Instruct-AWQ", TensorParallelSize: pointer.Int32(2),
+		}},
+	}}
+	// The topology weight defaults to the throughput weight.
+	sched := &Scheduler{cache: cache, defaults: Weights{TokensPerSecond: 1}}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Name: "p", Namespace: "default", Labels: map[string]string{"modeldeployment_cr": "big"},
+	}}
+
+	var scores []*NodeScore
+	for _, n := range nodes {
+		score := sched.Factors(pod, n)
+		scores = append(scores, &score)
+	}
+	sched.Normalize(pod, scores)
+	for i, want := range []string{"NV4", "SYS", ""} {
+		if scores[i].Interconnect != want {
+			t.Errorf("%s: expected interconnect %q, got %q", scores[i].Node, want, scores[i].Interconnect)
+		}
+	}
+	// No node has been benchmarked, so the interconnect decides.
+	if !(scores[0].Score > scores[1].Score && scores[1].Score > scores[2].Score) {
+		t.Errorf("expected nvlink > split > unknown, got %v, %v, %v", scores[0].Score, scores[1].Score, scores[2].Score)
+	}
+
+	// The tensor-parallel degree is the number of GPUs the model needs.
+	single := node("single", nil)
+	single.Labels["flexinfer.ai/gpu.count"] = "1"
+	if reason := sched.Fit(context.Background(), pod, single); reason != "model needs 2 GPUs, node has 1" {
+		t.Errorf("unexpected fit %q", reason)
+	}
 }
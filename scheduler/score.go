@@ -0,0 +1,246 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	extenderv1 "k8s.io/kube-scheduler/extender/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/flexinfer/flexinfer/agents/benchmarker"
+	aiv1alpha1 "github.com/flexinfer/flexinfer/api/v1alpha1"
+	"github.com/flexinfer/flexinfer/pkg/deviceclass"
+	"github.com/flexinfer/flexinfer/pkg/hwprobe"
+)
+
+// MaxScore is the top of the normalized score range, matching the scheduling
+// framework's MaxNodeScore.
+const MaxScore = 100
+
+// maxScoreHistory bounds how many pods' breakdowns /debug/scores keeps.
+const maxScoreHistory = 256
+
+// NodeScore is the breakdown of one node's score for a pod.
+type NodeScore struct {
+	Node string `json:"node"`
+
+	// Raw factors, as read from benchmark results and node annotations.
+	TokensPerSecond float64 `json:"tokensPerSecond"`
+	Utilization     float64 `json:"utilization"`
+	// Allocated is the percentage of the node's VRAM model pods have
+	// claimed. The busier of it and Utilization is what is scored, so that
+	// models just placed count before they show up in utilization.
+	Allocated float64 `json:"allocated"`
+	Cost      float64 `json:"cost"`
+	// DeviceClassPreference ranks the node's device class among the model's
+	// preferred ones: n for the first of n, 0 for classes not listed.
+	DeviceClassPreference float64 `json:"deviceClassPreference"`
+	// Interconnect is the slowest link within the best-connected group of
+	// free GPUs a tensor-parallel model could be sharded across, e.g. NV12,
+	// and Topology its rank, higher being faster. Both are unset for other
+	// models and for nodes that do not publish their topology.
+	Interconnect string  `json:"interconnect,omitempty"`
+	Topology     float64 `json:"topology"`
+
+	// Factors min/max-scaled across the candidate nodes to 0–MaxScore, where
+	// higher is better: the fastest, least utilized (most, for models that
+	// pack) and cheapest node gets MaxScore for each.
+	TokensPerSecondScore float64 `json:"tokensPerSecondScore"`
+	UtilizationScore     float64 `json:"utilizationScore"`
+	CostScore            float64 `json:"costScore"`
+	DeviceClassScore     float64 `json:"deviceClassScore"`
+	TopologyScore        float64 `json:"topologyScore"`
+
+	// Score is the weighted mean of the factor scores, 0–MaxScore.
+	Score float64 `json:"score"`
+	// ExtenderScore is Score scaled to the extender's 0–MaxExtenderPriority.
+	ExtenderScore int64 `json:"extenderScore"`
+
+	// Error is set for nodes that could not be scored; they score 0.
+	Error string `json:"error,omitempty"`
+}
+
+// scoreNodes scores pod on each of nodes.
+func (s *Scheduler) scoreNodes(pod *corev1.Pod, nodes []candidate) []NodeScore {
+	scores := make([]NodeScore, len(nodes))
+	var scored []*NodeScore
+	for i, c := range nodes {
+		if c.err != nil {
+			scores[i] = NodeScore{Node: c.name, Error: c.err.Error()}
+			continue
+		}
+		scores[i] = s.Factors(pod, c.node)
+		scored = append(scored, &scores[i])
+	}
+	s.Normalize(pod, scored)
+	return scores
+}
+
+// Factors reads the raw scoring factors of pod on node: its model's
+// benchmarked throughput there, the node's utilization and cost and, for
+// tensor-parallel models, how well its free GPUs are connected.
+func (s *Scheduler) Factors(pod *corev1.Pod, node *corev1.Node) NodeScore {
+	n := NodeScore{Node: node.Name}
+	// Nodes whose device class has not been benchmarked yet get no
+	// throughput credit.
+	n.TokensPerSecond = s.tokensPerSecond(pod.Namespace, pod.Labels[benchmarker.LabelModelDeployment], node)
+	n.Utilization, _ = strconv.ParseFloat(node.Annotations["flexinfer.ai/gpu.util"], 64)
+	n.Cost, _ = strconv.ParseFloat(node.Annotations["flexinfer.ai/cost"], 64)
+	if total := gpuVRAM(node) * int64(gpuCount(node)); total > 0 {
+		n.Allocated = float64(s.cache.Usage(node.Name).VRAMBytes) / float64(total) * 100
+	}
+	if model := modelPolicy(pod); model != nil {
+		if class, ok := deviceclass.FromLabels(node.Labels); ok {
+			for i, preferred := range model.PreferredDeviceClasses {
+				if preferred == class.String() {
+					n.DeviceClassPreference = float64(len(model.PreferredDeviceClasses) - i)
+					break
+				}
+			}
+		}
+	}
+	// Tensor-parallel shards exchange activations on every layer, so nodes
+	// whose free GPUs are joined by NVLink or one PCIe switch serve them
+	// faster. This only picks the node; the device plugin picks its GPUs.
+	if req := s.requirementsFor(context.Background(), pod); req.tensorParallel > 1 {
+		if link, ok := interconnect(node, req.tensorParallel); ok {
+			n.Interconnect, n.Topology = link.String(), float64(link)
+		}
+	}
+	return n
+}
+
+// interconnect returns the slowest link within the best-connected group of
+// size GPUs that are not busy on node, going by the gpu.topology and
+// gpu.busy annotations the agent publishes. It returns false if the node
+// does not publish its topology or has too few free GPUs.
+func interconnect(node *corev1.Node, size int) (hwprobe.Link, bool) {
+	v, ok := node.Annotations["flexinfer.ai/gpu.topology"]
+	if !ok {
+		return hwprobe.LinkUnknown, false
+	}
+	topology, err := hwprobe.ParseTopology(v)
+	if err != nil {
+		log.Log.Error(err, "Ignoring invalid GPU topology", "node", node.Name)
+		return hwprobe.LinkUnknown, false
+	}
+	busy := map[int]bool{}
+	for _, gpu := range strings.Split(node.Annotations["flexinfer.ai/gpu.busy"], ",") {
+		if i, err := strconv.Atoi(strings.TrimSpace(gpu)); err == nil {
+			busy[i] = true
+		}
+	}
+	group, link := topology.BestGroup(size, busy)
+	return link, group != nil
+}
+
+// Normalize min/max-scales each factor across scores and sets their Score
+// to the mean weighted by pod's model's policy, or else by the policy for its
+// namespace.
+func (s *Scheduler) Normalize(pod *corev1.Pod, scores []*NodeScore) {
+	model := modelPolicy(pod)
+	// Spreading prefers idle nodes; packing prefers busy ones, filling the
+	// GPUs small models already share before starting on empty ones.
+	utilization := func(n *NodeScore) float64 { return -math.Max(n.Utilization, n.Allocated) }
+	if model != nil && model.Placement == aiv1alpha1.PlacementPack {
+		utilization = func(n *NodeScore) float64 { return math.Max(n.Utilization, n.Allocated) }
+	}
+
+	normalize(scores, func(n *NodeScore) float64 { return n.TokensPerSecond },
+		func(n *NodeScore, v float64) { n.TokensPerSecondScore = v })
+	normalize(scores, utilization,
+		func(n *NodeScore, v float64) { n.UtilizationScore = v })
+	normalize(scores, func(n *NodeScore) float64 { return -n.Cost },
+		func(n *NodeScore, v float64) { n.CostScore = v })
+	normalize(scores, func(n *NodeScore) float64 { return n.DeviceClassPreference },
+		func(n *NodeScore, v float64) { n.DeviceClassScore = v })
+	normalize(scores, func(n *NodeScore) float64 { return n.Topology },
+		func(n *NodeScore, v float64) { n.TopologyScore = v })
+
+	w := s.weightsFor(pod, model, s.requirementsFor(context.Background(), pod).tensorParallel > 1)
+	total := w.total()
+	for _, n := range scores {
+		if total > 0 {
+			n.Score = (n.TokensPerSecondScore*w.TokensPerSecond + n.UtilizationScore*w.Utilization +
+				n.CostScore*w.Cost + n.DeviceClassScore*w.DeviceClass + n.TopologyScore*w.Topology) / total
+		}
+		n.ExtenderScore = int64(math.Round(n.Score * float64(extenderv1.MaxExtenderPriority) / MaxScore))
+	}
+}
+
+// normalize min/max-scales the factor get returns across nodes to
+// 0–MaxScore, higher being better, and stores it with set. A factor that is
+// the same on every node does not tell them apart and scores MaxScore.
+func normalize(nodes []*NodeScore, get func(*NodeScore) float64, set func(*NodeScore, float64)) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, n := range nodes {
+		lo, hi = math.Min(lo, get(n)), math.Max(hi, get(n))
+	}
+	for _, n := range nodes {
+		if hi == lo {
+			set(n, MaxScore)
+			continue
+		}
+		set(n, (get(n)-lo)/(hi-lo)*MaxScore)
+	}
+}
+
+// scoreHistory keeps the most recent score breakdown of each pod.
+type scoreHistory struct {
+	mu     sync.Mutex
+	scores map[string][]NodeScore
+	order  []string
+}
+
+// record stores the breakdown for pod, evicting the oldest pod when full.
+func (h *scoreHistory) record(pod string, scores []NodeScore) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.scores == nil {
+		h.scores = map[string][]NodeScore{}
+	}
+	if _, ok := h.scores[pod]; !ok {
+		h.order = append(h.order, pod)
+		if len(h.order) > maxScoreHistory {
+			delete(h.scores, h.order[0])
+			h.order = h.order[1:]
+		}
+	}
+	h.scores[pod] = scores
+}
+
+// get returns the breakdown for pod, or for every pod if pod is empty.
+func (h *scoreHistory) get(pod string) (map[string][]NodeScore, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if pod == "" {
+		all := make(map[string][]NodeScore, len(h.scores))
+		for k, v := range h.scores {
+			all[k] = v
+		}
+		return all, true
+	}
+	scores, ok := h.scores[pod]
+	return map[string][]NodeScore{pod: scores}, ok
+}
+
+// DebugScores is the handler for the /debug/scores endpoint. It returns the
+// per-factor breakdown of the last scoring of the pod named by the
+// pod=<namespace>/<name> query parameter, or of every recently scored pod.
+func (s *Scheduler) DebugScores(w http.ResponseWriter, r *http.Request) {
+	scores, ok := s.history.get(r.URL.Query().Get("pod"))
+	if !ok {
+		http.Error(w, "Pod has not been scored", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(scores); err != nil {
+		log.FromContext(r.Context()).Error(err, "Failed to encode response")
+	}
+}